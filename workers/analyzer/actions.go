@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Action walks stop here so cyclic /Next chains or huge name trees cannot stall analysis
+const maxActions = 1000
+
+// Name tree nodes visited per tree. Values carry no object reference to mark
+// as seen, so a /Kids entry pointing back at its own node is only stopped here.
+const maxNameTreeNodes = 4 * maxActions
+
+// An action the document runs on open, on a page event or from an annotation
+type DocumentAction struct {
+	Type    string `json:"type"`
+	Trigger string `json:"trigger"`
+	Page    int    `json:"page,omitempty"`
+
+	// URI or file the action points at, for actions that leave the document
+	Target string `json:"target,omitempty"`
+}
+
+// Collects the actions reachable from the catalog and from every page
+type actionScanner struct {
+	actions []DocumentAction
+}
+
+// Open actions, document level additional actions and named JavaScript
+func (as *actionScanner) addCatalog(catalog pdf.Value) {
+	as.addAction(catalog.Key("OpenAction"), "OpenAction", 0)
+	as.addAdditionalActions(catalog.Key("AA"), "catalog", 0)
+
+	// Named scripts run when the document opens
+	names := catalog.Key("Names").Key("JavaScript")
+	for _, script := range nameTreeValues(names) {
+		as.addAction(script, "Names/JavaScript", 0)
+	}
+}
+
+// Page event actions and actions attached to the page's annotations
+func (as *actionScanner) addPage(page pdf.Page, pageNumber int) {
+	as.addAdditionalActions(page.V.Key("AA"), "page", pageNumber)
+
+	annots := page.V.Key("Annots")
+	for i := 0; i < annots.Len() && len(as.actions) < maxActions; i++ {
+		annot := annots.Index(i)
+		trigger := fmt.Sprintf("annotation/%s", annot.Key("Subtype").Name())
+		as.addAction(annot.Key("A"), trigger, pageNumber)
+		as.addAdditionalActions(annot.Key("AA"), trigger, pageNumber)
+	}
+}
+
+// Additional actions map event names to actions
+func (as *actionScanner) addAdditionalActions(aa pdf.Value, trigger string, pageNumber int) {
+	for _, event := range aa.Keys() {
+		as.addAction(aa.Key(event), trigger+"/AA/"+event, pageNumber)
+	}
+}
+
+// Record an action and everything chained after it through /Next
+func (as *actionScanner) addAction(action pdf.Value, trigger string, pageNumber int) {
+	queue := []pdf.Value{action}
+	for len(queue) > 0 && len(as.actions) < maxActions {
+		action, queue = queue[0], queue[1:]
+
+		// OpenAction may be a destination array rather than an action
+		actionType := action.Key("S").Name()
+		if action.Kind() != pdf.Dict || actionType == "" {
+			continue
+		}
+		as.actions = append(as.actions, DocumentAction{
+			Type:    actionType,
+			Trigger: trigger,
+			Page:    pageNumber,
+			Target:  actionTarget(action),
+		})
+
+		next := action.Key("Next")
+		if next.Kind() == pdf.Array {
+			for i := 0; i < next.Len(); i++ {
+				queue = append(queue, next.Index(i))
+			}
+		} else if !next.IsNull() {
+			queue = append(queue, next)
+		}
+	}
+}
+
+// URI or file an action opens, submits to or launches
+func actionTarget(action pdf.Value) string {
+	switch action.Key("S").Name() {
+	case "URI":
+		return action.Key("URI").RawString()
+	case "Launch":
+		// Windows launch parameters carry the file and its arguments separately
+		if target := fileSpecName(action.Key("F")); target != "" {
+			return target
+		}
+		return fileSpecName(action.Key("Win").Key("F"))
+	case "GoToR", "GoToE", "SubmitForm", "ImportData":
+		return fileSpecName(action.Key("F"))
+	}
+	return ""
+}
+
+// A file specification is either a plain string or a dictionary of names
+func fileSpecName(spec pdf.Value) string {
+	switch spec.Kind() {
+	case pdf.String:
+		return spec.Text()
+	case pdf.Dict:
+		for _, key := range []string{"UF", "F", "Unix", "DOS", "Mac"} {
+			if name := spec.Key(key).Text(); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Append actions found by another scanner, such as one that read a single page
+func (as *actionScanner) merge(other *actionScanner) {
+	for _, action := range other.actions {
+		if len(as.actions) >= maxActions {
+			return
+		}
+		as.actions = append(as.actions, action)
+	}
+}
+
+// Actions of a given type, e.g. JavaScript or Launch
+func (as *actionScanner) ofType(actionType string) []DocumentAction {
+	var matches []DocumentAction
+	for _, action := range as.actions {
+		if action.Type == actionType {
+			matches = append(matches, action)
+		}
+	}
+	return matches
+}
+
+// Values of a name tree, bounded by maxActions values and maxNameTreeNodes nodes
+func nameTreeValues(tree pdf.Value) []pdf.Value {
+	var values []pdf.Value
+	queue := []pdf.Value{tree}
+	for visited := 0; len(queue) > 0 && len(values) < maxActions && visited < maxNameTreeNodes; visited++ {
+		node := queue[0]
+		queue = queue[1:]
+
+		// Names alternate key, value
+		names := node.Key("Names")
+		for i := 1; i < names.Len(); i += 2 {
+			values = append(values, names.Index(i))
+		}
+
+		kids := node.Key("Kids")
+		for i := 0; i < kids.Len() && len(queue) < maxActions; i++ {
+			queue = append(queue, kids.Index(i))
+		}
+	}
+	return values
+}
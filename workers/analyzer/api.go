@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAPIAddr          = "127.0.0.1:8080"
+	defaultAPIMaxUploadSize = 100 * 1024 * 1024
+	defaultAPIMaxConcurrent = 4
+)
+
+// Form fields other than the file are small, anything longer is refused
+const maxAPIFieldBytes = 4096
+
+// How long in-flight requests get to finish on shutdown
+const apiShutdownTimeout = 30 * time.Second
+
+// Synchronous analysis over HTTP, next to or instead of the Hatchet worker
+type APIConfig struct {
+	Addr          string
+	MaxUploadSize int64 // request body bytes, uploads included
+	MaxConcurrent int   // analyses at once, further requests get 503
+}
+
+func DefaultAPIConfig() APIConfig {
+	return APIConfig{
+		Addr:          defaultAPIAddr,
+		MaxUploadSize: defaultAPIMaxUploadSize,
+		MaxConcurrent: defaultAPIMaxConcurrent,
+	}
+}
+
+// API settings from ANALYZER_API_ADDR, ANALYZER_API_MAX_UPLOAD_SIZE and ANALYZER_API_MAX_CONCURRENT
+func APIConfigFromEnv() APIConfig {
+	config := DefaultAPIConfig()
+	if addr := os.Getenv("ANALYZER_API_ADDR"); addr != "" {
+		config.Addr = addr
+	}
+	config.MaxUploadSize = envInt64("ANALYZER_API_MAX_UPLOAD_SIZE", config.MaxUploadSize)
+	config.MaxConcurrent = envInt("ANALYZER_API_MAX_CONCURRENT", config.MaxConcurrent)
+	return config
+}
+
+// Serves PDFAnalyzer over HTTP. There is no authentication, the default address
+// only listens on localhost; put it behind an authenticating proxy before
+// binding it to other interfaces.
+//
+//	POST /v1/analyze  multipart upload with a "file" part, or a JSON DocumentInput
+//	                  naming a stored document; responds with an AnalysisResult
+//	                  under a document ID the server assigns
+//	GET  /healthz     the process is up
+//	GET  /readyz      the server accepts analyses, fails while shutting down
+type APIServer struct {
+	analyzer *PDFAnalyzer
+	config   APIConfig
+	logger   *logrus.Logger
+	server   *http.Server
+
+	slots    chan struct{}
+	draining atomic.Bool
+}
+
+func NewAPIServer(analyzer *PDFAnalyzer, config APIConfig) *APIServer {
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = defaultAPIMaxConcurrent
+	}
+	api := &APIServer{
+		// API uploads are outside the upload workflow. Recording them would flag
+		// the same bytes arriving later on document:uploaded as their duplicate,
+		// and duplicates are neither fanned out nor sent to the pipeline.
+		analyzer: analyzer.withDuplicateLookupOnly(),
+		config:   config,
+		logger:   analyzer.logger,
+		slots:    make(chan struct{}, config.MaxConcurrent),
+	}
+	api.server = &http.Server{
+		Addr:              config.Addr,
+		Handler:           api.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return api
+}
+
+func (api *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/analyze", api.handleAnalyze)
+	mux.HandleFunc("/healthz", api.handleHealth)
+	mux.HandleFunc("/readyz", api.handleReady)
+	return mux
+}
+
+// Serve until Shutdown, returning nil once shut down
+func (api *APIServer) ListenAndServe() error {
+	api.logger.WithField("addr", api.config.Addr).Info("HTTP API listening")
+	if err := api.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Fail readiness, then let in-flight analyses finish
+func (api *APIServer) Shutdown(ctx context.Context) error {
+	api.draining.Store(true)
+	return api.server.Shutdown(ctx)
+}
+
+// Status body for the health endpoints
+type apiStatus struct {
+	Status   string `json:"status"`
+	InFlight int    `json:"in_flight"`
+	Capacity int    `json:"capacity"`
+}
+
+func (api *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiStatus{Status: "ok", InFlight: len(api.slots), Capacity: cap(api.slots)})
+}
+
+func (api *APIServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if api.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, apiStatus{Status: "draining", InFlight: len(api.slots), Capacity: cap(api.slots)})
+		return
+	}
+	writeJSON(w, http.StatusOK, apiStatus{Status: "ready", InFlight: len(api.slots), Capacity: cap(api.slots)})
+}
+
+func (api *APIServer) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "method-not-allowed", "use POST")
+		return
+	}
+
+	// The body is read before taking a slot, a slow client must not hold one
+	input, upload, ok := api.readAnalyzeRequest(w, r)
+	if !ok {
+		return
+	}
+	if upload != nil {
+		defer upload.Close()
+	}
+
+	// Refuse rather than queue, callers retry with backoff
+	select {
+	case api.slots <- struct{}{}:
+		defer func() { <-api.slots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, http.StatusServiceUnavailable, "busy", "all analysis slots are in use")
+		return
+	}
+
+	var (
+		result *AnalysisResult
+		err    error
+	)
+	if upload != nil {
+		result, err = api.analyzer.analyzeObject(r.Context(), input, upload, time.Now())
+	} else {
+		result, err = api.analyzer.AnalyzeDocument(r.Context(), input)
+	}
+
+	if err != nil {
+		status := apiErrorStatus(err)
+		api.logger.WithError(err).WithFields(logrus.Fields{
+			"document_id": input.ID,
+			"status":      status,
+		}).Warn("API analysis failed")
+
+		// Permanent failures read like the worker's reject, others are safe to retry
+		result = &AnalysisResult{DocumentID: input.ID, Pages: []PageReport{}, Error: newAnalysisError(err)}
+		if IsPermanentError(err) {
+			result = rejectedResult(input, err)
+		}
+		writeJSON(w, status, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Decode the request body within MaxUploadSize into the document to analyze,
+// with the spooled file for uploads. Document IDs are always assigned here:
+// artifacts are written under the ID, a caller's choice could overwrite those
+// of another document. Writes the error response and returns false when the
+// request is unusable.
+func (api *APIServer) readAnalyzeRequest(w http.ResponseWriter, r *http.Request) (DocumentInput, *tempObject, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, api.config.MaxUploadSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		input, upload, err := api.readUpload(r)
+		if err != nil {
+			api.writeRequestError(w, err)
+			return input, nil, false
+		}
+		return input, upload, true
+	case "application/json":
+		var input DocumentInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			api.writeRequestError(w, fmt.Errorf("invalid JSON body: %w", err))
+			return input, nil, false
+		}
+		if input.FilePath == "" && input.ID == "" {
+			writeAPIError(w, http.StatusBadRequest, "bad-request", "file_path or id is required")
+			return input, nil, false
+		}
+		// An id alone names the stored document, as for the worker
+		input.FilePath = documentLocation(input)
+		input.ID = "api-" + randomHex(8)
+		return input, nil, true
+	default:
+		writeAPIError(w, http.StatusUnsupportedMediaType, "unsupported-media-type", "send multipart/form-data or application/json")
+		return DocumentInput{}, nil, false
+	}
+}
+
+// Spool the "file" part to a temp file; other parts set DocumentInput fields
+func (api *APIServer) readUpload(r *http.Request) (DocumentInput, *tempObject, error) {
+	input := DocumentInput{}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return input, nil, err
+	}
+
+	var upload *tempObject
+	fail := func(err error) (DocumentInput, *tempObject, error) {
+		if upload != nil {
+			upload.Close()
+		}
+		return input, nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if part.FormName() == "file" {
+			if upload != nil {
+				return fail(errors.New("only one file part is accepted"))
+			}
+			if upload, err = spoolUpload(part); err != nil {
+				return fail(err)
+			}
+			input.FilePath = part.FileName()
+			continue
+		}
+
+		value, err := readFormField(part)
+		if err != nil {
+			return fail(err)
+		}
+		if err := setUploadField(&input, part.FormName(), value); err != nil {
+			return fail(err)
+		}
+	}
+
+	if upload == nil {
+		return fail(errors.New(`multipart body has no "file" part`))
+	}
+	input.ID = "upload-" + randomHex(8)
+	return input, upload, nil
+}
+
+// Random access over the upload without holding it in memory
+func spoolUpload(part *multipart.Part) (*tempObject, error) {
+	tmp, err := os.CreateTemp("", "analyzer-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(tmp, part)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &tempObject{localObject: localObject{File: tmp, size: size}}, nil
+}
+
+func readFormField(part *multipart.Part) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxAPIFieldBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxAPIFieldBytes {
+		return "", fmt.Errorf("form field %q is too long", part.FormName())
+	}
+	return string(value), nil
+}
+
+// Form fields mirror the DocumentInput JSON names, the ID is never taken from them
+func setUploadField(input *DocumentInput, name, value string) error {
+	flags := map[string]*bool{
+		"extract_text":      &input.ExtractText,
+		"extract_positions": &input.ExtractPositions,
+		"detect_tables":     &input.DetectTables,
+		"extract_images":    &input.ExtractImages,
+		"detect_pii":        &input.DetectPII,
+	}
+
+	switch name {
+	case "tenant_id":
+		input.TenantID = value
+	default:
+		flag, ok := flags[name]
+		if !ok {
+			return nil
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("form field %q must be true or false", name)
+		}
+		*flag = parsed
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Problems with the request itself, before any analysis ran
+func (api *APIServer) writeRequestError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "limit-exceeded",
+			fmt.Sprintf("request body is over the %d byte limit", tooLarge.Limit))
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, "bad-request", err.Error())
+}
+
+// HTTP status for an analysis failure
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrPathTraversal), errors.Is(err, ErrUnsupportedLocation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	case IsPermanentError(err):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// Request errors use the same "error" object as AnalysisResult
+func writeAPIError(w http.ResponseWriter, status int, kind, message string) {
+	writeJSON(w, status, struct {
+		Error *AnalysisError `json:"error"`
+	}{&AnalysisError{Kind: kind, Message: message, Retryable: status == http.StatusServiceUnavailable}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// analyzer serve, the HTTP API without the Hatchet worker
+func runServe(args []string, stderr io.Writer) int {
+	config := APIConfigFromEnv()
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&config.Addr, "addr", config.Addr, "listen address; the API has no authentication")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.InfoLevel)
+
+	analyzer, closeAnalyzer := newAnalyzerFromEnv(logger)
+	defer closeAnalyzer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	api := NewAPIServer(analyzer, config)
+	errs := make(chan error, 1)
+	go func() { errs <- api.ListenAndServe() }()
+
+	select {
+	case err := <-errs:
+		logger.WithError(err).Error("HTTP API stopped")
+		return exitFailed
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down HTTP API...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	if err := api.Shutdown(shutdownCtx); err != nil {
+		logger.WithError(err).Error("Error during HTTP API shutdown")
+	}
+	return exitOK
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// API over testdata taking one analysis at a time
+func newTestAPI(t *testing.T, maxUploadSize int64) *APIServer {
+	t.Helper()
+	return NewAPIServer(newTestAnalyzer(t), APIConfig{MaxUploadSize: maxUploadSize, MaxConcurrent: 1})
+}
+
+// Multipart body with a "file" part holding data and the given form fields
+func multipartBody(t *testing.T, data []byte, fields map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, err := form.CreateFormFile("file", "upload.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, form.FormDataContentType()
+}
+
+// Serve one request, decoding the JSON response into an AnalysisResult
+func serveAPI(t *testing.T, api *APIServer, method, contentType string, body *bytes.Buffer) (*httptest.ResponseRecorder, AnalysisResult) {
+	t.Helper()
+
+	if body == nil {
+		body = &bytes.Buffer{}
+	}
+	req := httptest.NewRequest(method, "/v1/analyze", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, req)
+
+	var result AnalysisResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, rec.Body)
+	}
+	return rec, result
+}
+
+func TestAPIAnalyze(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newTestAPI(t, 1<<20)
+
+	t.Run("upload", func(t *testing.T) {
+		// A caller's id is not used, artifacts would be written under it
+		body, contentType := multipartBody(t, sample, map[string]string{"id": "someone-else", "tenant_id": "acme", "detect_pii": "true"})
+		rec, result := serveAPI(t, api, http.MethodPost, contentType, body)
+		if rec.Code != http.StatusOK || result.PageCount != 1 || result.Entities == nil {
+			t.Fatalf("status %d, result %+v", rec.Code, result)
+		}
+		if !strings.HasPrefix(result.DocumentID, "upload-") {
+			t.Errorf("DocumentID = %q, want one assigned by the server", result.DocumentID)
+		}
+	})
+
+	t.Run("stored document", func(t *testing.T) {
+		for _, request := range []string{`{"file_path": "sample.pdf"}`, `{"id": "sample"}`} {
+			rec, result := serveAPI(t, api, http.MethodPost, "application/json", bytes.NewBufferString(request))
+			if rec.Code != http.StatusOK || result.PageCount != 1 {
+				t.Fatalf("%s: status %d, result %+v", request, rec.Code, result)
+			}
+			if !strings.HasPrefix(result.DocumentID, "api-") {
+				t.Errorf("%s: DocumentID = %q, want one assigned by the server", request, result.DocumentID)
+			}
+		}
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+		kind        string
+	}{
+		{"not a post", http.MethodGet, "application/json", "", http.StatusMethodNotAllowed, "method-not-allowed"},
+		{"unsupported media type", http.MethodPost, "text/plain", "sample.pdf", http.StatusUnsupportedMediaType, "unsupported-media-type"},
+		{"invalid json", http.MethodPost, "application/json", "{", http.StatusBadRequest, "bad-request"},
+		{"no document named", http.MethodPost, "application/json", `{"tenant_id": "acme"}`, http.StatusBadRequest, "bad-request"},
+		{"missing document", http.MethodPost, "application/json", `{"file_path": "missing.pdf"}`, http.StatusNotFound, "not-found"},
+		{"path outside the root", http.MethodPost, "application/json", `{"file_path": "../api.go"}`, http.StatusBadRequest, "path-traversal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, result := serveAPI(t, api, tt.method, tt.contentType, bytes.NewBufferString(tt.body))
+			if rec.Code != tt.status || result.Error == nil || result.Error.Kind != tt.kind {
+				t.Errorf("status %d, error %+v, want %d %s", rec.Code, result.Error, tt.status, tt.kind)
+			}
+		})
+	}
+}
+
+func TestAPIRejectsUnsupportedUpload(t *testing.T) {
+	body, contentType := multipartBody(t, []byte{0x00, 0x01, 0x02, 0x03}, nil)
+	rec, result := serveAPI(t, newTestAPI(t, 1<<20), http.MethodPost, contentType, body)
+
+	// Permanent failures read like the worker's reject and are not retried
+	if rec.Code != http.StatusUnprocessableEntity || result.Error == nil || result.Error.Kind != "unsupported-format" || result.Error.Retryable {
+		t.Errorf("status %d, error %+v", rec.Code, result.Error)
+	}
+}
+
+func TestAPIBodyLimit(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newTestAPI(t, 1024)
+
+	upload, contentType := multipartBody(t, sample, nil)
+	long := bytes.NewBufferString(`{"file_path": "sample.pdf", "tenant_id": "` + strings.Repeat("a", 1024) + `"}`)
+	for name, body := range map[string]struct {
+		contentType string
+		data        *bytes.Buffer
+	}{
+		"upload":    {contentType, upload},
+		"json body": {"application/json", long},
+	} {
+		rec, result := serveAPI(t, api, http.MethodPost, body.contentType, body.data)
+		if rec.Code != http.StatusRequestEntityTooLarge || result.Error == nil || result.Error.Kind != "limit-exceeded" {
+			t.Errorf("%s: status %d, error %+v", name, rec.Code, result.Error)
+		}
+	}
+
+	// Uploads within the limit are still analyzed against the document limits
+	limited := NewAPIServer(newTestAnalyzer(t, WithResourceLimits(ResourceLimits{MaxFileSize: 1000})), APIConfig{MaxUploadSize: 1 << 20})
+	body, contentType := multipartBody(t, sample, nil)
+	if rec, result := serveAPI(t, limited, http.MethodPost, contentType, body); rec.Code != http.StatusRequestEntityTooLarge || result.Error == nil {
+		t.Errorf("document over MaxFileSize: status %d, error %+v", rec.Code, result.Error)
+	}
+}
+
+func TestAPIBusy(t *testing.T) {
+	api := newTestAPI(t, 1024)
+	api.slots <- struct{}{}
+
+	rec, result := serveAPI(t, api, http.MethodPost, "application/json", bytes.NewBufferString(`{"file_path": "sample.pdf"}`))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" || result.Error == nil || !result.Error.Retryable {
+		t.Errorf("status %d, Retry-After %q, error %+v", rec.Code, rec.Header().Get("Retry-After"), result.Error)
+	}
+
+	// Bodies are read before a slot is taken, bad requests are refused as such
+	for body, status := range map[string]int{
+		"{":                       http.StatusBadRequest,
+		strings.Repeat(" ", 2048): http.StatusRequestEntityTooLarge,
+	} {
+		if rec, _ := serveAPI(t, api, http.MethodPost, "application/json", bytes.NewBufferString(body)); rec.Code != status {
+			t.Errorf("status %d while busy, want %d", rec.Code, status)
+		}
+	}
+
+	// The slot is given back once the analysis is done
+	<-api.slots
+	if rec, _ := serveAPI(t, api, http.MethodPost, "application/json", bytes.NewBufferString(`{"file_path": "sample.pdf"}`)); rec.Code != http.StatusOK || len(api.slots) != 0 {
+		t.Errorf("status %d, %d slots in use", rec.Code, len(api.slots))
+	}
+}
+
+func TestAPIHealth(t *testing.T) {
+	api := newTestAPI(t, 1024)
+	check := func(path string, status int, want string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var body apiStatus
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != status || body.Status != want || body.Capacity != 1 {
+			t.Errorf("%s: status %d, %+v, want %d %s", path, rec.Code, body, status, want)
+		}
+	}
+
+	check("/healthz", http.StatusOK, "ok")
+	check("/readyz", http.StatusOK, "ready")
+
+	// Draining fails readiness while the process stays healthy
+	api.draining.Store(true)
+	check("/readyz", http.StatusServiceUnavailable, "draining")
+	check("/healthz", http.StatusOK, "ok")
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Bump whenever analysis output changes, cached results from older versions are dropped
+const analyzerVersion = "1"
+
+const defaultCacheSize = 128
+
+// In-memory LRU of analysis results, optionally backed by a directory of JSON files
+type ResultCacheConfig struct {
+	Enabled bool
+	Size    int
+	Dir     string
+}
+
+func DefaultResultCacheConfig() ResultCacheConfig {
+	return ResultCacheConfig{Enabled: true, Size: defaultCacheSize}
+}
+
+// Cache settings from ANALYZER_CACHE_DISABLED, ANALYZER_CACHE_SIZE and ANALYZER_CACHE_DIR
+func ResultCacheConfigFromEnv() ResultCacheConfig {
+	config := DefaultResultCacheConfig()
+	config.Enabled = os.Getenv("ANALYZER_CACHE_DISABLED") != "true"
+	config.Size = envInt("ANALYZER_CACHE_SIZE", config.Size)
+	config.Dir = os.Getenv("ANALYZER_CACHE_DIR")
+	return config
+}
+
+// Hit and miss counters since the worker started
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	DiskHits  int64 `json:"disk_hits"`
+	Misses    int64 `json:"misses"`
+	Stores    int64 `json:"stores"`
+	Evictions int64 `json:"evictions"`
+}
+
+// Share of lookups served from the cache
+func (cs CacheStats) HitRatio() float64 {
+	total := cs.Hits + cs.Misses
+	if total == 0 {
+		return 0
+	}
+	return roundTo(float64(cs.Hits)/float64(total), 3)
+}
+
+// Analysis results keyed by content hash and everything else the result depends on
+type ResultCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+
+	root  string // configured disk directory, empty for memory only
+	dir   string // root/<scope> holding entries for the current config
+	scope string
+
+	hits, diskHits, misses, stores, evictions atomic.Int64
+}
+
+// Entries hold the encoded result, every hit decodes its own copy so callers
+// can change what they get back without touching the cache
+type cacheEntry struct {
+	key  string
+	data []byte
+}
+
+// Cache from the environment, nil when caching is disabled
+func ResultCacheFromEnv() (*ResultCache, error) {
+	config := ResultCacheConfigFromEnv()
+	if !config.Enabled {
+		return nil, nil
+	}
+	return NewResultCache(config)
+}
+
+func NewResultCache(config ResultCacheConfig) (*ResultCache, error) {
+	if config.Size <= 0 {
+		config.Size = defaultCacheSize
+	}
+	cache := &ResultCache{
+		size:    config.Size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		root:    config.Dir,
+	}
+	if cache.root != "" {
+		if err := os.MkdirAll(cache.root, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	return cache, nil
+}
+
+// Tie the cache to an analyzer version and config. Entries in memory under any
+// other scope are dropped; on disk only scopes of older analyzer versions are
+// removed, workers of the same or a newer version may share the directory.
+func (rc *ResultCache) bind(scope string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.scope == scope {
+		return nil
+	}
+	rc.scope = scope
+	rc.entries = make(map[string]*list.Element)
+	rc.order.Init()
+
+	if rc.root == "" {
+		return nil
+	}
+	rc.dir = filepath.Join(rc.root, scope)
+	if err := os.MkdirAll(rc.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Only remove directories this cache created, the root may hold other files
+	scopes, err := filepath.Glob(filepath.Join(rc.root, "scope-*"))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, dir := range scopes {
+		if staleScope(filepath.Base(dir)) {
+			errs = append(errs, os.RemoveAll(dir))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Scopes are named scope-v<version>-<config hash>; one written by an older
+// analyzer version, or before versions were part of the name, is never read again
+func staleScope(name string) bool {
+	rest, versioned := strings.CutPrefix(name, "scope-v")
+	version, _, _ := strings.Cut(rest, "-")
+	scopeVersion, err := strconv.Atoi(version)
+	if !versioned || err != nil {
+		return true
+	}
+	current, _ := strconv.Atoi(analyzerVersion)
+	return scopeVersion < current
+}
+
+// Cached result for a key, from memory or disk
+func (rc *ResultCache) Get(key string) (*AnalysisResult, bool) {
+	rc.mu.Lock()
+	var data []byte
+	if element, ok := rc.entries[key]; ok {
+		rc.order.MoveToFront(element)
+		data = element.Value.(*cacheEntry).data
+	}
+	dir := rc.dir
+	rc.mu.Unlock()
+
+	if result, ok := decodeCachedResult(data); ok {
+		rc.hits.Add(1)
+		return result, true
+	}
+
+	if dir != "" {
+		if data, result, ok := readCachedResult(filepath.Join(dir, key+".json")); ok {
+			rc.remember(key, data)
+			rc.hits.Add(1)
+			rc.diskHits.Add(1)
+			return result, true
+		}
+	}
+
+	rc.misses.Add(1)
+	return nil, false
+}
+
+// Store a result in memory and, when configured, on disk. The result is
+// encoded now, later changes to it are not cached.
+func (rc *ResultCache) Put(key string, result *AnalysisResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	rc.remember(key, data)
+	rc.stores.Add(1)
+
+	rc.mu.Lock()
+	dir := rc.dir
+	rc.mu.Unlock()
+	if dir == "" {
+		return nil
+	}
+	return writeCachedResult(filepath.Join(dir, key+".json"), data)
+}
+
+func (rc *ResultCache) remember(key string, data []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if element, ok := rc.entries[key]; ok {
+		element.Value.(*cacheEntry).data = data
+		rc.order.MoveToFront(element)
+		return
+	}
+	rc.entries[key] = rc.order.PushFront(&cacheEntry{key: key, data: data})
+
+	for rc.order.Len() > rc.size {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cacheEntry).key)
+		rc.evictions.Add(1)
+	}
+}
+
+func (rc *ResultCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:      rc.hits.Load(),
+		DiskHits:  rc.diskHits.Load(),
+		Misses:    rc.misses.Load(),
+		Stores:    rc.stores.Load(),
+		Evictions: rc.evictions.Load(),
+	}
+}
+
+// A missing or unreadable file is a miss, the entry is rewritten on the next store
+func readCachedResult(path string) ([]byte, *AnalysisResult, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, false
+	}
+	result, ok := decodeCachedResult(data)
+	return data, result, ok
+}
+
+func decodeCachedResult(data []byte) (*AnalysisResult, bool) {
+	if data == nil {
+		return nil, false
+	}
+	result := &AnalysisResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// Write through a temp file so readers never see a partial entry
+func writeCachedResult(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Scope of cached results: the analyzer version and every config that shapes
+// a result, so editing pricing, routing or the resource limits a document is
+// refused under invalidates the cache
+func (pa *PDFAnalyzer) cacheScope() string {
+	config, _ := json.Marshal(struct {
+		Pricing    *PricingConfig     `json:"pricing"`
+		Routing    *RoutingPolicy     `json:"routing"`
+		Chunks     ChunkPlannerConfig `json:"chunks"`
+		Extraction ExtractionConfig   `json:"extraction"`
+		Limits     ResourceLimits     `json:"limits"`
+	}{pa.pricing, pa.routing, pa.chunks, pa.extraction, pa.limits})
+
+	sum := sha256.Sum256(config)
+	return "scope-v" + analyzerVersion + "-" + hex.EncodeToString(sum[:8])
+}
+
+// Key for a document's result: its bytes plus the request options that change
+// the result. The document ID is left out, hits are re-labelled with the new ID.
+func cacheKey(contentHash string, input DocumentInput) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%t|%t", contentHash, input.TenantID, input.DetectTables, input.DetectPII)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Results that wrote artifacts under the document's ID cannot be reused for another ID
+func (pa *PDFAnalyzer) cacheable(input DocumentInput) bool {
+	return pa.cache != nil &&
+		!pa.extraction.Enabled && !input.ExtractText &&
+		!pa.extraction.ExtractImages && !input.ExtractImages
+}
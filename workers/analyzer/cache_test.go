@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestResultCacheLRU(t *testing.T) {
+	cache, err := NewResultCache(ResultCacheConfig{Enabled: true, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Put(key, &AnalysisResult{DocumentID: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading a makes b the least recently used
+	a, ok := cache.Get("a")
+	if !ok || a.DocumentID != "a" {
+		t.Fatalf("Get(a) = %+v, %v", a, ok)
+	}
+	a.DocumentID = "changed"
+	cache.Put("c", &AnalysisResult{DocumentID: "c"})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used entry kept")
+	}
+	if a, _ := cache.Get("a"); a == nil || a.DocumentID != "a" {
+		t.Errorf("cached entry is %+v, changes to a hit leaked into it", a)
+	}
+	want := CacheStats{Hits: 2, Misses: 1, Stores: 3, Evictions: 1}
+	if stats := cache.Stats(); stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestResultCacheDisk(t *testing.T) {
+	root := t.TempDir()
+	open := func() *ResultCache {
+		t.Helper()
+		cache, err := NewResultCache(ResultCacheConfig{Enabled: true, Dir: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.bind("scope-v1-test"); err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+
+	want := &AnalysisResult{DocumentID: "doc", PageCount: 3, EstimatedCost: 1.5, Metrics: ProcessingMetrics{IsTextBased: true, ImageCount: 2}}
+	if err := open().Put("key", want); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted worker finds the entry on disk
+	cache := open()
+	got, ok := cache.Get("key")
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get() = %+v, %v, want %+v", got, ok, want)
+	}
+	if stats := cache.Stats(); stats.DiskHits != 1 {
+		t.Errorf("Stats() = %+v, want one disk hit", stats)
+	}
+	// Once read it is served from memory
+	cache.Get("key")
+	if stats := cache.Stats(); stats.Hits != 2 || stats.DiskHits != 1 {
+		t.Errorf("Stats() = %+v after the second read", stats)
+	}
+
+	// A torn entry is a miss
+	if err := os.WriteFile(filepath.Join(root, "scope-v1-test", "torn.json"), []byte(`{"document_id":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := open().Get("torn"); ok {
+		t.Error("torn entry served")
+	}
+}
+
+func TestResultCacheScope(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"scope-0123abcd", "scope-v0-aaaa", "scope-v1-bbbb", "scope-v2-cccc", "notes"} {
+		if err := os.Mkdir(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err := NewResultCache(ResultCacheConfig{Enabled: true, Dir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := DefaultResourceLimits()
+	pa := newTestAnalyzer(t, WithResultCache(cache), WithResourceLimits(limits))
+	cache.Put("key", &AnalysisResult{DocumentID: "doc"})
+
+	// Scopes of older versions go, other configs of this version and newer versions stay
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	want := []string{"notes", pa.cacheScope(), "scope-v1-bbbb", "scope-v2-cccc"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("cache root holds %v, want %v", names, want)
+	}
+
+	// Tighter limits may refuse documents the cached results were produced
+	// for, so they start a new scope
+	limits.MaxPages = 10
+	other := newTestAnalyzer(t, WithResultCache(cache), WithResourceLimits(limits))
+	if other.cacheScope() == pa.cacheScope() {
+		t.Fatal("resource limits left out of the cache scope")
+	}
+	if _, ok := cache.Get("key"); ok {
+		t.Error("entry from the previous scope served from memory")
+	}
+	if _, err := os.Stat(filepath.Join(root, pa.cacheScope(), "key.json")); err != nil {
+		t.Errorf("entry of the previous scope removed from disk: %v", err)
+	}
+}
+
+func TestAnalyzeFromCache(t *testing.T) {
+	cache, err := NewResultCache(DefaultResultCacheConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pa := newTestAnalyzer(t, WithResultCache(cache))
+
+	first, err := pa.AnalyzeDocument(context.Background(), DocumentInput{ID: "first", FilePath: "sample.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pa.AnalyzeDocument(context.Background(), DocumentInput{ID: "second", FilePath: "sample.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Cached || !second.Cached || second.DocumentID != "second" || second.PageCount != first.PageCount {
+		t.Errorf("second analysis %+v, want the first result relabelled", second)
+	}
+
+	// Another tenant is priced on its own, and extracted artifacts belong to one ID
+	for _, input := range []DocumentInput{
+		{ID: "third", TenantID: "acme", FilePath: "sample.pdf"},
+		{ID: "fourth", FilePath: "sample.pdf", ExtractText: true},
+	} {
+		result, err := pa.AnalyzeDocument(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Cached {
+			t.Errorf("%s served from the cache", input.ID)
+		}
+	}
+}
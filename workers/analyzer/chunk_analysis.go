@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A chunk that does not fit the document, it fails the same way on every retry
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// Input for a child workflow that extracts one chunk of a document. The
+// extraction switches are those of the document input, so a chunk writes what
+// the analyze step would have written for its pages.
+type ChunkInput struct {
+	DocumentID string `json:"document_id"`
+	FilePath   string `json:"file_path"`
+	TenantID   string `json:"tenant_id,omitempty"`
+	Chunk      Chunk  `json:"chunk"`
+
+	ExtractText      bool `json:"extract_text,omitempty"`
+	ExtractPositions bool `json:"extract_positions,omitempty"`
+	DetectTables     bool `json:"detect_tables,omitempty"`
+	ExtractImages    bool `json:"extract_images,omitempty"`
+	DetectPII        bool `json:"detect_pii,omitempty"`
+}
+
+// Chunk input for a page range of a document
+func newChunkInput(input *DocumentInput, documentID string, chunk Chunk) *ChunkInput {
+	return &ChunkInput{
+		DocumentID:       documentID,
+		FilePath:         input.FilePath,
+		TenantID:         input.TenantID,
+		Chunk:            chunk,
+		ExtractText:      input.ExtractText,
+		ExtractPositions: input.ExtractPositions,
+		DetectTables:     input.DetectTables,
+		ExtractImages:    input.ExtractImages,
+		DetectPII:        input.DetectPII,
+	}
+}
+
+// The document input a chunk was spawned from
+func (ci ChunkInput) document() DocumentInput {
+	return DocumentInput{
+		ID:               ci.DocumentID,
+		FilePath:         ci.FilePath,
+		TenantID:         ci.TenantID,
+		ExtractText:      ci.ExtractText,
+		ExtractPositions: ci.ExtractPositions,
+		DetectTables:     ci.DetectTables,
+		ExtractImages:    ci.ExtractImages,
+		DetectPII:        ci.DetectPII,
+	}
+}
+
+// Output of a single chunk extraction
+type ChunkResult struct {
+	DocumentID     string `json:"document_id"`
+	ChunkIndex     int    `json:"chunk_index"`
+	StartPage      int    `json:"start_page"`
+	EndPage        int    `json:"end_page"`
+	PagesExtracted int    `json:"pages_extracted"`
+	TableCount     int    `json:"table_count"`
+	AnalysisTime   int64  `json:"analysis_time"`
+
+	Extraction      *ExtractionArtifact `json:"extraction,omitempty"`
+	Entities        *EntityReport       `json:"entities,omitempty"`
+	ImageExtraction *ImageExtraction    `json:"image_extraction,omitempty"`
+
+	// Set when a deadline cut the chunk short, the outputs cover only the pages read
+	Partial *PartialResult `json:"partial,omitempty"`
+
+	Error *AnalysisError `json:"error,omitempty"`
+}
+
+// Extract the pages of one chunk, the work the analyze step deferred for a
+// parallel document, under the same deadlines as a whole document
+func (pa *PDFAnalyzer) AnalyzeChunk(ctx context.Context, input ChunkInput) (result *ChunkResult, err error) {
+	startTime := time.Now()
+
+	pa.logger.WithFields(logrus.Fields{
+		"document_id": input.DocumentID,
+		"chunk_index": input.Chunk.Index,
+		"start_page":  input.Chunk.StartPage,
+		"end_page":    input.Chunk.EndPage,
+	}).Info("Starting chunk extraction")
+
+	document := input.document()
+	object, err := pa.storage.Open(ctx, documentLocation(document))
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	// Malformed objects deep in the file make the pdf package panic, other
+	// panics are analyzer bugs and are logged once recovered
+	defer func() { pa.logPanicStack(err) }()
+	defer recoverPDFPanic(&err)
+
+	// Chunks may be started directly, so the document limits are checked again
+	limits := newResourceGuard(pa.limits)
+	if err := limits.checkFileSize(object.Size()); err != nil {
+		return nil, err
+	}
+	if err := limits.checkObjects(object, object.Size()); err != nil {
+		return nil, err
+	}
+
+	reader, err := openPDFReader(object, object.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	pageCount := reader.NumPage()
+	if input.Chunk.StartPage < 1 || input.Chunk.EndPage > pageCount || input.Chunk.StartPage > input.Chunk.EndPage {
+		return nil, fmt.Errorf("%w: pages %d-%d out of range for %d page document",
+			ErrInvalidChunk, input.Chunk.StartPage, input.Chunk.EndPage, pageCount)
+	}
+
+	extractor, err := pa.newTextExtractor(ctx, document, chunkTextArtifactName(input.Chunk.Index))
+	if err != nil {
+		return nil, err
+	}
+	images, err := pa.newImageExtractor(ctx, document)
+	if err != nil {
+		extractor.abort()
+		return nil, err
+	}
+	opts := analysisOptions{
+		extractor:    extractor,
+		images:       images,
+		entities:     pa.newEntityDetector(document),
+		limits:       limits,
+		detectTables: pa.extraction.DetectTables || input.DetectTables,
+	}
+	images.attach(object, object.Size(), !reader.Trailer().Key("Encrypt").IsNull(), limits)
+
+	analysisCtx, cancel := pa.timeouts.documentContext(ctx)
+	defer cancel()
+
+	// Partial state is tracked the same way as for a whole document
+	stats := &contentStats{}
+	extracted, err := pa.extractPages(analysisCtx, reader, input.Chunk.StartPage, input.Chunk.EndPage, stats, opts)
+	if err != nil {
+		extractor.abort()
+		return nil, err
+	}
+
+	result = &ChunkResult{
+		DocumentID:      input.DocumentID,
+		ChunkIndex:      input.Chunk.Index,
+		StartPage:       input.Chunk.StartPage,
+		EndPage:         input.Chunk.EndPage,
+		PagesExtracted:  extracted,
+		TableCount:      len(stats.Tables),
+		Entities:        opts.entities.result(),
+		ImageExtraction: images.result(),
+	}
+	if extractor != nil {
+		if result.Extraction, err = extractor.commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	if stats.Partial != nil {
+		stats.Partial.PagesAnalyzed = extracted
+		result.Partial = stats.Partial
+		pa.logger.WithFields(logrus.Fields{
+			"reason":          result.Partial.Reason,
+			"pages_extracted": extracted,
+			"pages_timed_out": len(result.Partial.PagesTimedOut),
+		}).Warn("Chunk extraction cut short, returning partial result")
+	}
+
+	result.AnalysisTime = time.Since(startTime).Milliseconds()
+
+	pa.logger.WithFields(logrus.Fields{
+		"document_id":     result.DocumentID,
+		"chunk_index":     result.ChunkIndex,
+		"pages_extracted": result.PagesExtracted,
+		"table_count":     result.TableCount,
+		"analysis_time":   result.AnalysisTime,
+	}).Info("Chunk extraction completed")
+
+	return result, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+)
+
+// Jobs the analyzer worker runs at once
+const maxConcurrentRuns = 10
+
+// Chunks extracted at once, the chunk worker's slots and the default fan-out width
+const defaultChunkConcurrency = 10
+
+// Relative work of a page, scanned pages need OCR and cost the most
+const (
+	pageBaseWork        = 1.0
+	pageWorkPerKChars   = 0.5
+	pageWorkPerImage    = 0.25
+	scannedPageOCRWork  = 2.0
+	chunkSearchAttempts = 50
+)
+
+// Limits the chunk planner works within
+type ChunkPlannerConfig struct {
+	MaxChunkPages  int `json:"max_chunk_pages"`
+	MinChunkPages  int `json:"min_chunk_pages"`
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+// How a document is split for parallel processing
+type ChunkPlan struct {
+	ChunkCount    int     `json:"chunk_count"`
+	MaxChunkPages int     `json:"max_chunk_pages"`
+	Concurrency   int     `json:"concurrency"`
+	Waves         int     `json:"waves"`
+	TotalWork     float64 `json:"total_work"`
+	Chunks        []Chunk `json:"chunks"`
+}
+
+// A contiguous, inclusive page range processed by one child workflow
+type Chunk struct {
+	Index         int     `json:"index"`
+	StartPage     int     `json:"start_page"`
+	EndPage       int     `json:"end_page"`
+	PageCount     int     `json:"page_count"`
+	EstimatedWork float64 `json:"estimated_work"`
+}
+
+// Chunks run on their own worker with MaxConcurrency slots, so a wave of one
+// document's chunks fills it without competing with the fan-out steps
+func DefaultChunkPlannerConfig() ChunkPlannerConfig {
+	return ChunkPlannerConfig{
+		MaxChunkPages:  50,
+		MinChunkPages:  5,
+		MaxConcurrency: defaultChunkConcurrency,
+	}
+}
+
+// Override chunking limits with ANALYZER_CHUNK_* environment variables
+func ChunkPlannerConfigFromEnv() ChunkPlannerConfig {
+	config := DefaultChunkPlannerConfig()
+	config.MaxChunkPages = envInt("ANALYZER_CHUNK_MAX_PAGES", config.MaxChunkPages)
+	config.MinChunkPages = envInt("ANALYZER_CHUNK_MIN_PAGES", config.MinChunkPages)
+	config.MaxConcurrency = envInt("ANALYZER_CHUNK_MAX_CONCURRENCY", config.MaxConcurrency)
+	return config
+}
+
+// Positive integer from the environment, or fallback
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Strategies that fan out into chunks
+func isParallelStrategy(strategy string) bool {
+	return strategy == StrategyParallel || strategy == StrategyParallelChunked
+}
+
+// Estimated processing work for every page, indexed by page number - 1
+func estimatePageWork(pageCount int, pages []PageReport) []float64 {
+	work := make([]float64, pageCount)
+	for i := range work {
+		work[i] = pageBaseWork
+	}
+
+	for _, page := range pages {
+		if page.PageNumber < 1 || page.PageNumber > pageCount {
+			continue
+		}
+
+		w := pageBaseWork +
+			float64(page.CharCount)/1000*pageWorkPerKChars +
+			float64(page.ImageCount)*pageWorkPerImage
+		if page.IsScanned {
+			w += scannedPageOCRWork
+		}
+		work[page.PageNumber-1] = w
+	}
+
+	return work
+}
+
+// Split pages into contiguous ranges with roughly equal work.
+//
+// The chunk count is capped by worker concurrency and kept above the minimum
+// chunk size. MaxChunkPages is a hard limit, when a document is too big to fit
+// in MaxConcurrency chunks the extra chunks run in later waves.
+func PlanChunks(config ChunkPlannerConfig, pageCount int, pages []PageReport) *ChunkPlan {
+	if pageCount <= 0 {
+		return &ChunkPlan{Chunks: []Chunk{}}
+	}
+
+	config = config.normalized(pageCount)
+	work := estimatePageWork(pageCount, pages)
+
+	target := config.MaxConcurrency
+	if byMinSize := pageCount / config.MinChunkPages; byMinSize < target {
+		target = byMinSize
+	}
+	if bySizeCap := (pageCount + config.MaxChunkPages - 1) / config.MaxChunkPages; bySizeCap > target {
+		target = bySizeCap
+	}
+	if target < 1 {
+		target = 1
+	}
+
+	// Binary search the smallest per-chunk work that still fits in target chunks
+	total, heaviest := 0.0, 0.0
+	for _, w := range work {
+		total += w
+		heaviest = math.Max(heaviest, w)
+	}
+
+	low, high := heaviest, total
+	for i := 0; i < chunkSearchAttempts; i++ {
+		mid := (low + high) / 2
+		if len(splitByWork(work, mid, config.MaxChunkPages)) <= target {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+
+	chunks := splitByWork(work, high, config.MaxChunkPages)
+
+	return &ChunkPlan{
+		ChunkCount:    len(chunks),
+		MaxChunkPages: config.MaxChunkPages,
+		Concurrency:   config.MaxConcurrency,
+		Waves:         (len(chunks) + config.MaxConcurrency - 1) / config.MaxConcurrency,
+		TotalWork:     math.Round(total*100) / 100,
+		Chunks:        chunks,
+	}
+}
+
+// Replace unset limits with ones that keep the planner well defined
+func (cc ChunkPlannerConfig) normalized(pageCount int) ChunkPlannerConfig {
+	if cc.MaxChunkPages < 1 {
+		cc.MaxChunkPages = pageCount
+	}
+	if cc.MinChunkPages < 1 {
+		cc.MinChunkPages = 1
+	}
+	if cc.MaxConcurrency < 1 {
+		cc.MaxConcurrency = 1
+	}
+	return cc
+}
+
+// Greedily fill chunks up to maxWork and maxPages
+func splitByWork(work []float64, maxWork float64, maxPages int) []Chunk {
+	chunks := []Chunk{}
+	current := Chunk{StartPage: 1}
+
+	for i, w := range work {
+		pageNumber := i + 1
+		full := current.PageCount >= maxPages || current.EstimatedWork+w > maxWork
+		if current.PageCount > 0 && full {
+			chunks = append(chunks, closeChunk(current, len(chunks)))
+			current = Chunk{StartPage: pageNumber}
+		}
+
+		current.EndPage = pageNumber
+		current.PageCount++
+		current.EstimatedWork += w
+	}
+
+	return append(chunks, closeChunk(current, len(chunks)))
+}
+
+func closeChunk(chunk Chunk, index int) Chunk {
+	chunk.Index = index
+	chunk.EstimatedWork = math.Round(chunk.EstimatedWork*100) / 100
+	return chunk
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPlanChunks(t *testing.T) {
+	config := ChunkPlannerConfig{MaxChunkPages: 50, MinChunkPages: 5, MaxConcurrency: 10}
+
+	tests := []struct {
+		name      string
+		config    ChunkPlannerConfig
+		pageCount int
+		pages     []PageReport
+		chunks    int
+		waves     int
+	}{
+		{"empty document", config, 0, nil, 0, 0},
+		{"below the minimum chunk size", config, 4, nil, 1, 1},
+		{"capped by the minimum chunk size", config, 23, nil, 4, 1},
+		{"capped by concurrency", config, 200, nil, 10, 1},
+		{"past the page cap runs in waves", config, 1000, nil, 20, 2},
+		{"unset limits", ChunkPlannerConfig{}, 30, nil, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanChunks(tt.config, tt.pageCount, tt.pages)
+			if plan.ChunkCount != tt.chunks || len(plan.Chunks) != tt.chunks {
+				t.Fatalf("got %d chunks (%d listed), want %d", plan.ChunkCount, len(plan.Chunks), tt.chunks)
+			}
+			if plan.Waves != tt.waves {
+				t.Errorf("Waves = %d, want %d", plan.Waves, tt.waves)
+			}
+			checkChunksCover(t, plan, tt.pageCount)
+		})
+	}
+}
+
+func TestPlanChunksBalancesWork(t *testing.T) {
+	// The first ten pages are scans, each worth several text pages
+	pages := make([]PageReport, 40)
+	for i := range pages {
+		pages[i] = PageReport{PageNumber: i + 1, CharCount: 1000}
+		if i < 10 {
+			pages[i] = PageReport{PageNumber: i + 1, ImageCount: 1, IsScanned: true}
+		}
+	}
+
+	plan := PlanChunks(ChunkPlannerConfig{MaxChunkPages: 50, MinChunkPages: 5, MaxConcurrency: 4}, 40, pages)
+	checkChunksCover(t, plan, 40)
+	if plan.ChunkCount != 4 {
+		t.Fatalf("got %d chunks, want 4", plan.ChunkCount)
+	}
+
+	// 10 * 3.25 + 30 * 1.5 of work, roughly 19.4 per chunk
+	if plan.TotalWork != 77.5 {
+		t.Errorf("TotalWork = %v, want 77.5", plan.TotalWork)
+	}
+	if first, last := plan.Chunks[0], plan.Chunks[len(plan.Chunks)-1]; first.PageCount >= last.PageCount {
+		t.Errorf("scanned chunk has %d pages, text chunk %d; scans should get fewer pages", first.PageCount, last.PageCount)
+	}
+	for _, chunk := range plan.Chunks {
+		if chunk.EstimatedWork > 22 {
+			t.Errorf("chunk %d carries %v work, want it balanced", chunk.Index, chunk.EstimatedWork)
+		}
+	}
+}
+
+// Chunks are numbered in order and cover every page once, within the page cap
+func checkChunksCover(t *testing.T, plan *ChunkPlan, pageCount int) {
+	t.Helper()
+
+	next := 1
+	for i, chunk := range plan.Chunks {
+		if chunk.Index != i {
+			t.Errorf("chunk %d has index %d", i, chunk.Index)
+		}
+		if chunk.StartPage != next || chunk.EndPage < chunk.StartPage {
+			t.Errorf("chunk %d covers pages %d-%d, want it to start at %d", i, chunk.StartPage, chunk.EndPage, next)
+		}
+		if chunk.PageCount != chunk.EndPage-chunk.StartPage+1 {
+			t.Errorf("chunk %d has %d pages for range %d-%d", i, chunk.PageCount, chunk.StartPage, chunk.EndPage)
+		}
+		if chunk.PageCount > plan.MaxChunkPages {
+			t.Errorf("chunk %d has %d pages, over the %d page cap", i, chunk.PageCount, plan.MaxChunkPages)
+		}
+		next = chunk.EndPage + 1
+	}
+	if next != pageCount+1 {
+		t.Errorf("chunks end at page %d, want %d", next-1, pageCount)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Output formats for the local commands
+const (
+	OutputJSON  = "json"
+	OutputTable = "table"
+)
+
+// Exit codes for the local commands
+const (
+	exitOK     = 0
+	exitFailed = 1 // at least one document could not be analyzed
+	exitUsage  = 2
+)
+
+const cliUsage = `usage: analyzer [command] [flags]
+
+Without a command the analyzer runs as a Hatchet worker, also serving the
+HTTP analysis API when ANALYZER_API_ADDR is set.
+
+commands:
+  worker                  run the Hatchet worker
+  inspect [flags] FILE... analyze files or storage locations, e.g. s3://bucket/key.pdf
+  batch [flags] DIR       analyze every file under a directory concurrently
+  serve [flags]           serve the HTTP analysis API without the worker
+
+Pricing, routing, chunking, limits and timeouts are read from the same
+environment variables as the worker. Run a command with -h for its flags.
+`
+
+// Flags shared by inspect and batch
+type cliOptions struct {
+	output      string
+	tables      bool
+	pii         bool
+	verbose     bool
+	concurrency int
+}
+
+func (opts *cliOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&opts.output, "o", OutputTable, "output format, json or table")
+	flags.BoolVar(&opts.tables, "tables", false, "detect tables")
+	flags.BoolVar(&opts.pii, "pii", false, "detect PII and entities")
+	flags.BoolVar(&opts.verbose, "v", false, "log analysis progress to stderr")
+}
+
+// Run a local command, returning the process exit code
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "worker":
+		runWorker()
+		return exitOK
+	case "inspect":
+		return runInspect(args[1:], stdout, stderr)
+	case "batch":
+		return runBatch(args[1:], stdout, stderr)
+	case "serve":
+		return runServe(args[1:], stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return exitOK
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+	return exitUsage
+}
+
+// analyzer inspect FILE..., results in argument order
+func runInspect(args []string, stdout, stderr io.Writer) int {
+	var opts cliOptions
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	opts.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(stderr, "inspect needs at least one file")
+		return exitUsage
+	}
+	if !opts.validOutput(stderr) {
+		return exitUsage
+	}
+
+	analyzer, err := newLocalAnalyzer(opts, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	results := make([]*AnalysisResult, 0, flags.NArg())
+	for _, location := range flags.Args() {
+		results = append(results, analyzeLocal(ctx, analyzer, location))
+	}
+	return writeResults(stdout, opts.output, results, true)
+}
+
+// analyzer batch DIR, every regular file below it with a bounded worker pool
+func runBatch(args []string, stdout, stderr io.Writer) int {
+	opts := cliOptions{concurrency: runtime.NumCPU()}
+	flags := flag.NewFlagSet("batch", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	opts.register(flags)
+	flags.IntVar(&opts.concurrency, "c", opts.concurrency, "documents analyzed at once")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "batch needs exactly one directory")
+		return exitUsage
+	}
+	if !opts.validOutput(stderr) {
+		return exitUsage
+	}
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+
+	files, err := listDocuments(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	analyzer, err := newLocalAnalyzer(opts, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Results land in their file's slot so the output keeps directory order
+	results := make([]*AnalysisResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = analyzeLocal(ctx, analyzer, files[i])
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return writeResults(stdout, opts.output, results, false)
+}
+
+func (opts cliOptions) validOutput(stderr io.Writer) bool {
+	if opts.output == OutputJSON || opts.output == OutputTable {
+		return true
+	}
+	fmt.Fprintf(stderr, "unknown output format %q, use json or table\n", opts.output)
+	return false
+}
+
+// Analyzer configured from the environment like the worker, reading local
+// paths anywhere on disk and never writing artifacts or cached results
+func newLocalAnalyzer(opts cliOptions, stderr io.Writer) (*PDFAnalyzer, error) {
+	logger := logrus.New()
+	logger.SetOutput(stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	if opts.verbose {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+
+	pricing, err := LoadPricingFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pricing config: %w", err)
+	}
+	routing, err := LoadRoutingFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load routing policy: %w", err)
+	}
+
+	storage := NewStorageFromEnv()
+	storage.Local = &LocalStorage{Root: string(filepath.Separator)}
+
+	extraction := DefaultExtractionConfig()
+	extraction.DetectTables = opts.tables
+	extraction.DetectPII = opts.pii
+
+	return NewPDFAnalzyer(
+		logger,
+		WithStorage(storage),
+		WithPricing(pricing),
+		WithRouting(routing),
+		WithChunking(ChunkPlannerConfigFromEnv()),
+		WithTextExtraction(extraction),
+		WithTimeouts(TimeoutConfigFromEnv()),
+		WithResourceLimits(ResourceLimitsFromEnv()),
+	), nil
+}
+
+// Analyze one file or storage location, failures are reported in the result
+// the same way the worker reports them
+func analyzeLocal(ctx context.Context, analyzer *PDFAnalyzer, location string) *AnalysisResult {
+	input := DocumentInput{ID: location, FilePath: location}
+	if !strings.Contains(location, "://") {
+		if abs, err := filepath.Abs(location); err == nil {
+			input.FilePath = abs
+		}
+	}
+
+	result, err := analyzer.AnalyzeDocument(ctx, input)
+	if err == nil {
+		return result
+	}
+	if IsPermanentError(err) {
+		return rejectedResult(input, err)
+	}
+	return &AnalysisResult{DocumentID: input.ID, Pages: []PageReport{}, Error: newAnalysisError(err)}
+}
+
+// Regular files below dir in lexical order, skipping hidden files and directories
+func listDocuments(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// Print results as a table or JSON; a single inspected document is printed as
+// one indented object, anything else as one JSON object per line
+func writeResults(w io.Writer, output string, results []*AnalysisResult, indent bool) int {
+	code := exitOK
+	for _, result := range results {
+		if result.Error != nil {
+			code = exitFailed
+		}
+	}
+
+	if output == OutputTable {
+		writeResultTable(w, results)
+		return code
+	}
+
+	encoder := json.NewEncoder(w)
+	if indent && len(results) == 1 {
+		encoder.SetIndent("", "  ")
+	}
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return exitFailed
+		}
+	}
+	return code
+}
+
+func writeResultTable(w io.Writer, results []*AnalysisResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOCUMENT\tFORMAT\tPAGES\tSIZE\tSTRATEGY\tRULE\tCOST\tRISK\tDUPLICATE OF\tMS\tERROR")
+	for _, r := range results {
+		risk, errorKind := "-", ""
+		if r.Security != nil {
+			risk = r.Security.RiskLevel
+		}
+		if r.Error != nil {
+			errorKind = r.Error.Kind
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%.2f\t%s\t%s\t%d\t%s\n",
+			r.DocumentID, orDash(r.Format), r.PageCount, formatBytes(r.FileSize), orDash(r.ProcessType),
+			orDash(r.Routing.Rule), r.EstimatedCost, risk, orDash(r.DuplicateOf), r.Metrics.AnalysisTime, errorKind)
+	}
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Human readable size, e.g. 1.5M
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, suffix := float64(n), ""
+	for _, s := range []string{"K", "M", "G", "T"} {
+		value, suffix = value/unit, s
+		if value < unit {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f%s", value, suffix)
+}
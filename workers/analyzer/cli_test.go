@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run a local command, returning its exit code and what it printed
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunCommandInspectJSON(t *testing.T) {
+	code, stdout, stderr := runCLI(t, "inspect", "-o", "json", "-pii", "testdata/sample.pdf")
+	if code != exitOK {
+		t.Fatalf("exit code %d, stderr: %s", code, stderr)
+	}
+
+	var result AnalysisResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("stdout is not one JSON result: %v\n%s", err, stdout)
+	}
+	if !strings.HasPrefix(stdout, "{\n  \"document_id\"") {
+		t.Errorf("single inspected document is not indented:\n%s", stdout)
+	}
+
+	if result.DocumentID != "testdata/sample.pdf" {
+		t.Errorf("DocumentID = %q, want testdata/sample.pdf", result.DocumentID)
+	}
+	if result.Error != nil {
+		t.Fatalf("analysis failed: %+v", result.Error)
+	}
+	if result.Format != FormatPDF || result.PageCount != 1 || result.FileSize != 1710 {
+		t.Errorf("got a %d page %s of %d bytes, want a 1 page pdf of 1710 bytes", result.PageCount, result.Format, result.FileSize)
+	}
+	if result.Routing.Strategy != StrategySimple || result.Routing.Rule != "default" {
+		t.Errorf("routed %s by %s, want simple by default", result.Routing.Strategy, result.Routing.Rule)
+	}
+	if result.Metadata.Title != "Sample Contract" {
+		t.Errorf("Metadata.Title = %q, want Sample Contract", result.Metadata.Title)
+	}
+	if result.Entities == nil {
+		t.Fatal("-pii reported no entities")
+	}
+	for _, entityType := range []string{EntityEmail, EntityCreditCard, EntityIBAN, EntitySSN} {
+		if result.Entities.Counts[entityType] != 1 {
+			t.Errorf("found %d %s entities, want 1", result.Entities.Counts[entityType], entityType)
+		}
+	}
+}
+
+func TestRunCommandInspectTable(t *testing.T) {
+	code, stdout, _ := runCLI(t, "inspect", "testdata/sample.pdf", "testdata/missing.pdf")
+	if code != exitFailed {
+		t.Errorf("exit code %d, want %d for a missing document", code, exitFailed)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want a header and two rows:\n%s", len(lines), stdout)
+	}
+	if fields := strings.Fields(lines[0]); fields[0] != "DOCUMENT" || fields[len(fields)-1] != "ERROR" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+
+	// Rows keep argument order
+	sample, missing := strings.Fields(lines[1]), strings.Fields(lines[2])
+	if sample[0] != "testdata/sample.pdf" || sample[1] != FormatPDF || sample[4] != StrategySimple {
+		t.Errorf("unexpected sample row %q", lines[1])
+	}
+	if missing[0] != "testdata/missing.pdf" || missing[len(missing)-1] != "not-found" {
+		t.Errorf("unexpected missing row %q", lines[2])
+	}
+}
+
+func TestRunCommandBatch(t *testing.T) {
+	dir := t.TempDir()
+	sample, err := os.ReadFile("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"a.pdf":          sample,
+		"b/notes.txt":    []byte("Meeting notes, nothing to see here.\n"),
+		"c.bin":          {0x00, 0x01, 0x02, 0x03},
+		".hidden.pdf":    sample,
+		".cache/old.pdf": sample,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, stdout, stderr := runCLI(t, "batch", "-o", "json", "-c", "2", dir)
+	if code != exitFailed {
+		t.Errorf("exit code %d, want %d for an unsupported file, stderr: %s", code, exitFailed, stderr)
+	}
+
+	// One result per line in directory order, hidden files skipped
+	want := []struct {
+		name   string
+		format string
+		err    string
+	}{
+		{"a.pdf", FormatPDF, ""},
+		{"b/notes.txt", FormatText, ""},
+		{"c.bin", "", "unsupported-format"},
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d results, want %d:\n%s", len(lines), len(want), stdout)
+	}
+	for i, line := range lines {
+		var result AnalysisResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("line %d is not a JSON result: %v", i+1, err)
+		}
+
+		errorKind := ""
+		if result.Error != nil {
+			errorKind = result.Error.Kind
+		}
+		if result.DocumentID != filepath.Join(dir, want[i].name) || result.Format != want[i].format || errorKind != want[i].err {
+			t.Errorf("result %d is %s (%q, error %q), want %s (%q, error %q)", i+1,
+				result.DocumentID, result.Format, errorKind, want[i].name, want[i].format, want[i].err)
+		}
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"help", []string{"help"}, exitOK, "usage: analyzer", ""},
+		{"unknown command", []string{"analyse"}, exitUsage, "", `unknown command "analyse"`},
+		{"inspect without files", []string{"inspect"}, exitUsage, "", "inspect needs at least one file"},
+		{"unknown output format", []string{"inspect", "-o", "xml", "testdata/sample.pdf"}, exitUsage, "", `unknown output format "xml"`},
+		{"unknown flag", []string{"inspect", "-x", "testdata/sample.pdf"}, exitUsage, "", "flag provided but not defined"},
+		{"batch with two directories", []string{"batch", "testdata", "testdata"}, exitUsage, "", "batch needs exactly one directory"},
+		{"batch of a missing directory", []string{"batch", "testdata/missing"}, exitFailed, "", "failed to list testdata/missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(stdout, tt.stdout) {
+				t.Errorf("stdout %q does not contain %q", stdout, tt.stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr %q does not contain %q", stderr, tt.stderr)
+			}
+		})
+	}
+}
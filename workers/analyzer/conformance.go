@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Largest XMP packet read from the catalog metadata stream
+const maxXMPBytes = 1024 * 1024
+
+// Conformance rules checked before archiving
+const (
+	RuleXMPMetadata        = "xmp_metadata"
+	RulePDFAIdentification = "pdfa_identification"
+	RuleOutputIntent       = "output_intent"
+	RuleFontEmbedding      = "font_embedding"
+	RuleEncryption         = "encryption"
+	RuleJavaScript         = "javascript"
+	RuleLaunchAction       = "launch_action"
+	RuleForbiddenAction    = "forbidden_action"
+	RuleFileIdentifier     = "file_identifier"
+	RulePDFVersion         = "pdf_version"
+	RuleEmbeddedFiles      = "embedded_files"
+)
+
+// Violation severities, any error means the document is not PDF/A
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Actions PDF/A does not allow besides JavaScript and Launch
+var forbiddenPDFAActions = map[string]bool{
+	"Sound": true, "Movie": true, "ResetForm": true, "ImportData": true, "Hide": true,
+	"SetOCGState": true, "Rendition": true, "Trans": true, "GoTo3DView": true,
+}
+
+var (
+	xmpPartPattern        = regexp.MustCompile(`pdfaid:part\s*(?:=\s*["']|>\s*)(\d)`)
+	xmpConformancePattern = regexp.MustCompile(`pdfaid:conformance\s*(?:=\s*["']|>\s*)([A-Za-z])`)
+)
+
+// Result of the PDF/A pre-check, a structural check rather than full validation
+type ConformanceReport struct {
+	Standard     string                 `json:"standard"`
+	Claimed      string                 `json:"claimed,omitempty"`
+	Part         int                    `json:"part,omitempty"`
+	Conformance  string                 `json:"conformance,omitempty"`
+	Plausible    bool                   `json:"plausible"`
+	RulesChecked []string               `json:"rules_checked"`
+	Violations   []ConformanceViolation `json:"violations"`
+}
+
+// A single rule the document breaks
+type ConformanceViolation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Page     int    `json:"page,omitempty"`
+	Object   string `json:"object,omitempty"`
+}
+
+func (cr *ConformanceReport) violate(violation ConformanceViolation) {
+	cr.Violations = append(cr.Violations, violation)
+	if violation.Severity == SeverityError {
+		cr.Plausible = false
+	}
+}
+
+// Check the structural PDF/A requirements that can be seen without rendering:
+// identification and XMP metadata, output intent, fonts, encryption and actions
+func checkConformance(reader *pdf.Reader, stats *contentStats) *ConformanceReport {
+	trailer := reader.Trailer()
+	catalog := trailer.Key("Root")
+
+	report := &ConformanceReport{
+		Standard:   "PDF/A",
+		Plausible:  true,
+		Violations: []ConformanceViolation{},
+		RulesChecked: []string{
+			RuleXMPMetadata, RulePDFAIdentification, RuleOutputIntent, RuleFontEmbedding,
+			RuleEncryption, RuleJavaScript, RuleLaunchAction, RuleForbiddenAction,
+			RuleFileIdentifier, RulePDFVersion, RuleEmbeddedFiles,
+		},
+	}
+
+	// Identification lives in the XMP packet referenced from the catalog
+	metadata := catalog.Key("Metadata")
+	xmp, err := readXMP(metadata)
+	switch {
+	case metadata.IsNull():
+		report.violate(ConformanceViolation{Rule: RuleXMPMetadata, Severity: SeverityError, Message: "catalog has no XMP metadata stream"})
+	case err != nil:
+		report.violate(ConformanceViolation{Rule: RuleXMPMetadata, Severity: SeverityError, Message: fmt.Sprintf("XMP metadata stream is unreadable: %v", err)})
+	}
+
+	if match := xmpPartPattern.FindStringSubmatch(xmp); match != nil {
+		report.Part, _ = strconv.Atoi(match[1])
+		report.Claimed = fmt.Sprintf("PDF/A-%d", report.Part)
+		if match := xmpConformancePattern.FindStringSubmatch(xmp); match != nil {
+			report.Conformance = match[1]
+			report.Claimed += strings.ToLower(match[1])
+		}
+	} else if !metadata.IsNull() {
+		report.violate(ConformanceViolation{Rule: RulePDFAIdentification, Severity: SeverityError, Message: "XMP metadata has no pdfaid:part, the document does not claim PDF/A"})
+	}
+
+	if report.Part == 1 && !metadata.Key("Filter").IsNull() {
+		report.violate(ConformanceViolation{Rule: RuleXMPMetadata, Severity: SeverityError, Message: "PDF/A-1 metadata streams must not be filtered"})
+	}
+
+	checkOutputIntent(catalog, report)
+
+	// Every font has to be embedded, Type3 glyphs live in the file already
+	if stats.Fonts != nil {
+		for _, font := range stats.Fonts.Fonts {
+			if !font.Embedded {
+				report.violate(ConformanceViolation{
+					Rule:     RuleFontEmbedding,
+					Severity: SeverityError,
+					Message:  "font is not embedded",
+					Page:     font.FirstPage,
+					Object:   font.Name,
+				})
+			}
+		}
+	}
+
+	if stats.IsEncrypted {
+		report.violate(ConformanceViolation{Rule: RuleEncryption, Severity: SeverityError, Message: "document is encrypted"})
+	}
+
+	for _, action := range stats.Actions {
+		violation := ConformanceViolation{Severity: SeverityError, Page: action.Page, Object: action.Trigger}
+		switch {
+		case action.Type == "JavaScript":
+			violation.Rule, violation.Message = RuleJavaScript, "document contains JavaScript"
+		case action.Type == "Launch":
+			violation.Rule, violation.Message = RuleLaunchAction, "document launches external applications"
+		case forbiddenPDFAActions[action.Type]:
+			violation.Rule, violation.Message = RuleForbiddenAction, fmt.Sprintf("%s actions are not allowed", action.Type)
+		default:
+			continue
+		}
+		report.violate(violation)
+	}
+
+	if id := trailer.Key("ID"); id.Len() < 2 {
+		report.violate(ConformanceViolation{Rule: RuleFileIdentifier, Severity: SeverityError, Message: "trailer has no file identifier"})
+	}
+
+	// PDF/A-1 is based on PDF 1.4, later parts on PDF 1.7
+	if report.Part == 1 && stats.Metadata.PDFVersion > "1.4" {
+		report.violate(ConformanceViolation{
+			Rule:     RulePDFVersion,
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("PDF/A-1 expects PDF 1.4, file declares %s", stats.Metadata.PDFVersion),
+		})
+	}
+
+	// PDF/A-3 is the only part allowing arbitrary attachments
+	if files := nameTreeValues(catalog.Key("Names").Key("EmbeddedFiles")); len(files) > 0 && report.Part != 3 {
+		severity := SeverityError
+		if report.Part == 2 {
+			// Part 2 allows attachments that are themselves PDF/A, which needs checking by hand
+			severity = SeverityWarning
+		}
+		report.violate(ConformanceViolation{
+			Rule:     RuleEmbeddedFiles,
+			Severity: severity,
+			Message:  fmt.Sprintf("document has %d embedded files", len(files)),
+		})
+	}
+
+	return report
+}
+
+// A GTS_PDFA1 output intent with an ICC profile defines how colors print
+func checkOutputIntent(catalog pdf.Value, report *ConformanceReport) {
+	intents := catalog.Key("OutputIntents")
+	for i := 0; i < intents.Len(); i++ {
+		intent := intents.Index(i)
+		if intent.Key("S").Name() != "GTS_PDFA1" {
+			continue
+		}
+		if intent.Key("DestOutputProfile").IsNull() {
+			report.violate(ConformanceViolation{Rule: RuleOutputIntent, Severity: SeverityError, Message: "GTS_PDFA1 output intent has no ICC profile"})
+		}
+		return
+	}
+
+	report.violate(ConformanceViolation{Rule: RuleOutputIntent, Severity: SeverityError, Message: "catalog has no GTS_PDFA1 output intent"})
+}
+
+// Read the XMP packet, the pdf package panics on filters it can't decode
+func readXMP(metadata pdf.Value) (xmp string, err error) {
+	if metadata.Kind() != pdf.Stream {
+		return "", nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			xmp, err = "", fmt.Errorf("%v", r)
+		}
+	}()
+
+	rc := metadata.Reader()
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxXMPBytes))
+	return string(data), err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckConformance(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		claimed    string
+		plausible  bool
+		violations map[string]string // rule to severity
+	}{
+		{"pdfa.pdf", "PDF/A-2b", true, map[string]string{}},
+		{
+			// PDF/A-1 claimed on a 1.7 file with a launch link and an attachment
+			"pdfa1_attach.pdf", "PDF/A-1b", false, map[string]string{
+				RuleLaunchAction:  SeverityError,
+				RuleEmbeddedFiles: SeverityError,
+				RulePDFVersion:    SeverityWarning,
+			},
+		},
+		{
+			// An ordinary PDF claims nothing and misses every archival requirement
+			"sample.pdf", "", false, map[string]string{
+				RuleXMPMetadata:    SeverityError,
+				RuleOutputIntent:   SeverityError,
+				RuleFontEmbedding:  SeverityError,
+				RuleFileIdentifier: SeverityError,
+			},
+		},
+	}
+
+	pa := newTestAnalyzer(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			report := analyzeFixture(t, pa, tt.fixture).Conformance
+			if report == nil {
+				t.Fatal("no conformance report")
+			}
+			if report.Claimed != tt.claimed || report.Plausible != tt.plausible {
+				t.Errorf("claimed %q, plausible %v, want %q and %v", report.Claimed, report.Plausible, tt.claimed, tt.plausible)
+			}
+
+			got := make(map[string]string)
+			for _, violation := range report.Violations {
+				got[violation.Rule] = violation.Severity
+			}
+			if !reflect.DeepEqual(got, tt.violations) {
+				t.Errorf("violations %+v, want rules %v", report.Violations, tt.violations)
+			}
+			if len(report.RulesChecked) != 11 {
+				t.Errorf("RulesChecked = %v", report.RulesChecked)
+			}
+		})
+	}
+}
+
+func TestConformanceViolationDetails(t *testing.T) {
+	report := analyzeFixture(t, newTestAnalyzer(t), "sample.pdf").Conformance
+	for _, violation := range report.Violations {
+		if violation.Rule == RuleFontEmbedding && (violation.Object != "Helvetica" || violation.Page != 1) {
+			t.Errorf("font violation %+v, want Helvetica on page 1", violation)
+		}
+	}
+
+	attached := analyzeFixture(t, newTestAnalyzer(t), "pdfa1_attach.pdf").Conformance
+	if attached.Part != 1 || attached.Conformance != "B" {
+		t.Errorf("part %d conformance %q, want 1 and B", attached.Part, attached.Conformance)
+	}
+	for _, violation := range attached.Violations {
+		if violation.Rule == RuleLaunchAction && violation.Page != 1 {
+			t.Errorf("launch action reported on page %d, want the page of its link", violation.Page)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// How a duplicate was matched
+const (
+	DuplicateMatchExact = "exact" // identical bytes
+	DuplicateMatchText  = "text"  // same normalized text, e.g. a re-saved copy
+)
+
+// Documents with less normalized text than this are only matched on their bytes,
+// short or blank documents share too much boilerplate to tell apart
+const minFingerprintChars = 200
+
+// Fingerprints of every analyzed document, mapping each tenant's hashes to the
+// first of its documents seen with them; entries are appended to a JSONL file
+// when a path is set. Tenants never see each other's documents as duplicates.
+type DuplicateIndex struct {
+	mu     sync.Mutex
+	bySHA  map[string]string
+	byText map[string]string
+	file   *os.File
+}
+
+// One line of the index file
+type duplicateIndexEntry struct {
+	TenantID        string    `json:"tenant_id,omitempty"`
+	DocumentID      string    `json:"document_id"`
+	ContentHash     string    `json:"content_hash"`
+	TextFingerprint string    `json:"text_fingerprint,omitempty"`
+	IndexedAt       time.Time `json:"indexed_at"`
+}
+
+// In-memory index, forgotten when the worker stops
+func NewDuplicateIndex() *DuplicateIndex {
+	return &DuplicateIndex{bySHA: make(map[string]string), byText: make(map[string]string)}
+}
+
+// Load the index from ANALYZER_DEDUP_INDEX, in memory only when unset
+func DuplicateIndexFromEnv() (*DuplicateIndex, error) {
+	return OpenDuplicateIndex(os.Getenv("ANALYZER_DEDUP_INDEX"))
+}
+
+// Load an index file and keep it open for appending, creating it if missing
+func OpenDuplicateIndex(path string) (*DuplicateIndex, error) {
+	index := NewDuplicateIndex()
+	if path == "" {
+		return index, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open duplicate index: %w", err)
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// A crash mid-append leaves a torn last line, skip anything unreadable
+		var entry duplicateIndexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.DocumentID == "" {
+			continue
+		}
+		index.add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read duplicate index %s: %w", path, err)
+	}
+
+	index.file = file
+	return index, nil
+}
+
+// Look a document up and record it; returns the earlier document it duplicates
+// and how it matched, empty when it is new. A document never duplicates itself,
+// so retried analyses of the same ID are not flagged.
+func (di *DuplicateIndex) Check(tenantID, documentID, contentHash, textFingerprint string) (string, string, error) {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+
+	duplicateOf, match := di.lookup(tenantID, documentID, contentHash, textFingerprint)
+
+	// Later copies point at the original, whichever copy they match
+	entry := duplicateIndexEntry{
+		TenantID:        tenantID,
+		DocumentID:      documentID,
+		ContentHash:     contentHash,
+		TextFingerprint: textFingerprint,
+		IndexedAt:       time.Now().UTC(),
+	}
+	if duplicateOf != "" {
+		entry.DocumentID = duplicateOf
+	}
+	if !di.add(entry) || di.file == nil {
+		return duplicateOf, match, nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return duplicateOf, match, err
+	}
+	if _, err := di.file.Write(append(line, '\n')); err != nil {
+		return duplicateOf, match, fmt.Errorf("failed to append to duplicate index: %w", err)
+	}
+	return duplicateOf, match, nil
+}
+
+// Look a document up without recording it, for analyses that must never become
+// the original later uploads are flagged against
+func (di *DuplicateIndex) Lookup(tenantID, documentID, contentHash, textFingerprint string) (string, string) {
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	return di.lookup(tenantID, documentID, contentHash, textFingerprint)
+}
+
+func (di *DuplicateIndex) lookup(tenantID, documentID, contentHash, textFingerprint string) (string, string) {
+	if id, ok := di.bySHA[duplicateKey(tenantID, contentHash)]; ok && id != documentID {
+		return id, DuplicateMatchExact
+	}
+	if id, ok := di.byText[duplicateKey(tenantID, textFingerprint)]; ok && textFingerprint != "" && id != documentID {
+		return id, DuplicateMatchText
+	}
+	return "", ""
+}
+
+// Record hashes not seen before, reporting whether anything was new
+func (di *DuplicateIndex) add(entry duplicateIndexEntry) bool {
+	added := false
+	sha := duplicateKey(entry.TenantID, entry.ContentHash)
+	if _, ok := di.bySHA[sha]; !ok && entry.ContentHash != "" {
+		di.bySHA[sha] = entry.DocumentID
+		added = true
+	}
+	text := duplicateKey(entry.TenantID, entry.TextFingerprint)
+	if _, ok := di.byText[text]; !ok && entry.TextFingerprint != "" {
+		di.byText[text] = entry.DocumentID
+		added = true
+	}
+	return added
+}
+
+// Index key of a hash within a tenant's documents
+func duplicateKey(tenantID, hash string) string {
+	return tenantID + "|" + hash
+}
+
+func (di *DuplicateIndex) Close() error {
+	if di.file == nil {
+		return nil
+	}
+	return di.file.Close()
+}
+
+// SHA-256 of the document bytes, hex encoded
+func hashObject(object Object) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(object, 0, object.Size())); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Hashes page text reduced to lowercase words, so copies that were re-saved,
+// re-paginated or converted between formats fingerprint the same
+type textFingerprinter struct {
+	hash  hash.Hash
+	chars int
+	space bool
+
+	// State of a page added in parts, see addPagePart
+	pageStarted  bool
+	pendingSpace bool
+}
+
+func newTextFingerprinter() *textFingerprinter {
+	return &textFingerprinter{hash: sha256.New()}
+}
+
+// Add text as it is read, a page at once or in pieces. Safe to call on a nil
+// fingerprinter
+func (tf *textFingerprinter) addText(text string) {
+	if tf == nil {
+		return
+	}
+
+	var normalized strings.Builder
+	for _, r := range text {
+		tf.addRune(&normalized, r)
+	}
+	tf.hash.Write([]byte(normalized.String()))
+}
+
+// Add a piece of a page whose text is trimmed of surrounding whitespace, as
+// strings.TrimSpace would: whitespace before the page's first character is
+// dropped, and later whitespace only counts once more follows on the page.
+// endPage closes the page. Safe to call on a nil fingerprinter.
+func (tf *textFingerprinter) addPagePart(text string) {
+	if tf == nil {
+		return
+	}
+
+	var normalized strings.Builder
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			tf.pendingSpace = tf.pageStarted
+			continue
+		}
+		if tf.pendingSpace {
+			tf.addRune(&normalized, ' ')
+			tf.pendingSpace = false
+		}
+		tf.pageStarted = true
+		tf.addRune(&normalized, r)
+	}
+	tf.hash.Write([]byte(normalized.String()))
+}
+
+// Close a page added with addPagePart, dropping its trailing whitespace
+func (tf *textFingerprinter) endPage() {
+	if tf == nil {
+		return
+	}
+	tf.pageStarted, tf.pendingSpace = false, false
+}
+
+// Lowercase letters and digits, any run of other characters is one space
+func (tf *textFingerprinter) addRune(normalized *strings.Builder, r rune) {
+	if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		tf.space = tf.chars > 0
+		return
+	}
+	if tf.space {
+		normalized.WriteByte(' ')
+		tf.space = false
+	}
+	normalized.WriteRune(unicode.ToLower(r))
+	tf.chars++
+}
+
+// Hex fingerprint, empty when there was too little text to go on
+func (tf *textFingerprinter) sum() string {
+	if tf == nil || tf.chars < minFingerprintChars {
+		return ""
+	}
+	return hex.EncodeToString(tf.hash.Sum(nil))
+}
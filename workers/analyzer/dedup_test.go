@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateIndexTenants(t *testing.T) {
+	index := NewDuplicateIndex()
+	pa := newTestAnalyzer(t, WithDuplicateIndex(index))
+
+	analyze := func(tenant, id string) *AnalysisResult {
+		t.Helper()
+		result, err := pa.AnalyzeDocument(context.Background(), DocumentInput{ID: id, TenantID: tenant, FilePath: "sample.pdf"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := analyze("acme", "acme-1"); result.DuplicateOf != "" {
+		t.Fatalf("first upload flagged as a duplicate of %s", result.DuplicateOf)
+	}
+	// The same bytes uploaded by another tenant are that tenant's original
+	if result := analyze("globex", "globex-1"); result.DuplicateOf != "" {
+		t.Errorf("globex upload flagged as a duplicate of %s", result.DuplicateOf)
+	}
+
+	for tenant, original := range map[string]string{"acme": "acme-1", "globex": "globex-1"} {
+		result := analyze(tenant, tenant+"-2")
+		if result.DuplicateOf != original || result.DuplicateMatch != DuplicateMatchExact {
+			t.Errorf("%s re-upload: duplicate of %q by %q, want %s", tenant, result.DuplicateOf, result.DuplicateMatch, original)
+		}
+	}
+
+	// Retrying an analysis never flags the document against itself
+	if result := analyze("acme", "acme-1"); result.DuplicateOf != "" {
+		t.Errorf("retry flagged as a duplicate of %s", result.DuplicateOf)
+	}
+}
+
+func TestDuplicateIndexMatches(t *testing.T) {
+	index := NewDuplicateIndex()
+	if dup, _, err := index.Check("t", "a", "sha-a", "text-1"); dup != "" || err != nil {
+		t.Fatalf("Check() = %q, %v on an empty index", dup, err)
+	}
+
+	// A re-saved copy shares the text but not the bytes
+	dup, match, _ := index.Check("t", "b", "sha-b", "text-1")
+	if dup != "a" || match != DuplicateMatchText {
+		t.Errorf("re-saved copy: %q by %q, want a by text", dup, match)
+	}
+	// A copy of the copy still points at the original
+	if dup, match, _ := index.Check("t", "c", "sha-b", ""); dup != "a" || match != DuplicateMatchExact {
+		t.Errorf("copy of the copy: %q by %q, want a by bytes", dup, match)
+	}
+
+	// Lookups leave the index as it was
+	if dup, _ := index.Lookup("t", "d", "sha-d", ""); dup != "" {
+		t.Errorf("Lookup() = %q for unseen bytes", dup)
+	}
+	if dup, _, _ := index.Check("t", "e", "sha-d", ""); dup != "" {
+		t.Errorf("bytes only looked up before recorded as %q", dup)
+	}
+}
+
+func TestDuplicateIndexFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.jsonl")
+	index, err := OpenDuplicateIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Check("acme", "a", "sha", "text")
+	index.Check("acme", "b", "sha", "text") // nothing new to append
+	index.Check("globex", "c", "sha", "")
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"tenant_id":"acme"`) || !strings.Contains(lines[1], `"tenant_id":"globex"`) {
+		t.Fatalf("index file holds\n%s", data)
+	}
+
+	// A torn last line from a crash is skipped
+	if err := os.WriteFile(path, append(data, `{"tenant_id":"acme","document_id":"x","content`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenDuplicateIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	for _, tt := range []struct{ tenant, want string }{{"acme", "a"}, {"globex", "c"}, {"initech", ""}} {
+		if dup, _ := reopened.Lookup(tt.tenant, "new", "sha", ""); dup != tt.want {
+			t.Errorf("%s: duplicate of %q after reopening, want %q", tt.tenant, dup, tt.want)
+		}
+	}
+}
+
+func TestTextFingerprint(t *testing.T) {
+	body := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 6)
+	sum := func(pages ...string) string {
+		tf := newTextFingerprinter()
+		for _, page := range pages {
+			tf.addText(page)
+		}
+		return tf.sum()
+	}
+
+	want := sum(body)
+	if want == "" {
+		t.Fatal("no fingerprint for a page of text")
+	}
+	// Case, punctuation and where the pages break do not matter
+	if got := sum(strings.ToUpper(body[:100]), "\n"+strings.ReplaceAll(body[100:], ".", ";")); got != want {
+		t.Error("re-paginated copy fingerprints differently")
+	}
+	if got := sum(body + " extra"); got == want {
+		t.Error("different text fingerprints the same")
+	}
+	if got := sum(body[:100]); got != "" {
+		t.Errorf("short text fingerprinted as %s", got)
+	}
+
+	// A page added in pieces counts as its trimmed text
+	parts := newTextFingerprinter()
+	for _, part := range []string{"  \n", body[:50], "", body[50:] + "  ", "\n"} {
+		parts.addPagePart(part)
+	}
+	parts.endPage()
+	if got := parts.sum(); got != sum(strings.TrimSpace(body)) {
+		t.Error("page added in parts fingerprints differently")
+	}
+
+	var off *textFingerprinter
+	off.addText(body)
+	if off.sum() != "" {
+		t.Error("nil fingerprinter returned a sum")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/hatchet-dev/hatchet/pkg/client"
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+	"github.com/sirupsen/logrus"
+)
+
+// Event that starts the document-processing-pipeline workflow
+const defaultProcessEvent = "document:process"
+
+// Why a document is not handed to the processing pipeline
+const (
+	EmitSkippedDisabled  = "disabled"
+	EmitSkippedFailed    = "analysis_failed"
+	EmitSkippedStrategy  = "strategy"
+	EmitSkippedDuplicate = "duplicate"
+)
+
+// Rejected, quarantined and held documents wait for a person, not the pipeline
+var unprocessedStrategies = map[string]bool{
+	StrategyReject:       true,
+	StrategyQuarantine:   true,
+	StrategyManualReview: true,
+}
+
+// Follow-up event pushed once a document is analyzed
+type ProcessEventConfig struct {
+	Enabled bool
+	Event   string
+	// Append the strategy to the event, e.g. document:process:ocr, so each
+	// strategy can trigger its own workflow
+	PerStrategy bool
+}
+
+func DefaultProcessEventConfig() ProcessEventConfig {
+	return ProcessEventConfig{Enabled: true, Event: defaultProcessEvent}
+}
+
+// Settings from ANALYZER_PROCESS_EVENT_DISABLED, ANALYZER_PROCESS_EVENT and
+// ANALYZER_PROCESS_EVENT_PER_STRATEGY
+func ProcessEventConfigFromEnv() ProcessEventConfig {
+	config := DefaultProcessEventConfig()
+	config.Enabled = os.Getenv("ANALYZER_PROCESS_EVENT_DISABLED") != "true"
+	if event := os.Getenv("ANALYZER_PROCESS_EVENT"); event != "" {
+		config.Event = event
+	}
+	config.PerStrategy = os.Getenv("ANALYZER_PROCESS_EVENT_PER_STRATEGY") == "true"
+	return config
+}
+
+// Event key for a strategy
+func (pc ProcessEventConfig) eventKey(strategy string) string {
+	if pc.PerStrategy {
+		return pc.Event + ":" + strategy
+	}
+	return pc.Event
+}
+
+// Payload of the follow-up event. document_id and file_path match the input
+// of document-processing-pipeline so it runs unchanged.
+type ProcessEvent struct {
+	DocumentID     string          `json:"document_id"`
+	FilePath       string          `json:"file_path"`
+	TenantID       string          `json:"tenant_id,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Strategy       string          `json:"strategy"`
+	Analysis       *AnalysisResult `json:"analysis"`
+	Chunks         *JoinOutput     `json:"chunks,omitempty"`
+}
+
+// Output of the emit step
+type EmitOutput struct {
+	DocumentID     string `json:"document_id"`
+	Emitted        bool   `json:"emitted"`
+	Event          string `json:"event,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	SkipReason     string `json:"skip_reason,omitempty"`
+}
+
+// Same document, bytes and event always give the same key. Hatchet does not
+// dedupe events, document-processing-pipeline claims the key in its first step
+// and skips its stages when a replayed or re-triggered analysis sends it again.
+func processIdempotencyKey(event string, input *DocumentInput, analysis *AnalysisResult) string {
+	hash := sha256.New()
+	for _, part := range []string{event, input.TenantID, analysis.DocumentID, analysis.ContentHash} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Reason a result is not sent to the pipeline, empty when it is
+func emitSkipReason(config ProcessEventConfig, analysis *AnalysisResult) string {
+	switch {
+	case !config.Enabled:
+		return EmitSkippedDisabled
+	case analysis.Error != nil:
+		return EmitSkippedFailed
+	case unprocessedStrategies[analysis.ProcessType]:
+		return EmitSkippedStrategy
+	case analysis.DuplicateOf != "":
+		// Duplicates reuse what the pipeline produced for the original
+		return EmitSkippedDuplicate
+	}
+	return ""
+}
+
+// Push the follow-up event for an analyzed document. The push is its own step
+// after join, so retries of the analysis don't push again. A retried push that
+// had been delivered, or a re-triggered analysis, does send a second event;
+// the pipeline's claim on the idempotency key keeps it from running twice.
+func emitStep(pdfAnalyzer *PDFAnalyzer, events client.EventClient, config ProcessEventConfig) func(ctx worker.HatchetContext, input *DocumentInput) (*EmitOutput, error) {
+	return func(ctx worker.HatchetContext, input *DocumentInput) (*EmitOutput, error) {
+		logger := pdfAnalyzer.logger
+
+		analysis := &AnalysisResult{}
+		if err := ctx.StepOutput("analyze", analysis); err != nil {
+			return nil, fmt.Errorf("failed to read analyze output: %w", err)
+		}
+
+		output := &EmitOutput{DocumentID: analysis.DocumentID}
+		if output.SkipReason = emitSkipReason(config, analysis); output.SkipReason != "" {
+			logger.WithFields(logrus.Fields{
+				"workflow_run_id": ctx.WorkflowRunId(),
+				"document_id":     analysis.DocumentID,
+				"process_type":    analysis.ProcessType,
+				"skip_reason":     output.SkipReason,
+			}).Info("Emit: NOT SENT TO PIPELINE")
+			return output, nil
+		}
+
+		payload := &ProcessEvent{
+			DocumentID: analysis.DocumentID,
+			FilePath:   input.FilePath,
+			TenantID:   input.TenantID,
+			Strategy:   analysis.ProcessType,
+			Analysis:   analysis,
+		}
+
+		// Parallel documents carry what their chunks found
+		join := &JoinOutput{}
+		if err := ctx.StepOutput("join", join); err == nil && join.ChunkCount > 0 {
+			payload.Chunks = join
+		}
+
+		output.Event = config.eventKey(analysis.ProcessType)
+		output.IdempotencyKey = processIdempotencyKey(output.Event, input, analysis)
+		payload.IdempotencyKey = output.IdempotencyKey
+
+		if err := pushProcessEvent(ctx, events, output.Event, payload, ctx.WorkflowRunId()); err != nil {
+			logger.WithError(err).WithField("document_id", analysis.DocumentID).Error("Failed to push process event")
+			return nil, err
+		}
+		output.Emitted = true
+
+		logger.WithFields(logrus.Fields{
+			"workflow_run_id": ctx.WorkflowRunId(),
+			"document_id":     analysis.DocumentID,
+			"event":           output.Event,
+			"idempotency_key": output.IdempotencyKey,
+		}).Info("Emit: SENT TO PIPELINE")
+
+		return output, nil
+	}
+}
+
+// Push the event with the idempotency key in its metadata, where Hatchet shows
+// it on every run the event triggers
+func pushProcessEvent(ctx context.Context, events client.EventClient, event string, payload *ProcessEvent, workflowRunID string) error {
+	metadata := map[string]string{
+		"idempotency_key":        payload.IdempotencyKey,
+		"document_id":            payload.DocumentID,
+		"strategy":               payload.Strategy,
+		"source_workflow_run_id": workflowRunID,
+	}
+	if err := events.Push(ctx, event, payload, client.WithEventMetadata(metadata)); err != nil {
+		return fmt.Errorf("failed to push %s event: %w", event, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessIdempotencyKey(t *testing.T) {
+	input := &DocumentInput{ID: "doc-1", TenantID: "acme"}
+	analysis := &AnalysisResult{DocumentID: "doc-1", ContentHash: "abc"}
+	key := processIdempotencyKey("document:process", input, analysis)
+
+	// A replayed or re-triggered analysis sends the same key, whatever else changed
+	rerun := &AnalysisResult{DocumentID: "doc-1", ContentHash: "abc", ProcessType: StrategyOCR, Cached: true}
+	if got := processIdempotencyKey("document:process", &DocumentInput{ID: "doc-1", TenantID: "acme", ExtractText: true}, rerun); got != key {
+		t.Errorf("re-run key %s, want %s", got, key)
+	}
+	if len(key) != 64 {
+		t.Errorf("key %q is not a hex sha256", key)
+	}
+
+	// Anything naming a different piece of work gives a different key, fields
+	// are separated so moving a character between them changes it too
+	for name, other := range map[string]string{
+		"event":    processIdempotencyKey("document:process:ocr", input, analysis),
+		"tenant":   processIdempotencyKey("document:process", &DocumentInput{TenantID: "globex"}, analysis),
+		"document": processIdempotencyKey("document:process", input, &AnalysisResult{DocumentID: "doc-2", ContentHash: "abc"}),
+		"content":  processIdempotencyKey("document:process", input, &AnalysisResult{DocumentID: "doc-1", ContentHash: "abd"}),
+		"boundary": processIdempotencyKey("document:process", &DocumentInput{TenantID: "acm"}, &AnalysisResult{DocumentID: "edoc-1", ContentHash: "abc"}),
+	} {
+		if other == key {
+			t.Errorf("another %s gives the same key", name)
+		}
+	}
+}
+
+func TestEmitSkipReason(t *testing.T) {
+	enabled := DefaultProcessEventConfig()
+	tests := []struct {
+		name     string
+		config   ProcessEventConfig
+		analysis *AnalysisResult
+		want     string
+	}{
+		{"processed", enabled, &AnalysisResult{ProcessType: StrategySimple}, ""},
+		{"disabled", ProcessEventConfig{}, &AnalysisResult{ProcessType: StrategySimple}, EmitSkippedDisabled},
+		{"failed", enabled, &AnalysisResult{Error: &AnalysisError{Kind: "corrupted"}}, EmitSkippedFailed},
+		{"quarantined", enabled, &AnalysisResult{ProcessType: StrategyQuarantine}, EmitSkippedStrategy},
+		{"duplicate", enabled, &AnalysisResult{ProcessType: StrategySimple, DuplicateOf: "doc-0"}, EmitSkippedDuplicate},
+	}
+
+	for _, tt := range tests {
+		if got := emitSkipReason(tt.config, tt.analysis); got != tt.want {
+			t.Errorf("%s: emitSkipReason() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	perStrategy := ProcessEventConfig{Enabled: true, Event: "document:process", PerStrategy: true}
+	if got := perStrategy.eventKey(StrategyOCR); got != "document:process:"+StrategyOCR {
+		t.Errorf("eventKey() = %q", got)
+	}
+}
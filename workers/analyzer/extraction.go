@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Default location prefix for artifacts written by the analyzer
+const defaultArtifactPrefix = "artifacts"
+
+// Name of the per-page text artifact under a document's artifact prefix
+const textArtifactName = "text.jsonl"
+
+// Text artifact of one chunk of a parallel document, e.g. text.chunk-0003.jsonl
+func chunkTextArtifactName(index int) string {
+	return fmt.Sprintf("text.chunk-%04d.jsonl", index)
+}
+
+// Controls whether full page text, tables, images and PII are extracted during analysis
+type ExtractionConfig struct {
+	Enabled          bool
+	IncludePositions bool
+	DetectTables     bool
+	ExtractImages    bool
+	DetectPII        bool
+	ArtifactPrefix   string
+}
+
+func DefaultExtractionConfig() ExtractionConfig {
+	return ExtractionConfig{ArtifactPrefix: defaultArtifactPrefix}
+}
+
+// Extraction settings from ANALYZER_EXTRACT_*, ANALYZER_DETECT_* and ANALYZER_ARTIFACT_PREFIX
+func ExtractionConfigFromEnv() ExtractionConfig {
+	config := DefaultExtractionConfig()
+	config.Enabled = os.Getenv("ANALYZER_EXTRACT_TEXT") == "true"
+	config.IncludePositions = os.Getenv("ANALYZER_EXTRACT_POSITIONS") == "true"
+	config.DetectTables = os.Getenv("ANALYZER_DETECT_TABLES") == "true"
+	config.ExtractImages = os.Getenv("ANALYZER_EXTRACT_IMAGES") == "true"
+	config.DetectPII = os.Getenv("ANALYZER_DETECT_PII") == "true"
+	if prefix := os.Getenv("ANALYZER_ARTIFACT_PREFIX"); prefix != "" {
+		config.ArtifactPrefix = prefix
+	}
+	return config
+}
+
+// Where an extraction artifact was written
+type ExtractionArtifact struct {
+	Location          string `json:"location"`
+	Format            string `json:"format"`
+	PagesWritten      int    `json:"pages_written"`
+	Bytes             int64  `json:"bytes"`
+	IncludesPositions bool   `json:"includes_positions"`
+}
+
+// One line of the text artifact
+type PageText struct {
+	DocumentID string    `json:"document_id"`
+	PageNumber int       `json:"page_number"`
+	Text       string    `json:"text"`
+	Rows       []TextRow `json:"rows,omitempty"`
+	Tables     []Table   `json:"tables,omitempty"`
+}
+
+// Words sharing a baseline, top of the page first
+type TextRow struct {
+	Y     float64    `json:"y"`
+	Words []TextWord `json:"words"`
+}
+
+// A word and its position, in PDF points from the bottom left of the page
+type TextWord struct {
+	Text     string  `json:"text"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Width    float64 `json:"width"`
+	FontSize float64 `json:"font_size"`
+}
+
+// Streams page text to storage as JSONL while the format analyzers walk pages
+type textExtractor struct {
+	documentID       string
+	includePositions bool
+	artifact         ArtifactWriter
+	writer           *bufio.Writer
+	counter          *countingWriter
+	location         string
+	pages            int
+}
+
+// Open the named text artifact for a document, nil when extraction is off
+func (pa *PDFAnalyzer) newTextExtractor(ctx context.Context, input DocumentInput, name string) (*textExtractor, error) {
+	if !pa.extraction.Enabled && !input.ExtractText {
+		return nil, nil
+	}
+
+	writable, ok := pa.storage.(WritableStorage)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage backend cannot write artifacts", ErrUnsupportedLocation)
+	}
+
+	location := artifactLocation(pa.extraction.ArtifactPrefix, input.ID, name)
+	artifact, err := writable.Create(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &countingWriter{w: artifact}
+	return &textExtractor{
+		documentID:       input.ID,
+		includePositions: pa.extraction.IncludePositions || input.ExtractPositions,
+		artifact:         artifact,
+		writer:           bufio.NewWriter(counter),
+		counter:          counter,
+		location:         location,
+	}, nil
+}
+
+// <prefix>/<document id>/<name>, with the id flattened to a single segment
+func artifactLocation(prefix, documentID, name string) string {
+	id := strings.NewReplacer("/", "_", "\\", "_").Replace(documentID)
+	if id == "" || id == "." || id == ".." {
+		id = "_"
+	}
+	return strings.TrimRight(prefix, "/") + "/" + id + "/" + name
+}
+
+// Append one page, safe to call on a nil extractor
+func (te *textExtractor) writePage(record PageText) error {
+	if te == nil {
+		return nil
+	}
+
+	record.DocumentID = te.documentID
+	if !te.includePositions {
+		record.Rows = nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := te.writer.Write(line); err != nil {
+		return err
+	}
+	if err := te.writer.WriteByte('\n'); err != nil {
+		return err
+	}
+
+	te.pages++
+	return nil
+}
+
+// Publish the artifact once every page has been written
+func (te *textExtractor) commit() (*ExtractionArtifact, error) {
+	if err := te.writer.Flush(); err != nil {
+		te.artifact.Abort()
+		return nil, err
+	}
+	if err := te.artifact.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &ExtractionArtifact{
+		Location:          te.location,
+		Format:            "jsonl",
+		PagesWritten:      te.pages,
+		Bytes:             te.counter.n,
+		IncludesPositions: te.includePositions,
+	}, nil
+}
+
+// Drop a partially written artifact, safe to call on a nil extractor
+func (te *textExtractor) abort() {
+	if te == nil {
+		return
+	}
+	te.artifact.Abort()
+}
+
+// Whether the PDF page walk should collect text positions
+func (te *textExtractor) wantsPositions() bool {
+	return te != nil && te.includePositions
+}
+
+// Glyphs within this fraction of the font size of each other belong to one word
+const wordGapRatio = 0.2
+
+// Glyphs whose baselines differ by less than this many points share a row
+const rowTolerance = 2.0
+
+// Positioned words for a PDF page, grouped into rows by baseline
+func pageTextRows(page pdf.Page) (rows []TextRow) {
+	// Content interprets the whole stream and panics on operators it cannot parse
+	defer func() {
+		if r := recover(); r != nil {
+			rows = nil
+		}
+	}()
+
+	// Sort on the exact baseline, a tolerance in the comparator would not be a
+	// consistent ordering. Rows are grouped from the sorted glyphs below.
+	glyphs := page.Content().Text
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		return glyphs[i].Y > glyphs[j].Y
+	})
+
+	var word *TextWord
+	var text strings.Builder
+	flush := func() {
+		if word != nil {
+			word.Text = text.String()
+			word.Width = roundTo(word.Width, 2)
+			rows[len(rows)-1].Words = append(rows[len(rows)-1].Words, *word)
+			word = nil
+			text.Reset()
+		}
+	}
+
+	for start := 0; start < len(glyphs); {
+		// A row runs until a baseline rowTolerance below its first one
+		end := start + 1
+		for end < len(glyphs) && glyphs[start].Y-glyphs[end].Y < rowTolerance {
+			end++
+		}
+		flush()
+		rows = append(rows, TextRow{Y: roundTo(glyphs[start].Y, 2), Words: []TextWord{}})
+
+		row := glyphs[start:end]
+		sort.SliceStable(row, func(i, j int) bool {
+			return row[i].X < row[j].X
+		})
+		start = end
+
+		for _, glyph := range row {
+			if strings.TrimSpace(glyph.S) == "" {
+				flush()
+				continue
+			}
+
+			if word != nil && glyph.X-(word.X+word.Width) > wordGapRatio*glyph.FontSize {
+				flush()
+			}
+			if word == nil {
+				word = &TextWord{X: roundTo(glyph.X, 2), Y: roundTo(glyph.Y, 2), FontSize: glyph.FontSize}
+			}
+			text.WriteString(glyph.S)
+			word.Width = glyph.X + glyph.W - word.X
+		}
+	}
+	flush()
+
+	// Rows made only of spaces carry nothing worth writing
+	kept := rows[:0]
+	for _, row := range rows {
+		if len(row.Words) > 0 {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
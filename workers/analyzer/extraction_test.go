@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Analyzer reading testdata and writing artifacts under root
+func newExtractingAnalyzer(t *testing.T, root string, opts ...AnalyzerOption) *PDFAnalyzer {
+	t.Helper()
+
+	storage := &StorageRouter{Local: &LocalStorage{Root: "testdata"}, Artifacts: newArtifactStorage(root)}
+	return newTestAnalyzer(t, append([]AnalyzerOption{WithStorage(storage)}, opts...)...)
+}
+
+// Lines of a JSONL text artifact
+func readTextArtifact(t *testing.T, path string) []PageText {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var pages []PageText
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var page PageText
+		if err := json.Unmarshal(scanner.Bytes(), &page); err != nil {
+			t.Fatalf("artifact line %q: %v", scanner.Text(), err)
+		}
+		pages = append(pages, page)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return pages
+}
+
+func TestExtractTextArtifact(t *testing.T) {
+	root := t.TempDir()
+	pa := newExtractingAnalyzer(t, root)
+
+	input := DocumentInput{ID: "contracts/2024/sample", FilePath: "sample.pdf", ExtractText: true, ExtractPositions: true}
+	result, err := pa.AnalyzeDocument(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := result.Extraction
+	if artifact == nil {
+		t.Fatal("no extraction artifact")
+	}
+	if artifact.Location != "artifacts/contracts_2024_sample/text.jsonl" || artifact.Format != "jsonl" {
+		t.Errorf("artifact at %q as %q", artifact.Location, artifact.Format)
+	}
+	if artifact.PagesWritten != 1 || !artifact.IncludesPositions {
+		t.Errorf("artifact %+v, want one page with positions", artifact)
+	}
+	info, err := os.Stat(filepath.Join(root, artifact.Location))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != artifact.Bytes {
+		t.Errorf("artifact is %d bytes, reported %d", info.Size(), artifact.Bytes)
+	}
+
+	pages := readTextArtifact(t, filepath.Join(root, artifact.Location))
+	if len(pages) != 1 {
+		t.Fatalf("artifact has %d pages, want 1", len(pages))
+	}
+	page := pages[0]
+	if page.DocumentID != input.ID || page.PageNumber != 1 || !strings.Contains(page.Text, "jane.doe@example.com") {
+		t.Errorf("page %d of %q: %q", page.PageNumber, page.DocumentID, page.Text)
+	}
+
+	// Rows run top to bottom, words left to right within a row
+	if len(page.Rows) < 2 {
+		t.Fatalf("got %d rows, want the title and body lines", len(page.Rows))
+	}
+	for i, row := range page.Rows {
+		if i > 0 && row.Y >= page.Rows[i-1].Y {
+			t.Errorf("row %d at y=%v is not below row %d at y=%v", i, row.Y, i-1, page.Rows[i-1].Y)
+		}
+		for j, word := range row.Words {
+			if word.Text == "" || word.FontSize <= 0 || word.Width <= 0 {
+				t.Errorf("row %d word %d: %+v", i, j, word)
+			}
+			if j > 0 && word.X <= row.Words[j-1].X {
+				t.Errorf("row %d word %d at x=%v is left of the word before it", i, j, word.X)
+			}
+		}
+	}
+}
+
+func TestExtractTextWithoutPositions(t *testing.T) {
+	root := t.TempDir()
+	pa := newExtractingAnalyzer(t, root, WithTextExtraction(ExtractionConfig{Enabled: true, ArtifactPrefix: "out/"}))
+
+	result := analyzeFixture(t, pa, "sample.pdf")
+	if result.Extraction == nil || result.Extraction.Location != "out/sample.pdf/text.jsonl" {
+		t.Fatalf("extraction %+v", result.Extraction)
+	}
+	for _, page := range readTextArtifact(t, filepath.Join(root, result.Extraction.Location)) {
+		if page.Rows != nil {
+			t.Errorf("page %d has rows without positions requested", page.PageNumber)
+		}
+	}
+}
+
+func TestExtractTextAbortsFailedAnalysis(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	pa := newExtractingAnalyzer(t, root)
+	input := DocumentInput{ID: "cut", ExtractText: true}
+	if _, err := pa.analyzeObject(context.Background(), input, newMemObject(sample[:len(sample)/2]), time.Now()); err == nil {
+		t.Fatal("truncated pdf analyzed")
+	}
+
+	// Nothing half written is left behind for the pipeline to pick up
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("artifact %s left after a failed analysis", path)
+		}
+		return nil
+	})
+}
+
+func TestArtifactLocation(t *testing.T) {
+	for id, want := range map[string]string{
+		"doc-1":          "artifacts/doc-1/text.jsonl",
+		"a/b\\c":         "artifacts/a_b_c/text.jsonl",
+		"../../etc":      "artifacts/.._.._etc/text.jsonl",
+		"..":             "artifacts/_/text.jsonl",
+		"":               "artifacts/_/text.jsonl",
+		"/uploads/x.pdf": "artifacts/_uploads_x.pdf/text.jsonl",
+	} {
+		if got := artifactLocation("artifacts/", id, textArtifactName); got != want {
+			t.Errorf("artifactLocation(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
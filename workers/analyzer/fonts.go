@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Font warning codes
+const (
+	FontWarningUnembedded = "unembedded_font"
+	FontWarningType3      = "type3_font"
+)
+
+// Fonts every PDF reader ships, unembedded copies still render but fail archival checks
+var standard14Fonts = map[string]bool{
+	"Times-Roman": true, "Times-Bold": true, "Times-Italic": true, "Times-BoldItalic": true,
+	"Helvetica": true, "Helvetica-Bold": true, "Helvetica-Oblique": true, "Helvetica-BoldOblique": true,
+	"Courier": true, "Courier-Bold": true, "Courier-Oblique": true, "Courier-BoldOblique": true,
+	"Symbol": true, "ZapfDingbats": true,
+}
+
+// A distinct font used somewhere in the document
+type FontInfo struct {
+	Name       string `json:"name"`
+	Subtype    string `json:"subtype"`
+	Embedded   bool   `json:"embedded"`
+	Subset     bool   `json:"subset"`
+	Standard14 bool   `json:"standard_14,omitempty"`
+	Encoding   string `json:"encoding"`
+	ToUnicode  bool   `json:"to_unicode"`
+	FirstPage  int    `json:"first_page"`
+	PageCount  int    `json:"page_count"`
+}
+
+// A font that printing or archival pipelines are likely to reject
+type FontWarning struct {
+	Code      string `json:"code"`
+	Font      string `json:"font"`
+	Message   string `json:"message"`
+	FirstPage int    `json:"first_page"`
+	PageCount int    `json:"page_count"`
+}
+
+// Every font in the document along with the problems found
+type FontReport struct {
+	Fonts      []FontInfo    `json:"fonts"`
+	Embedded   int           `json:"embedded"`
+	Unembedded int           `json:"unembedded"`
+	Type3      int           `json:"type3"`
+	Warnings   []FontWarning `json:"warnings"`
+}
+
+// Collects fonts page by page, the same font on many pages is reported once
+type fontInventory struct {
+	fonts map[string]*FontInfo
+	order []string
+}
+
+func newFontInventory() *fontInventory {
+	return &fontInventory{fonts: make(map[string]*FontInfo)}
+}
+
+// Fonts used on a page, including those of forms drawn on it. Reads the page
+// only, so it can run while other pages are merged into the inventory.
+func collectPageFonts(page pdf.Page) []FontInfo {
+	seen := make(map[string]bool)
+	var infos []FontInfo
+
+	var walk func(resources pdf.Value, depth int)
+	walk = func(resources pdf.Value, depth int) {
+		fonts := resources.Key("Font")
+		for _, resName := range fonts.Keys() {
+			info := describeFont(fonts.Key(resName), resName)
+			if key := info.key(); !seen[key] {
+				seen[key] = true
+				infos = append(infos, info)
+			}
+		}
+
+		// Forms drawn on the page bring their own fonts
+		xobjects := resources.Key("XObject")
+		for _, name := range xobjects.Keys() {
+			xobject := xobjects.Key(name)
+			if xobject.Key("Subtype").Name() == "Form" && depth < maxFormDepth {
+				walk(formResources(xobject, resources), depth+1)
+			}
+		}
+	}
+	walk(page.Resources(), 0)
+	return infos
+}
+
+// Record the fonts used on a page, returning their names
+func (fi *fontInventory) addFonts(infos []FontInfo, pageNumber int) []string {
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		info := info
+		names = append(names, info.Name)
+
+		key := info.key()
+		if existing, ok := fi.fonts[key]; ok {
+			existing.PageCount++
+			continue
+		}
+		info.FirstPage = pageNumber
+		info.PageCount = 1
+		fi.fonts[key] = &info
+		fi.order = append(fi.order, key)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// The same font program used on several pages
+func (info FontInfo) key() string {
+	return fmt.Sprintf("%s|%s|%t", info.Name, info.Subtype, info.Embedded)
+}
+
+// Number of distinct fonts seen so far
+func (fi *fontInventory) count() int {
+	return len(fi.order)
+}
+
+// Final report with warnings for unembedded and Type3 fonts
+func (fi *fontInventory) report() *FontReport {
+	report := &FontReport{Fonts: []FontInfo{}, Warnings: []FontWarning{}}
+	for _, key := range fi.order {
+		font := *fi.fonts[key]
+		report.Fonts = append(report.Fonts, font)
+
+		switch {
+		case font.Subtype == "Type3":
+			report.Type3++
+			report.Warnings = append(report.Warnings, FontWarning{
+				Code:      FontWarningType3,
+				Font:      font.Name,
+				Message:   "Type3 font glyphs are drawn as graphics and rarely survive print or archival conversion",
+				FirstPage: font.FirstPage,
+				PageCount: font.PageCount,
+			})
+		case !font.Embedded:
+			report.Unembedded++
+			message := "font is not embedded, output depends on fonts installed where it is rendered"
+			if font.Standard14 {
+				message = "standard font is not embedded, readers substitute it but archival formats require embedding"
+			}
+			report.Warnings = append(report.Warnings, FontWarning{
+				Code:      FontWarningUnembedded,
+				Font:      font.Name,
+				Message:   message,
+				FirstPage: font.FirstPage,
+				PageCount: font.PageCount,
+			})
+		default:
+			report.Embedded++
+		}
+	}
+	return report
+}
+
+func describeFont(font pdf.Value, resName string) FontInfo {
+	info := FontInfo{
+		Name:      font.Key("BaseFont").Name(),
+		Subtype:   font.Key("Subtype").Name(),
+		Encoding:  fontEncoding(font.Key("Encoding")),
+		ToUnicode: !font.Key("ToUnicode").IsNull(),
+	}
+
+	// Type3 fonts usually have no BaseFont, fall back to their resource name
+	if info.Name == "" {
+		info.Name = font.Key("Name").Name()
+	}
+	if info.Name == "" {
+		info.Name = resName
+	}
+
+	info.Subset = isSubsetFontName(info.Name)
+	info.Standard14 = standard14Fonts[info.Name]
+
+	// Composite fonts keep their descriptor on the descendant CIDFont
+	descriptor := font.Key("FontDescriptor")
+	if info.Subtype == "Type0" {
+		descriptor = font.Key("DescendantFonts").Index(0).Key("FontDescriptor")
+	}
+
+	// Type3 glyphs are content streams inside the font itself
+	info.Embedded = info.Subtype == "Type3" ||
+		!descriptor.Key("FontFile").IsNull() ||
+		!descriptor.Key("FontFile2").IsNull() ||
+		!descriptor.Key("FontFile3").IsNull()
+
+	return info
+}
+
+// Subset fonts are named with a six capital letter tag, ABCDEF+Name
+func isSubsetFontName(name string) bool {
+	if len(name) < 8 || name[6] != '+' {
+		return false
+	}
+	for i := 0; i < 6; i++ {
+		if name[i] < 'A' || name[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func fontEncoding(encoding pdf.Value) string {
+	switch encoding.Kind() {
+	case pdf.Name:
+		return encoding.Name()
+	case pdf.Dict:
+		if base := encoding.Key("BaseEncoding").Name(); base != "" {
+			return base + "+differences"
+		}
+		return "custom"
+	case pdf.Stream:
+		return "embedded_cmap"
+	}
+	return "builtin"
+}
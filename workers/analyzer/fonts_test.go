@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFontReport(t *testing.T) {
+	result := analyzeFixture(t, newTestAnalyzer(t), "fonts.pdf")
+	report := result.Fonts
+	if report == nil {
+		t.Fatal("no font report")
+	}
+
+	// Page 1 uses Helvetica and a subset Arial, page 2 Helvetica again, a
+	// Type3 font without a name and a composite CJK font
+	want := []FontInfo{
+		{Name: "Helvetica", Subtype: "Type1", Standard14: true, Encoding: "WinAnsiEncoding", FirstPage: 1, PageCount: 2},
+		{Name: "ABCDEF+Arial", Subtype: "TrueType", Embedded: true, Subset: true, Encoding: "WinAnsiEncoding+differences", FirstPage: 1, PageCount: 1},
+		{Name: "F3", Subtype: "Type3", Embedded: true, Encoding: "custom", FirstPage: 2, PageCount: 1},
+		{Name: "MSGothic", Subtype: "Type0", Encoding: "Identity-H", FirstPage: 2, PageCount: 1},
+	}
+	if !reflect.DeepEqual(report.Fonts, want) {
+		t.Errorf("fonts\n got %+v\nwant %+v", report.Fonts, want)
+	}
+	if report.Embedded != 1 || report.Unembedded != 2 || report.Type3 != 1 {
+		t.Errorf("%d embedded, %d unembedded, %d Type3, want 1, 2 and 1", report.Embedded, report.Unembedded, report.Type3)
+	}
+
+	warnings := make(map[string]FontWarning)
+	for _, warning := range report.Warnings {
+		warnings[warning.Font] = warning
+	}
+	if len(warnings) != 3 || warnings["F3"].Code != FontWarningType3 || warnings["MSGothic"].Code != FontWarningUnembedded {
+		t.Fatalf("warnings %+v", report.Warnings)
+	}
+	// Readers substitute the standard fonts, the warning says so
+	helvetica := warnings["Helvetica"]
+	if helvetica.Code != FontWarningUnembedded || helvetica.PageCount != 2 || !strings.Contains(helvetica.Message, "standard font") {
+		t.Errorf("Helvetica warning %+v", helvetica)
+	}
+
+	if got := result.Pages[1].Fonts; !reflect.DeepEqual(got, []string{"F3", "Helvetica", "MSGothic"}) {
+		t.Errorf("page 2 fonts %v", got)
+	}
+	if result.Metrics.FontCount != 4 || result.Metrics.ProblemFontCount != 3 {
+		t.Errorf("metrics count %d fonts, %d with problems", result.Metrics.FontCount, result.Metrics.ProblemFontCount)
+	}
+}
+
+func TestIsSubsetFontName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"ABCDEF+Arial": true,
+		"ABCDEF+":      false,
+		"ABCdEF+Arial": false,
+		"ABCDE+Arial":  false,
+		"Arial":        false,
+		"ABCDEFG+Bold": false,
+	} {
+		if got := isSubsetFontName(name); got != want {
+			t.Errorf("isSubsetFontName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
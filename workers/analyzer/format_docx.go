@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Word processing documents, paged by the page breaks Word records
+type docxFormatAnalyzer struct{}
+
+func (df docxFormatAnalyzer) analyze(ctx context.Context, object Object, opts analysisOptions) (*contentStats, error) {
+	archive, err := zip.NewReader(object, object.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%w: docx archive: %v", ErrCorruptedDocument, err)
+	}
+
+	parts := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		parts[file.Name] = file
+	}
+
+	stats := &contentStats{}
+
+	document, ok := parts["word/document.xml"]
+	if !ok {
+		return nil, fmt.Errorf("%w: docx is missing word/document.xml", ErrUnsupportedFormat)
+	}
+	if stats.Pages, stats.TextSamples, err = readDOCXPages(ctx, document, opts); err != nil {
+		return nil, err
+	}
+	if err := checkStopped(ctx, stats); err != nil {
+		return nil, err
+	}
+
+	// Word's own page count is more accurate than counting breaks when present
+	stats.PageCount = len(stats.Pages)
+	if app, ok := parts["docProps/app.xml"]; ok {
+		pages, err := readDOCXAppPages(app, opts.limits)
+		if err != nil {
+			return nil, err
+		}
+		if pages > stats.PageCount {
+			stats.PageCount = pages
+		}
+	}
+
+	if core, ok := parts["docProps/core.xml"]; ok {
+		if stats.Metadata, err = readDOCXCoreMetadata(core, opts.limits); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, page := range stats.Pages {
+		stats.ImageCount += page.ImageCount
+	}
+	return stats, nil
+}
+
+// Stream document.xml, starting a new page at every rendered or explicit page
+// break; when ctx ends only the pages closed so far are returned
+func readDOCXPages(ctx context.Context, part *zip.File, opts analysisOptions) ([]PageReport, []pageSample, error) {
+	rc, err := part.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorruptedDocument, err)
+	}
+	defer rc.Close()
+
+	extractor := opts.extractor
+	pages := []PageReport{}
+	sampler := newTextSampler(maxLanguageSamples)
+	current := PageReport{PageNumber: 1, TextExtracted: true}
+	var pageText strings.Builder
+	inText := false
+
+	closePage := func() error {
+		current.ContentType = classifyPageContent(current.CharCount, current.ImageCount)
+		current.IsScanned = current.ContentType == PageContentScanned
+		pages = append(pages, current)
+		sampler.add(current.PageNumber, pageText.String())
+		text := strings.TrimSpace(pageText.String())
+		if err := extractor.writePage(PageText{PageNumber: current.PageNumber, Text: text}); err != nil {
+			return fmt.Errorf("writing page %d text: %w", current.PageNumber, err)
+		}
+		opts.entities.scanPage(current.PageNumber, text)
+		opts.fingerprint.endPage()
+		current = PageReport{PageNumber: len(pages) + 1, TextExtracted: true}
+		pageText.Reset()
+		return nil
+	}
+
+	// document.xml is the part a zip bomb would inflate
+	decoder := xml.NewDecoder(opts.limits.reader(rc))
+	for {
+		if ctx.Err() != nil {
+			return pages, sampler.samples, nil
+		}
+
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrLimitExceeded) {
+			return nil, nil, err
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: docx document.xml: %v", ErrCorruptedDocument, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "drawing", "pict":
+				current.ImageCount++
+			case "lastRenderedPageBreak":
+				if current.CharCount > 0 || current.ImageCount > 0 {
+					if err := closePage(); err != nil {
+						return nil, nil, err
+					}
+				}
+			case "br":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "type" && attr.Value == "page" {
+						if err := closePage(); err != nil {
+							return nil, nil, err
+						}
+					}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "t" {
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				current.CharCount += countVisibleChars(string(t))
+				opts.fingerprint.addPagePart(string(t))
+				opts.fingerprint.addPagePart(" ")
+				if opts.needsFullText() || pageText.Len() < maxSampleCharsPerPage {
+					pageText.Write(t)
+					pageText.WriteByte(' ')
+				}
+			}
+		}
+	}
+
+	if err := closePage(); err != nil {
+		return nil, nil, err
+	}
+	return pages, sampler.samples, nil
+}
+
+// Page count Word stored in docProps/app.xml, 0 when missing
+func readDOCXAppPages(part *zip.File, limits *resourceGuard) (int, error) {
+	var app struct {
+		Pages string `xml:"Pages"`
+	}
+	if err := decodeZipXML(part, limits, &app); err != nil {
+		return 0, metadataPartError(err)
+	}
+
+	pages, _ := strconv.Atoi(strings.TrimSpace(app.Pages))
+	return pages, nil
+}
+
+// Title, author and dates from docProps/core.xml
+func readDOCXCoreMetadata(part *zip.File, limits *resourceGuard) (DocumentMetadata, error) {
+	var core struct {
+		Title    string `xml:"title"`
+		Subject  string `xml:"subject"`
+		Creator  string `xml:"creator"`
+		Keywords string `xml:"keywords"`
+		Created  string `xml:"created"`
+		Modified string `xml:"modified"`
+	}
+	if err := decodeZipXML(part, limits, &core); err != nil {
+		return DocumentMetadata{}, metadataPartError(err)
+	}
+
+	metadata := DocumentMetadata{
+		Title:    core.Title,
+		Subject:  core.Subject,
+		Author:   core.Creator,
+		Keywords: core.Keywords,
+	}
+	if created, err := time.Parse(time.RFC3339, strings.TrimSpace(core.Created)); err == nil {
+		metadata.CreationDate = &created
+	}
+	if modified, err := time.Parse(time.RFC3339, strings.TrimSpace(core.Modified)); err == nil {
+		metadata.ModificationDate = &modified
+	}
+	return metadata, nil
+}
+
+// Decode a metadata part through the guard like the document body
+func decodeZipXML(part *zip.File, limits *resourceGuard, v interface{}) error {
+	rc, err := part.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return xml.NewDecoder(limits.reader(rc)).Decode(v)
+}
+
+// A limit stops the analysis, any other error in a metadata part leaves it unread
+func metadataPartError(err error) error {
+	if errors.Is(err, ErrLimitExceeded) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// TIFF files with more directories than this are treated as corrupted
+const maxTIFFPages = 10000
+
+// TIFF tags read for each page
+const (
+	tiffTagImageWidth  = 256
+	tiffTagImageLength = 257
+	tiffTagOrientation = 274
+)
+
+// Single image formats, always one scanned page
+type rasterFormatAnalyzer struct {
+	format string
+}
+
+func (rf rasterFormatAnalyzer) analyze(ctx context.Context, object Object, opts analysisOptions) (*contentStats, error) {
+	config, _, err := image.DecodeConfig(io.NewSectionReader(object, 0, object.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s image: %v", ErrUnsupportedFormat, rf.format, err)
+	}
+
+	page := imagePageReport(1, config.Width, config.Height, 0)
+	return &contentStats{
+		PageCount:  1,
+		ImageCount: 1,
+		Pages:      []PageReport{page},
+	}, nil
+}
+
+// Multi-page TIFF scans, one page per image file directory
+type tiffFormatAnalyzer struct{}
+
+func (tf tiffFormatAnalyzer) analyze(ctx context.Context, object Object, opts analysisOptions) (*contentStats, error) {
+	header := make([]byte, 8)
+	if _, err := object.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%w: tiff header: %v", ErrUnsupportedFormat, err)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if header[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	stats := &contentStats{Pages: []PageReport{}}
+	seen := make(map[uint32]bool)
+
+	// Walk the IFD chain, refusing loops and runaway page counts
+	for offset := order.Uint32(header[4:8]); offset != 0; {
+		if seen[offset] || len(stats.Pages) >= maxTIFFPages {
+			return nil, fmt.Errorf("%w: tiff directory loop at offset %d", ErrCorruptedDocument, offset)
+		}
+		seen[offset] = true
+
+		page, next, err := readTIFFDirectory(object, order, offset, len(stats.Pages)+1)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptedDocument, err)
+		}
+
+		stats.Pages = append(stats.Pages, page)
+		offset = next
+	}
+
+	stats.PageCount = len(stats.Pages)
+	stats.ImageCount = len(stats.Pages)
+	return stats, nil
+}
+
+// Read one image file directory, returning its page and the next offset
+func readTIFFDirectory(object Object, order binary.ByteOrder, offset uint32, pageNumber int) (PageReport, uint32, error) {
+	countBytes := make([]byte, 2)
+	if _, err := object.ReadAt(countBytes, int64(offset)); err != nil {
+		return PageReport{}, 0, fmt.Errorf("tiff directory at %d: %v", offset, err)
+	}
+
+	count := int(order.Uint16(countBytes))
+	entries := make([]byte, count*12+4)
+	if _, err := object.ReadAt(entries, int64(offset)+2); err != nil {
+		return PageReport{}, 0, fmt.Errorf("tiff directory at %d: %v", offset, err)
+	}
+
+	width, height, rotation := 0, 0, 0
+	for i := 0; i < count; i++ {
+		entry := entries[i*12 : i*12+12]
+		tag := order.Uint16(entry[0:2])
+		fieldType := order.Uint16(entry[2:4])
+
+		// SHORT values sit in the first two bytes, LONG values use all four
+		value := int(order.Uint32(entry[8:12]))
+		if fieldType == 3 {
+			value = int(order.Uint16(entry[8:10]))
+		}
+
+		switch tag {
+		case tiffTagImageWidth:
+			width = value
+		case tiffTagImageLength:
+			height = value
+		case tiffTagOrientation:
+			rotation = tiffOrientationRotation(value)
+		}
+	}
+
+	next := order.Uint32(entries[count*12:])
+	return imagePageReport(pageNumber, width, height, rotation), next, nil
+}
+
+// Rotation implied by the TIFF/EXIF orientation tag
+func tiffOrientationRotation(orientation int) int {
+	switch orientation {
+	case 3, 4:
+		return 180
+	case 5, 6:
+		return 90
+	case 7, 8:
+		return 270
+	}
+	return 0
+}
+
+// Image pages have no text layer and need OCR, dimensions are in pixels
+func imagePageReport(pageNumber, width, height, rotation int) PageReport {
+	return PageReport{
+		PageNumber:  pageNumber,
+		ImageCount:  1,
+		IsScanned:   true,
+		ContentType: PageContentScanned,
+		Rotation:    rotation,
+		Width:       float64(width),
+		Height:      float64(height),
+	}
+}
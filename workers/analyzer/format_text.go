@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Plain text is paged like a printout, this many lines per page
+const textLinesPerPage = 60
+
+// Longest line the text scanner accepts
+const maxTextLineBytes = 1024 * 1024
+
+// Plain text files, always text based
+type textFormatAnalyzer struct{}
+
+func (tf textFormatAnalyzer) analyze(ctx context.Context, object Object, opts analysisOptions) (*contentStats, error) {
+	extractor := opts.extractor
+	scanner := bufio.NewScanner(io.NewSectionReader(object, 0, object.Size()))
+	scanner.Buffer(make([]byte, 64*1024), maxTextLineBytes)
+
+	stats := &contentStats{Pages: []PageReport{}}
+	sampler := newTextSampler(maxLanguageSamples)
+	current := PageReport{PageNumber: 1, TextExtracted: true}
+	var pageText strings.Builder
+	lines := 0
+
+	closePage := func() error {
+		current.ContentType = classifyPageContent(current.CharCount, 0)
+		stats.Pages = append(stats.Pages, current)
+		sampler.add(current.PageNumber, pageText.String())
+		if err := extractor.writePage(PageText{PageNumber: current.PageNumber, Text: pageText.String()}); err != nil {
+			return fmt.Errorf("writing page %d text: %w", current.PageNumber, err)
+		}
+		opts.entities.scanPage(current.PageNumber, pageText.String())
+		current = PageReport{PageNumber: len(stats.Pages) + 1, TextExtracted: true}
+		pageText.Reset()
+		lines = 0
+		return nil
+	}
+
+	// Stream line by line so large files are never loaded whole
+	for scanner.Scan() {
+		line := scanner.Text()
+		current.CharCount += countVisibleChars(line)
+		opts.fingerprint.addText(line)
+		opts.fingerprint.addText("\n")
+		// Only the sample is needed unless the full text is being extracted or scanned
+		if opts.needsFullText() || pageText.Len() < maxSampleCharsPerPage {
+			pageText.WriteString(line)
+			pageText.WriteByte('\n')
+		}
+		lines++
+		if lines == textLinesPerPage {
+			if err := closePage(); err != nil {
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: reading text: %v", ErrUnsupportedFormat, err)
+	}
+	if err := checkStopped(ctx, stats); err != nil {
+		return nil, err
+	}
+
+	if lines > 0 || len(stats.Pages) == 0 {
+		if err := closePage(); err != nil {
+			return nil, err
+		}
+	}
+
+	stats.PageCount = len(stats.Pages)
+	stats.TextSamples = sampler.samples
+	return stats, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Document formats the analyzer understands
+const (
+	FormatPDF  = "pdf"
+	FormatTIFF = "tiff"
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatDOCX = "docx"
+	FormatText = "text"
+)
+
+var formatMimeTypes = map[string]string{
+	FormatPDF:  "application/pdf",
+	FormatTIFF: "image/tiff",
+	FormatPNG:  "image/png",
+	FormatJPEG: "image/jpeg",
+	FormatDOCX: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	FormatText: "text/plain",
+}
+
+// Bytes inspected when sniffing a document format
+const sniffBytes = 512
+
+// Typed format errors, match with errors.Is
+var (
+	ErrUnsupportedFormat = errors.New("unsupported document format")
+	ErrCorruptedDocument = errors.New("document is corrupted")
+)
+
+// Format specific content analysis, every format fills the same contentStats
+// so routing, pricing and chunking work the same for all of them. Analyzers
+// stop early when ctx ends, marking the stats partial at the document deadline.
+type formatAnalyzer interface {
+	analyze(ctx context.Context, object Object, opts analysisOptions) (*contentStats, error)
+}
+
+// Per-document switches for the page walk
+type analysisOptions struct {
+	extractor    *textExtractor
+	images       *imageExtractor
+	entities     *entityDetector
+	fingerprint  *textFingerprinter
+	limits       *resourceGuard
+	detectTables bool
+
+	// PDFs with at least this many pages may fan out, their walk classifies the
+	// pages and leaves extraction to the chunks; zero never defers
+	deferFromPages int
+}
+
+// Whether the walk produces anything beyond the classification
+func (opts analysisOptions) extracts() bool {
+	return opts.extractor != nil || opts.images != nil || opts.entities != nil || opts.detectTables
+}
+
+// The same options with extraction switched off
+func (opts analysisOptions) withoutExtraction() analysisOptions {
+	opts.extractor, opts.images, opts.entities, opts.detectTables = nil, nil, nil, false
+	return opts
+}
+
+// Text formats keep only a language sample per page unless something reads the
+// whole page; the fingerprint is fed as the text streams
+func (opts analysisOptions) needsFullText() bool {
+	return opts.extractor != nil || opts.entities != nil
+}
+
+// Identify a document from its leading magic bytes
+func sniffFormat(object Object) (string, error) {
+	header := make([]byte, sniffBytes)
+	n, err := object.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("%PDF-")):
+		return FormatPDF, nil
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return FormatTIFF, nil
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG, nil
+	case bytes.HasPrefix(header, []byte("\xff\xd8\xff")):
+		return FormatJPEG, nil
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		if isDOCX(object) {
+			return FormatDOCX, nil
+		}
+		return "", fmt.Errorf("%w: zip archive is not a docx document", ErrUnsupportedFormat)
+	case looksLikeText(header):
+		return FormatText, nil
+	}
+
+	return "", fmt.Errorf("%w: unrecognized magic bytes % x", ErrUnsupportedFormat, header[:minInt(len(header), 8)])
+}
+
+// A docx is a zip with a word/document.xml part
+func isDOCX(object Object) bool {
+	archive, err := zip.NewReader(object, object.Size())
+	if err != nil {
+		return false
+	}
+
+	for _, file := range archive.File {
+		if file.Name == "word/document.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid UTF-8 without control bytes other than common whitespace
+func looksLikeText(header []byte) bool {
+	if len(header) == 0 {
+		return false
+	}
+
+	// A multi-byte rune may be cut off at the end of the sample
+	for i := 0; i < utf8.UTFMax && !utf8.Valid(header); i++ {
+		header = header[:len(header)-1]
+	}
+	if !utf8.Valid(header) {
+		return false
+	}
+
+	for _, b := range header {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Adapts PDF content analysis to the formatAnalyzer interface
+type pdfFormatAnalyzer struct {
+	pa *PDFAnalyzer
+}
+
+func (pf pdfFormatAnalyzer) analyze(ctx context.Context, object Object, opts analysisOptions) (*contentStats, error) {
+	return pf.pa.analyzePDFContent(ctx, object, object.Size(), opts)
+}
+
+func (pa *PDFAnalyzer) defaultFormatAnalyzers() map[string]formatAnalyzer {
+	return map[string]formatAnalyzer{
+		FormatPDF:  pdfFormatAnalyzer{pa: pa},
+		FormatTIFF: tiffFormatAnalyzer{},
+		FormatPNG:  rasterFormatAnalyzer{format: FormatPNG},
+		FormatJPEG: rasterFormatAnalyzer{format: FormatJPEG},
+		FormatDOCX: docxFormatAnalyzer{},
+		FormatText: textFormatAnalyzer{},
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// In-memory document for tests
+type memObject struct {
+	*bytes.Reader
+}
+
+func newMemObject(data []byte) *memObject {
+	return &memObject{bytes.NewReader(data)}
+}
+
+func (mo *memObject) Close() error { return nil }
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+		err    error
+	}{
+		{"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), FormatPDF, nil},
+		{"little endian tiff", []byte("II*\x00\x08\x00\x00\x00"), FormatTIFF, nil},
+		{"big endian tiff", []byte("MM\x00*\x00\x00\x00\x08"), FormatTIFF, nil},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), FormatPNG, nil},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), FormatJPEG, nil},
+		{"docx", zipArchive(t, map[string]string{"word/document.xml": ""}), FormatDOCX, nil},
+		{"other zip", zipArchive(t, map[string]string{"xl/workbook.xml": ""}), "", ErrUnsupportedFormat},
+		{"text", []byte("Invoice 2024-001\r\n\tTotal: 12.50\n"), FormatText, nil},
+		{"utf-8 text", []byte("Grüße aus Köln"), FormatText, nil},
+		{"rune cut at the sample end", append(bytes.Repeat([]byte("a"), sniffBytes-1), "é"...), FormatText, nil},
+		{"binary", []byte("\x00\x01\x02\x03garbage"), "", ErrUnsupportedFormat},
+		{"invalid utf-8", []byte("abc\xff\xfeabc"), "", ErrUnsupportedFormat},
+		{"empty", nil, "", ErrUnsupportedFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := sniffFormat(newMemObject(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("sniffFormat() error = %v, want %v", err, tt.err)
+			}
+			if format != tt.format {
+				t.Errorf("sniffFormat() = %q, want %q", format, tt.format)
+			}
+		})
+	}
+}
+
+// Analyze an in-memory document the way the HTTP API does
+func analyzeBytes(t *testing.T, pa *PDFAnalyzer, data []byte) *AnalysisResult {
+	t.Helper()
+
+	result, err := pa.analyzeObject(context.Background(), DocumentInput{ID: t.Name()}, newMemObject(data), time.Now())
+	if err != nil {
+		t.Fatalf("analyzing %s: %v", t.Name(), err)
+	}
+	return result
+}
+
+func TestAnalyzeTextDocument(t *testing.T) {
+	// Two full printout pages and a short third one
+	var text strings.Builder
+	for i := 1; i <= 2*textLinesPerPage+3; i++ {
+		fmt.Fprintf(&text, "Line %d of the quarterly report, nothing else to see here.\n", i)
+	}
+
+	result := analyzeBytes(t, newTestAnalyzer(t), []byte(text.String()))
+	if result.Format != FormatText || result.MimeType != "text/plain" {
+		t.Errorf("format %q, mime type %q", result.Format, result.MimeType)
+	}
+	if result.PageCount != 3 || len(result.Pages) != 3 {
+		t.Fatalf("got %d pages with %d reports, want 3", result.PageCount, len(result.Pages))
+	}
+	if !result.Metrics.IsTextBased {
+		t.Error("plain text is not text based")
+	}
+	if full, short := result.Pages[0].CharCount, result.Pages[2].CharCount; short >= full || short == 0 {
+		t.Errorf("third page has %d characters, first %d", short, full)
+	}
+}
+
+func TestAnalyzeDOCX(t *testing.T) {
+	paragraph := func(text string) string {
+		return "<w:p><w:r><w:t>" + text + "</w:t></w:r></w:p>"
+	}
+	document := `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+		paragraph(strings.Repeat("Terms and conditions of the agreement. ", 10)) +
+		`<w:p><w:r><w:br w:type="page"/></w:r></w:p>` +
+		paragraph("Signed by both parties.") +
+		`<w:p><w:r><w:drawing/></w:r></w:p>` +
+		`</w:body></w:document>`
+
+	docx := zipArchive(t, map[string]string{
+		"word/document.xml": document,
+		"docProps/core.xml": `<cp:coreProperties xmlns:cp="x" xmlns:dc="y" xmlns:dcterms="z">` +
+			`<dc:title>Service Agreement</dc:title><dc:creator>Legal</dc:creator>` +
+			`<dcterms:created>2024-03-01T09:30:00Z</dcterms:created></cp:coreProperties>`,
+	})
+
+	result := analyzeBytes(t, newTestAnalyzer(t), docx)
+	if result.Format != FormatDOCX || result.PageCount != 2 {
+		t.Fatalf("format %q with %d pages, want docx with 2", result.Format, result.PageCount)
+	}
+	if result.Pages[0].ImageCount != 0 || result.Pages[1].ImageCount != 1 || result.Metrics.ImageCount != 1 {
+		t.Errorf("images per page %d and %d, %d in total", result.Pages[0].ImageCount, result.Pages[1].ImageCount, result.Metrics.ImageCount)
+	}
+	if result.Metadata.Title != "Service Agreement" || result.Metadata.Author != "Legal" {
+		t.Errorf("metadata %+v", result.Metadata)
+	}
+	if created := result.Metadata.CreationDate; created == nil || !created.Equal(time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("CreationDate = %v", created)
+	}
+
+	// Word's own page count wins over counted breaks
+	docx = zipArchive(t, map[string]string{
+		"word/document.xml": document,
+		"docProps/app.xml":  "<Properties><Pages>7</Pages></Properties>",
+	})
+	if result := analyzeBytes(t, newTestAnalyzer(t), docx); result.PageCount != 7 || len(result.Pages) != 2 {
+		t.Errorf("got %d pages with %d reports, want 7 with 2", result.PageCount, len(result.Pages))
+	}
+}
+
+func TestAnalyzeUnsupportedFormat(t *testing.T) {
+	pa := newTestAnalyzer(t)
+	for name, data := range map[string][]byte{
+		"spreadsheet":       zipArchive(t, map[string]string{"xl/workbook.xml": ""}),
+		"docx without body": zipArchive(t, map[string]string{"word/document.xml": "<w:document><w:body>"}),
+	} {
+		_, err := pa.analyzeObject(context.Background(), DocumentInput{ID: name}, newMemObject(data), time.Now())
+		if !IsPermanentError(err) {
+			t.Errorf("%s: error %v is retried", name, err)
+		}
+	}
+}
+
+// Zip archive holding files with the given names and contents
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
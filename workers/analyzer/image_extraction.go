@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Images decoding to more pixel data than this are inventoried but not extracted
+const maxExtractedImageBytes = 64 * 1024 * 1024
+
+// Summary of the images written to storage for a document
+type ImageExtraction struct {
+	Prefix        string `json:"prefix"`
+	ImagesWritten int    `json:"images_written"`
+	Skipped       int    `json:"skipped"`
+	Bytes         int64  `json:"bytes"`
+}
+
+// Writes embedded images to storage as standalone files, named by content hash
+// so an image repeated on every page is stored once
+type imageExtractor struct {
+	ctx       context.Context
+	storage   WritableStorage
+	prefix    string
+	file      io.ReaderAt
+	fileSize  int64
+	encrypted bool
+	limits    *resourceGuard
+
+	// Encoded image streams located in the raw file, built on first use
+	rawStreams []rawImageStream
+	indexed    bool
+
+	written map[string]string
+	summary ImageExtraction
+}
+
+// Prepare image extraction for a document, nil when extraction is off
+func (pa *PDFAnalyzer) newImageExtractor(ctx context.Context, input DocumentInput) (*imageExtractor, error) {
+	if !pa.extraction.ExtractImages && !input.ExtractImages {
+		return nil, nil
+	}
+
+	writable, ok := pa.storage.(WritableStorage)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage backend cannot write artifacts", ErrUnsupportedLocation)
+	}
+
+	prefix := artifactLocation(pa.extraction.ArtifactPrefix, input.ID, "images")
+	return &imageExtractor{
+		ctx:     ctx,
+		storage: writable,
+		prefix:  prefix,
+		written: make(map[string]string),
+		summary: ImageExtraction{Prefix: prefix},
+	}, nil
+}
+
+// Give the extractor the raw PDF to locate encoded image streams in, and the
+// guard image buffers are charged to while they are decoded
+func (ie *imageExtractor) attach(file io.ReaderAt, fileSize int64, encrypted bool, limits *resourceGuard) {
+	if ie == nil {
+		return
+	}
+	ie.file, ie.fileSize, ie.encrypted, ie.limits = file, fileSize, encrypted, limits
+}
+
+// Summary of what was written, nil on a nil extractor
+func (ie *imageExtractor) result() *ImageExtraction {
+	if ie == nil {
+		return nil
+	}
+	summary := ie.summary
+	return &summary
+}
+
+// Extract every image on a page, recording where each one was written.
+// Images in formats that cannot stand alone as a file are skipped
+func (ie *imageExtractor) extractPage(page pdf.Page, images []ImageInfo) error {
+	if ie == nil || len(images) == 0 {
+		return nil
+	}
+
+	streams := collectPageImages(page)
+	for i := range images {
+		if i >= len(streams) || streams[i].name != images[i].Name {
+			break
+		}
+
+		data, ext, err := ie.encodeImage(streams[i].stream, images[i])
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			ie.summary.Skipped++
+			continue
+		}
+
+		location, err := ie.write(data, ext)
+		if err != nil {
+			return err
+		}
+		images[i].Location = location
+	}
+	return nil
+}
+
+func (ie *imageExtractor) write(data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:16]) + ext
+	if location, ok := ie.written[name]; ok {
+		return location, nil
+	}
+
+	location := ie.prefix + "/" + name
+	artifact, err := ie.storage.Create(ie.ctx, location)
+	if err != nil {
+		return "", err
+	}
+	if _, err := artifact.Write(data); err != nil {
+		artifact.Abort()
+		return "", err
+	}
+	if err := artifact.Commit(); err != nil {
+		return "", err
+	}
+
+	ie.written[name] = location
+	ie.summary.ImagesWritten++
+	ie.summary.Bytes += int64(len(data))
+	return location, nil
+}
+
+// File contents and extension for an image, nil when it can't be extracted.
+// Fails only when its buffers would overspend the memory budget.
+func (ie *imageExtractor) encodeImage(stream pdf.Value, info ImageInfo) (data []byte, ext string, err error) {
+	// Streams the pdf package cannot decode panic, treat them as unsupported
+	defer func() {
+		if r := recover(); r != nil {
+			data, ext, err = nil, "", nil
+		}
+	}()
+
+	switch info.Encoding {
+	case "jpeg":
+		data, err = ie.rawImageData(stream, info)
+		return data, ".jpg", err
+	case "jpeg2000":
+		data, err = ie.rawImageData(stream, info)
+		return data, ".jp2", err
+	case "ccitt":
+		if data, err = ie.rawImageData(stream, info); data != nil {
+			return ccittTIFF(data, info, stream.Key("DecodeParms")), ".tif", nil
+		}
+		return nil, "", err
+	case "flate", "raw":
+		data, err = decodedImagePNG(stream, info, ie.limits)
+		return data, ".png", err
+	}
+
+	// JBIG2 needs its global segments, LZW and run length aren't decoded by the pdf package
+	return nil, "", nil
+}
+
+// Re-encode an image the pdf package can decode as PNG. The decoded pixels and
+// the image built from them are charged to the memory budget while held.
+func decodedImagePNG(stream pdf.Value, info ImageInfo, limits *resourceGuard) ([]byte, error) {
+	channels := 0
+	switch {
+	case info.ImageMask, info.ColorSpace == "DeviceGray", info.ColorSpace == "CalGray":
+		channels = 1
+	case info.ColorSpace == "DeviceRGB", info.ColorSpace == "CalRGB":
+		channels = 3
+	case info.ColorSpace == "ICCBased":
+		channels = int(stream.Key("ColorSpace").Index(1).Key("N").Int64())
+	}
+	if channels != 1 && channels != 3 {
+		return nil, nil
+	}
+	if info.BitsPerComponent != 8 && !(channels == 1 && info.BitsPerComponent == 1) {
+		return nil, nil
+	}
+
+	rowBytes := (info.Width*channels*info.BitsPerComponent + 7) / 8
+	size := int64(rowBytes) * int64(info.Height)
+	if info.Width <= 0 || info.Height <= 0 || size > maxExtractedImageBytes {
+		return nil, nil
+	}
+
+	// RGB is converted to NRGBA, gray images keep a byte per pixel
+	held := size + int64(info.Width)*int64(info.Height)
+	if channels == 3 {
+		held = size + 4*int64(info.Width)*int64(info.Height)
+	}
+	if err := limits.charge(held); err != nil {
+		return nil, err
+	}
+	defer limits.release(held)
+
+	rc := stream.Reader()
+	defer rc.Close()
+	pixels := make([]byte, size)
+	if _, err := io.ReadFull(rc, pixels); err != nil {
+		return nil, nil
+	}
+
+	bounds := image.Rect(0, 0, info.Width, info.Height)
+	var img image.Image
+	switch {
+	case channels == 3:
+		rgba := image.NewNRGBA(bounds)
+		for y := 0; y < info.Height; y++ {
+			for x := 0; x < info.Width; x++ {
+				p := pixels[y*rowBytes+x*3:]
+				rgba.SetNRGBA(x, y, color.NRGBA{R: p[0], G: p[1], B: p[2], A: 255})
+			}
+		}
+		img = rgba
+	case info.BitsPerComponent == 1:
+		// Image masks paint the fill colour where the bit is 0 and leave the page
+		// showing where it is 1, plain 1 bit images treat 1 as white; either way
+		// a set bit comes out white
+		gray := image.NewGray(bounds)
+		for y := 0; y < info.Height; y++ {
+			for x := 0; x < info.Width; x++ {
+				bit := pixels[y*rowBytes+x/8] >> (7 - uint(x%8)) & 1
+				if bit == 1 {
+					gray.SetGray(x, y, color.Gray{Y: 255})
+				}
+			}
+		}
+		img = gray
+	default:
+		gray := image.NewGray(bounds)
+		for y := 0; y < info.Height; y++ {
+			copy(gray.Pix[y*gray.Stride:], pixels[y*rowBytes:(y+1)*rowBytes])
+		}
+		img = gray
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// Wrap CCITT fax data in a single strip TIFF so it opens as an image file
+func ccittTIFF(data []byte, info ImageInfo, params pdf.Value) []byte {
+	// K < 0 is pure two dimensional Group 4, anything else Group 3
+	compression, options := uint32(4), uint32(0)
+	if k := params.Key("K").Int64(); k >= 0 {
+		compression = 3
+		if k > 0 {
+			options = 1
+		}
+	}
+
+	// PDF defaults to 0 for white, BlackIs1 flips it
+	photometric := uint32(0)
+	if params.Key("BlackIs1").Bool() {
+		photometric = 1
+	}
+
+	type entry struct {
+		tag, kind uint16
+		value     uint32
+	}
+	entries := []entry{
+		{256, 4, uint32(info.Width)},
+		{257, 4, uint32(info.Height)},
+		{258, 3, 1},
+		{259, 3, compression},
+		{262, 3, photometric},
+		{273, 4, 0}, // strip offset, filled in below
+		{277, 3, 1},
+		{278, 4, uint32(info.Height)},
+		{279, 4, uint32(len(data))},
+	}
+	if compression == 3 {
+		entries = append(entries, entry{292, 4, options})
+	} else {
+		entries = append(entries, entry{293, 4, 0})
+	}
+
+	ifdSize := 2 + len(entries)*12 + 4
+	entries[5].value = uint32(8 + ifdSize)
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, binary.LittleEndian, uint16(42))
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e.tag)
+		binary.Write(&buf, binary.LittleEndian, e.kind)
+		binary.Write(&buf, binary.LittleEndian, uint32(1))
+		if e.kind == 3 {
+			binary.Write(&buf, binary.LittleEndian, uint16(e.value))
+			binary.Write(&buf, binary.LittleEndian, uint16(0))
+		} else {
+			binary.Write(&buf, binary.LittleEndian, e.value)
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// ==================== RAW STREAMS ====================
+
+// The pdf package only decodes Flate and ASCII85 and keeps stream offsets to
+// itself, so encoded image bytes are found by scanning the file for image
+// stream headers and matching them on their dictionary values
+type rawImageStream struct {
+	offset int64
+	width  int
+	height int
+	length int64 // -1 when the dictionary holds an indirect length
+	used   bool
+}
+
+// How far before a stream keyword its dictionary can start
+const rawDictLookBack = 4096
+
+var (
+	rawWidthPattern  = regexp.MustCompile(`/Width\s+(\d+)`)
+	rawHeightPattern = regexp.MustCompile(`/Height\s+(\d+)`)
+	rawLengthPattern = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	rawImagePattern  = regexp.MustCompile(`/Subtype\s*/Image\b`)
+)
+
+// Raw encoded bytes of a single filter image stream, nil when not found. The
+// bytes are charged to the memory budget while they are read.
+func (ie *imageExtractor) rawImageData(stream pdf.Value, info ImageInfo) ([]byte, error) {
+	// Transport filters in front of the codec would need decoding first,
+	// and encrypted files only hold the ciphertext
+	if ie.encrypted || len(info.Filters) != 1 || info.EncodedBytes <= 0 || info.EncodedBytes > maxExtractedImageBytes {
+		return nil, nil
+	}
+
+	if !ie.indexed {
+		ie.rawStreams = indexRawImageStreams(ie.file, ie.fileSize)
+		ie.indexed = true
+	}
+
+	candidate := ie.matchRawStream(info)
+	if candidate == nil {
+		return nil, nil
+	}
+
+	if err := ie.limits.charge(info.EncodedBytes); err != nil {
+		return nil, err
+	}
+	defer ie.limits.release(info.EncodedBytes)
+
+	data := make([]byte, info.EncodedBytes)
+	if _, err := ie.file.ReadAt(data, candidate.offset); err != nil {
+		return nil, nil
+	}
+	candidate.used = true
+	return data, nil
+}
+
+// The one stream not handed out yet that matches an image. Streams with an
+// indirect length match on their size alone, so when several match, or only one
+// already handed out does, the bytes could be another page's image and nothing
+// is returned; the image is reported as not extracted.
+func (ie *imageExtractor) matchRawStream(info ImageInfo) *rawImageStream {
+	var match *rawImageStream
+	for i := range ie.rawStreams {
+		candidate := &ie.rawStreams[i]
+		if candidate.used {
+			continue
+		}
+		if candidate.width != info.Width || candidate.height != info.Height {
+			continue
+		}
+		if candidate.length >= 0 && candidate.length != info.EncodedBytes {
+			continue
+		}
+		if match != nil {
+			return nil
+		}
+		match = candidate
+	}
+	return match
+}
+
+// Find every image stream in the file by its "stream" keyword and dictionary
+func indexRawImageStreams(file io.ReaderAt, size int64) []rawImageStream {
+	const block = 1 << 20
+	var streams []rawImageStream
+	keyword := []byte("stream")
+
+	for start := int64(0); start < size; start += block {
+		from := start - rawDictLookBack
+		if from < 0 {
+			from = 0
+		}
+		to := start + block + 2
+		if to > size {
+			to = size
+		}
+
+		window := make([]byte, to-from)
+		n, _ := file.ReadAt(window, from)
+		window = window[:n]
+
+		for i := 0; ; {
+			idx := bytes.Index(window[i:], keyword)
+			if idx < 0 {
+				break
+			}
+			pos := i + idx
+			i = pos + len(keyword)
+
+			// Only keywords starting in this block, and not endstream
+			if from+int64(pos) < start || from+int64(pos) >= start+block {
+				continue
+			}
+			if pos >= 3 && string(window[pos-3:pos]) == "end" {
+				continue
+			}
+
+			dataStart := pos + len(keyword)
+			switch {
+			case dataStart+1 < len(window) && window[dataStart] == '\r' && window[dataStart+1] == '\n':
+				dataStart += 2
+			case dataStart < len(window) && (window[dataStart] == '\n' || window[dataStart] == '\r'):
+				dataStart++
+			default:
+				continue
+			}
+
+			dictStart := pos - rawDictLookBack
+			if dictStart < 0 {
+				dictStart = 0
+			}
+			dict := window[dictStart:pos]
+			if obj := bytes.LastIndex(dict, []byte(" obj")); obj >= 0 {
+				dict = dict[obj:]
+			}
+			if !rawImagePattern.Match(dict) {
+				continue
+			}
+
+			stream := rawImageStream{offset: from + int64(dataStart), length: -1}
+			stream.width = atoiMatch(rawWidthPattern, dict)
+			stream.height = atoiMatch(rawHeightPattern, dict)
+			if m := rawLengthPattern.FindSubmatch(dict); m != nil && len(m[2]) == 0 {
+				stream.length, _ = strconv.ParseInt(string(m[1]), 10, 64)
+			}
+			streams = append(streams, stream)
+		}
+	}
+	return streams
+}
+
+func atoiMatch(pattern *regexp.Regexp, data []byte) int {
+	m := pattern.FindSubmatch(data)
+	if m == nil {
+		return 0
+	}
+	value, _ := strconv.Atoi(string(m[1]))
+	return value
+}
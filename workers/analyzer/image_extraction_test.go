@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractImages(t *testing.T) {
+	root := t.TempDir()
+	pa := newExtractingAnalyzer(t, root)
+
+	result, err := pa.AnalyzeDocument(context.Background(), DocumentInput{ID: "scans", FilePath: "images.pdf", ExtractImages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The image repeated as the page 2 scan is stored once, JBIG2 is skipped
+	summary := result.ImageExtraction
+	if summary == nil || summary.Prefix != "artifacts/scans/images" || summary.ImagesWritten != 4 || summary.Skipped != 1 {
+		t.Fatalf("ImageExtraction = %+v", summary)
+	}
+	images := result.Pages[0].Images
+	if images[0].Location == "" || images[0].Location != result.Pages[1].Images[0].Location {
+		t.Errorf("repeated image stored at %q and %q", images[0].Location, result.Pages[1].Images[0].Location)
+	}
+	if images[4].Location != "" {
+		t.Errorf("JBIG2 image extracted to %s", images[4].Location)
+	}
+
+	var written int64
+	files := make(map[string][]byte)
+	for _, info := range images[:4] {
+		if !strings.HasPrefix(info.Location, summary.Prefix+"/") {
+			t.Fatalf("%s stored at %q", info.Name, info.Location)
+		}
+		data, err := os.ReadFile(filepath.Join(root, info.Location))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[info.Name] = data
+		written += int64(len(data))
+	}
+	if written != summary.Bytes {
+		t.Errorf("files hold %d bytes, summary reports %d", written, summary.Bytes)
+	}
+
+	t.Run("flate rgb as png", func(t *testing.T) {
+		img := decodePNG(t, files["Im1"], ".png", images[0].Location)
+		want := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+		for x, c := range want {
+			if got := color.NRGBAModel.Convert(img.At(x, 0)); got != c {
+				t.Errorf("pixel %d,0 = %v, want %v", x, got, c)
+			}
+		}
+		if got := color.NRGBAModel.Convert(img.At(0, 1)); got != (color.NRGBA{0, 0, 0, 255}) {
+			t.Errorf("pixel 0,1 = %v, want black", got)
+		}
+	})
+
+	t.Run("image mask as png", func(t *testing.T) {
+		// Rows 0x0f and 0xf0, the mask paints where a bit is 0
+		img := decodePNG(t, files["Im2"], ".png", images[1].Location)
+		for x := 0; x < 8; x++ {
+			top, bottom := color.GrayModel.Convert(img.At(x, 0)).(color.Gray), color.GrayModel.Convert(img.At(x, 1)).(color.Gray)
+			if painted := x < 4; (top.Y == 0) != painted || (bottom.Y == 0) == painted {
+				t.Errorf("column %d: top %d, bottom %d", x, top.Y, bottom.Y)
+			}
+		}
+	})
+
+	t.Run("dct copied as jpeg", func(t *testing.T) {
+		if want := "\xff\xd8\xff\xe0not really a jpeg\xff\xd9"; string(files["Im3"]) != want || !strings.HasSuffix(images[2].Location, ".jpg") {
+			t.Errorf("%s holds %q", images[2].Location, files["Im3"])
+		}
+	})
+
+	t.Run("ccitt wrapped in tiff", func(t *testing.T) {
+		data := files["Im4"]
+		if !strings.HasSuffix(images[3].Location, ".tif") || !bytes.HasPrefix(data, []byte("II*\x00")) {
+			t.Fatalf("%s starts % x", images[3].Location, data[:4])
+		}
+		// K -1 is Group 4, BlackIs1 makes 1 black
+		tags := tiffTags(t, data)
+		for tag, want := range map[uint16]uint32{256: 32, 257: 8, 259: 4, 262: 1, 279: 5} {
+			if tags[tag] != want {
+				t.Errorf("tag %d = %d, want %d", tag, tags[tag], want)
+			}
+		}
+		if strip := data[tags[273]:]; !bytes.Equal(strip, []byte("\x26\xa0\x00\x10\x01")) {
+			t.Errorf("strip holds % x", strip)
+		}
+	})
+}
+
+func TestDecodedImageMemoryBudget(t *testing.T) {
+	object, err := os.Open("testdata/images.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	info, err := object.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openPDFReader(object, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgb := collectPageImages(reader.Page(1))[0]
+
+	// 4x2 RGB holds 24 bytes of pixels and 32 of NRGBA while it is encoded
+	for budget, fits := range map[int64]bool{55: false, 56: true} {
+		limits := newResourceGuard(ResourceLimits{MaxMemory: budget})
+		data, err := decodedImagePNG(rgb.stream, describeImage(rgb), limits)
+		if fits != (err == nil && data != nil) {
+			t.Errorf("budget %d: %d bytes, error %v", budget, len(data), err)
+		}
+		if !fits && !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("budget %d: error %v, want a resource limit", budget, err)
+		}
+		if held := limits.held.Load(); held != 0 {
+			t.Errorf("budget %d: %d bytes still charged", budget, held)
+		}
+	}
+}
+
+func decodePNG(t *testing.T, data []byte, ext, location string) image.Image {
+	t.Helper()
+
+	if !strings.HasSuffix(location, ext) {
+		t.Fatalf("stored at %s, want a %s file", location, ext)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// Single valued tags of the first IFD of a little endian TIFF
+func tiffTags(t *testing.T, data []byte) map[uint16]uint32 {
+	t.Helper()
+
+	le := binary.LittleEndian
+	ifd := le.Uint32(data[4:])
+	count := int(le.Uint16(data[ifd:]))
+	tags := make(map[uint16]uint32)
+	for i := 0; i < count; i++ {
+		entry := data[int(ifd)+2+i*12:]
+		value := le.Uint32(entry[8:])
+		if le.Uint16(entry[2:]) == 3 {
+			value = uint32(le.Uint16(entry[8:]))
+		}
+		tags[le.Uint16(entry)] = value
+	}
+	return tags
+}
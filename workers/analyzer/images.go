@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// A single image drawn over at least this much of the page is a full-page scan
+const fullPageCoverage = 0.85
+
+// Form XObjects can nest, stop following them past this depth
+const maxFormDepth = 5
+
+// Image classifications
+const (
+	ImageKindScan    = "scan"
+	ImageKindPhoto   = "photo"
+	ImageKindMask    = "mask"
+	ImageKindGraphic = "graphic"
+)
+
+// Stream encodings for the image codecs PDF supports
+var imageEncodings = map[string]string{
+	"DCTDecode":       "jpeg",
+	"JPXDecode":       "jpeg2000",
+	"JBIG2Decode":     "jbig2",
+	"CCITTFaxDecode":  "ccitt",
+	"FlateDecode":     "flate",
+	"LZWDecode":       "lzw",
+	"RunLengthDecode": "run_length",
+}
+
+// An embedded image XObject and how it is used on the page
+type ImageInfo struct {
+	Name             string   `json:"name"`
+	Kind             string   `json:"kind"`
+	Width            int      `json:"width"`
+	Height           int      `json:"height"`
+	ColorSpace       string   `json:"color_space"`
+	BitsPerComponent int      `json:"bits_per_component"`
+	Filters          []string `json:"filters"`
+	Encoding         string   `json:"encoding"`
+	ImageMask        bool     `json:"image_mask,omitempty"`
+	EncodedBytes     int64    `json:"encoded_bytes"`
+
+	// Share of the page the image is drawn over and its effective resolution,
+	// zero when the content stream could not be followed
+	Coverage float64 `json:"coverage"`
+	DPI      float64 `json:"dpi,omitempty"`
+
+	// Storage location when images are extracted
+	Location string `json:"location,omitempty"`
+}
+
+// An image XObject found in the page resources, names of nested forms are
+// prefixed with the form name
+type pageImage struct {
+	name   string
+	stream pdf.Value
+}
+
+// Every image XObject reachable from the page resources, including those
+// inside form XObjects
+func collectPageImages(page pdf.Page) []pageImage {
+	var images []pageImage
+	collectImageXObjects(page.Resources(), "", 0, &images)
+	return images
+}
+
+func collectImageXObjects(resources pdf.Value, prefix string, depth int, images *[]pageImage) {
+	xobjects := resources.Key("XObject")
+	for _, name := range xobjects.Keys() {
+		xobject := xobjects.Key(name)
+		switch xobject.Key("Subtype").Name() {
+		case "Image":
+			*images = append(*images, pageImage{name: prefix + name, stream: xobject})
+		case "Form":
+			if depth < maxFormDepth {
+				collectImageXObjects(formResources(xobject, resources), prefix+name+"/", depth+1, images)
+			}
+		}
+	}
+}
+
+// Inventory of the images on a page and whether it is a single full-page scan
+func pageImageInventory(page pdf.Page) ([]ImageInfo, bool) {
+	images := collectPageImages(page)
+	if len(images) == 0 {
+		return nil, false
+	}
+
+	placements := imagePlacements(page)
+	box := pageBox(page)
+	pageArea := (box[2] - box[0]) * (box[3] - box[1])
+
+	infos := make([]ImageInfo, 0, len(images))
+	drawn := 0
+	for _, image := range images {
+		info := describeImage(image)
+
+		if rect, ok := placements[image.name]; ok {
+			drawn++
+			if pageArea > 0 {
+				info.Coverage = roundTo(math.Min(rect.intersect(box).area()/pageArea, 1), 3)
+			}
+			if area := rect.area(); area > 0 && info.Width > 0 && info.Height > 0 {
+				// Geometric mean of both axes, unaffected by rotation
+				info.DPI = math.Round(math.Sqrt(float64(info.Width*info.Height) / (area / (72 * 72))))
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	fullPageScan := drawn == 1 && len(infos) == 1 && infos[0].Coverage >= fullPageCoverage
+	for i := range infos {
+		infos[i].Kind = classifyImage(infos[i], fullPageScan)
+	}
+	return infos, fullPageScan
+}
+
+func describeImage(image pageImage) ImageInfo {
+	stream := image.stream
+	info := ImageInfo{
+		Name:             image.name,
+		Width:            int(stream.Key("Width").Int64()),
+		Height:           int(stream.Key("Height").Int64()),
+		ColorSpace:       colorSpaceName(stream.Key("ColorSpace")),
+		BitsPerComponent: int(stream.Key("BitsPerComponent").Int64()),
+		Filters:          streamFilters(stream),
+		ImageMask:        stream.Key("ImageMask").Bool(),
+		EncodedBytes:     stream.Key("Length").Int64(),
+	}
+
+	// The last filter is the image codec, earlier ones are transport encodings
+	info.Encoding = "raw"
+	if n := len(info.Filters); n > 0 {
+		if encoding, ok := imageEncodings[info.Filters[n-1]]; ok {
+			info.Encoding = encoding
+		} else {
+			info.Encoding = info.Filters[n-1]
+		}
+	}
+	if info.ImageMask {
+		info.BitsPerComponent = 1
+	}
+	return info
+}
+
+func classifyImage(info ImageInfo, fullPageScan bool) string {
+	switch {
+	case fullPageScan:
+		return ImageKindScan
+	case info.ImageMask || info.BitsPerComponent == 1:
+		return ImageKindMask
+	case info.Encoding == "jpeg" || info.Encoding == "jpeg2000":
+		return ImageKindPhoto
+	}
+	return ImageKindGraphic
+}
+
+func streamFilters(stream pdf.Value) []string {
+	filter := stream.Key("Filter")
+	switch filter.Kind() {
+	case pdf.Name:
+		return []string{filter.Name()}
+	case pdf.Array:
+		filters := make([]string, 0, filter.Len())
+		for i := 0; i < filter.Len(); i++ {
+			filters = append(filters, filter.Index(i).Name())
+		}
+		return filters
+	}
+	return []string{}
+}
+
+// Family name of a color space, [/ICCBased ...] and friends report the family
+func colorSpaceName(cs pdf.Value) string {
+	switch cs.Kind() {
+	case pdf.Name:
+		return cs.Name()
+	case pdf.Array:
+		if cs.Len() > 0 {
+			return cs.Index(0).Name()
+		}
+	}
+	return ""
+}
+
+// ==================== PLACEMENT ====================
+
+// Axis aligned rectangle in default user space
+type rect [4]float64
+
+func (r rect) area() float64 {
+	return math.Max(r[2]-r[0], 0) * math.Max(r[3]-r[1], 0)
+}
+
+func (r rect) intersect(o rect) rect {
+	return rect{math.Max(r[0], o[0]), math.Max(r[1], o[1]), math.Min(r[2], o[2]), math.Min(r[3], o[3])}
+}
+
+// PDF transformation matrix [a b c d e f]
+type matrix [6]float64
+
+var identityMatrix = matrix{1, 0, 0, 1, 0, 0}
+
+// m applied first, then n
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// Bounding box of the unit square, where images are drawn, under m
+func (m matrix) unitSquare() rect {
+	xs := []float64{m[4], m[0] + m[4], m[2] + m[4], m[0] + m[2] + m[4]}
+	ys := []float64{m[5], m[1] + m[5], m[3] + m[5], m[1] + m[3] + m[5]}
+	r := rect{xs[0], ys[0], xs[0], ys[0]}
+	for i := 1; i < 4; i++ {
+		r[0], r[2] = math.Min(r[0], xs[i]), math.Max(r[2], xs[i])
+		r[1], r[3] = math.Min(r[1], ys[i]), math.Max(r[3], ys[i])
+	}
+	return r
+}
+
+// Forms without their own resources use the resources of the page drawing them
+func formResources(form, parent pdf.Value) pdf.Value {
+	if resources := form.Key("Resources"); !resources.IsNull() {
+		return resources
+	}
+	return parent
+}
+
+func matrixFromValue(v pdf.Value) matrix {
+	if v.Len() != 6 {
+		return identityMatrix
+	}
+	var m matrix
+	for i := range m {
+		m[i] = v.Index(i).Float64()
+	}
+	return m
+}
+
+// Where each image is drawn, following the graphics state through the page
+// and any form XObjects; the largest placement wins for images drawn twice
+func imagePlacements(page pdf.Page) (placements map[string]rect) {
+	placements = make(map[string]rect)
+
+	// Interpret panics on content it cannot tokenize, such as inline image data
+	defer func() {
+		if r := recover(); r != nil {
+			placements = make(map[string]rect)
+		}
+	}()
+
+	walkContentStreams(page.V.Key("Contents"), page.Resources(), "", identityMatrix, 0, placements)
+	return placements
+}
+
+func walkContentStreams(contents, resources pdf.Value, prefix string, ctm matrix, depth int, placements map[string]rect) {
+	if contents.Kind() == pdf.Array {
+		for i := 0; i < contents.Len(); i++ {
+			walkContentStreams(contents.Index(i), resources, prefix, ctm, depth, placements)
+		}
+		return
+	}
+
+	var saved []matrix
+	pdf.Interpret(contents, func(stk *pdf.Stack, op string) {
+		n := stk.Len()
+		args := make([]pdf.Value, n)
+		for i := n - 1; i >= 0; i-- {
+			args[i] = stk.Pop()
+		}
+
+		switch op {
+		case "q":
+			saved = append(saved, ctm)
+		case "Q":
+			if len(saved) > 0 {
+				ctm = saved[len(saved)-1]
+				saved = saved[:len(saved)-1]
+			}
+		case "cm":
+			if n == 6 {
+				var m matrix
+				for i := range m {
+					m[i] = args[i].Float64()
+				}
+				ctm = m.mul(ctm)
+			}
+		case "Do":
+			if n != 1 {
+				return
+			}
+			name := args[0].Name()
+			xobject := resources.Key("XObject").Key(name)
+			switch xobject.Key("Subtype").Name() {
+			case "Image":
+				drawn := ctm.unitSquare()
+				if previous, ok := placements[prefix+name]; !ok || drawn.area() > previous.area() {
+					placements[prefix+name] = drawn
+				}
+			case "Form":
+				if depth < maxFormDepth {
+					formCTM := matrixFromValue(xobject.Key("Matrix")).mul(ctm)
+					walkContentStreams(xobject, formResources(xobject, resources), prefix+name+"/", formCTM, depth+1, placements)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImageInventory(t *testing.T) {
+	result := analyzeFixture(t, newTestAnalyzer(t), "images.pdf")
+	if len(result.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(result.Pages))
+	}
+
+	type image struct {
+		name, kind, encoding string
+		width, height        int
+		coverage             float64
+	}
+	var got []image
+	for _, info := range result.Pages[0].Images {
+		got = append(got, image{info.Name, info.Kind, info.Encoding, info.Width, info.Height, info.Coverage})
+		if info.Location != "" {
+			t.Errorf("%s extracted without being asked for", info.Name)
+		}
+	}
+
+	// Coverage is the drawn rectangle over the 612x792 page
+	want := []image{
+		{"Im1", ImageKindGraphic, "flate", 4, 2, 0.021},
+		{"Im2", ImageKindMask, "raw", 8, 2, 0.003},
+		{"Im3", ImageKindPhoto, "jpeg", 16, 16, 0.011},
+		{"Im4", ImageKindMask, "ccitt", 32, 8, 0.043},
+		{"Im5", ImageKindMask, "jbig2", 8, 8, 0.011},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("page 1 images\n got %+v\nwant %+v", got, want)
+	}
+	if result.Pages[0].FullPageScan {
+		t.Error("page of several small images reported as a scan")
+	}
+
+	// One image drawn over the whole page is a scan, whatever its codec
+	scan := result.Pages[1]
+	if !scan.FullPageScan || len(scan.Images) != 1 || scan.Images[0].Kind != ImageKindScan || scan.Images[0].Coverage != 1 {
+		t.Errorf("page 2 %+v, want a full page scan", scan)
+	}
+	if result.Metrics.ImageCount != 6 || result.Metrics.IsTextBased {
+		t.Errorf("metrics %+v", result.Metrics)
+	}
+}
+
+func TestMatrixPlacement(t *testing.T) {
+	// A 200x100 image scaled, rotated a quarter turn and moved keeps its area
+	place := matrix{200, 0, 0, 100, 0, 0}.mul(matrix{0, 1, -1, 0, 300, 50})
+	box := place.unitSquare()
+	if box != (rect{200, 50, 300, 250}) {
+		t.Errorf("unitSquare() = %v", box)
+	}
+	if area := box.intersect(rect{0, 0, 250, 792}).area(); area != 50*200 {
+		t.Errorf("area inside the page = %v, want %v", area, 50*200)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Text sampling limits for language detection
+const (
+	maxLanguageSamples     = 10
+	maxSampleCharsPerPage  = 4000
+	minLettersForDetection = 10
+	minLanguageConfidence  = 0.5
+)
+
+// Language code used when text is present but cannot be identified
+const languageUndetermined = "und"
+
+// Detected languages and writing systems of a document
+type LanguageReport struct {
+	PrimaryLanguage string          `json:"primary_language"`
+	Confidence      float64         `json:"confidence"`
+	Languages       []LanguageScore `json:"languages"`
+	Scripts         []ScriptScore   `json:"scripts"`
+	SampledPages    []int           `json:"sampled_pages"`
+	SampledLetters  int             `json:"sampled_letters"`
+}
+
+type LanguageScore struct {
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence"`
+}
+
+type ScriptScore struct {
+	Script string  `json:"script"`
+	Share  float64 `json:"share"`
+}
+
+// Scripts in the order they are checked
+var scriptTables = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+	{"Arabic", unicode.Arabic},
+	{"Hebrew", unicode.Hebrew},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+	{"Devanagari", unicode.Devanagari},
+	{"Thai", unicode.Thai},
+}
+
+// Scripts used by exactly one of the languages we detect
+var scriptLanguages = map[string]string{
+	"Greek":      "el",
+	"Hebrew":     "he",
+	"Hangul":     "ko",
+	"Devanagari": "hi",
+	"Thai":       "th",
+}
+
+// Frequent function words, used to tell Latin script languages apart
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "in", "is", "that", "for", "it", "with", "as", "was", "on", "be", "by", "this", "are", "or", "from", "which", "have", "not", "will", "shall"},
+	"fr": {"le", "la", "les", "et", "des", "est", "un", "une", "du", "que", "pour", "dans", "qui", "par", "sur", "pas", "au", "avec", "ce", "sont", "aux", "entre"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "den", "mit", "von", "zu", "sich", "des", "auf", "für", "ein", "eine", "dem", "auch", "wird", "oder"},
+	"es": {"el", "los", "las", "y", "que", "del", "se", "por", "con", "una", "para", "es", "su", "al", "lo", "como", "más", "pero", "sus", "entre"},
+	"it": {"il", "di", "che", "e", "della", "per", "un", "una", "sono", "gli", "del", "con", "non", "nel", "alla", "questo", "anche", "come", "dei", "delle"},
+	"pt": {"o", "os", "que", "do", "da", "em", "um", "uma", "para", "com", "não", "se", "na", "no", "por", "mais", "dos", "das", "ao", "como"},
+	"nl": {"de", "het", "een", "en", "van", "dat", "op", "te", "zijn", "voor", "met", "niet", "aan", "er", "ook", "als", "bij", "door", "wordt", "deze"},
+}
+
+// Collects page text evenly across a document without knowing its length.
+// Pages are kept at a fixed stride, when the sampler fills up every other
+// sample is dropped and the stride doubles.
+type textSampler struct {
+	max     int
+	stride  int
+	samples []pageSample
+}
+
+type pageSample struct {
+	PageNumber int
+	Text       string
+}
+
+func newTextSampler(max int) *textSampler {
+	return &textSampler{max: max, stride: 1}
+}
+
+func (ts *textSampler) add(pageNumber int, text string) {
+	if (pageNumber-1)%ts.stride != 0 {
+		return
+	}
+
+	if len(text) > maxSampleCharsPerPage {
+		// Back up to a rune boundary so the sample doesn't end in a split character
+		cut := maxSampleCharsPerPage
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	ts.samples = append(ts.samples, pageSample{PageNumber: pageNumber, Text: text})
+
+	if len(ts.samples) > ts.max {
+		kept := ts.samples[:0]
+		for i, sample := range ts.samples {
+			if i%2 == 0 {
+				kept = append(kept, sample)
+			}
+		}
+		ts.samples = kept
+		ts.stride *= 2
+	}
+}
+
+// Detect languages page by page and weight them by letters per page
+func detectLanguages(samples []pageSample) *LanguageReport {
+	report := &LanguageReport{
+		Languages:    []LanguageScore{},
+		Scripts:      []ScriptScore{},
+		SampledPages: []int{},
+	}
+
+	scriptCounts := make(map[string]int)
+	languageWeights := make(map[string]float64)
+
+	for _, sample := range samples {
+		report.SampledPages = append(report.SampledPages, sample.PageNumber)
+
+		counts, letters := countScripts(sample.Text)
+		if letters < minLettersForDetection {
+			continue
+		}
+
+		for script, count := range counts {
+			scriptCounts[script] += count
+		}
+		report.SampledLetters += letters
+
+		for language, confidence := range detectSampleLanguages(sample.Text, counts, letters) {
+			languageWeights[language] += confidence * float64(letters)
+		}
+	}
+
+	if report.SampledLetters == 0 {
+		return report
+	}
+
+	for script, count := range scriptCounts {
+		report.Scripts = append(report.Scripts, ScriptScore{
+			Script: script,
+			Share:  roundTo(float64(count)/float64(report.SampledLetters), 3),
+		})
+	}
+	sort.Slice(report.Scripts, func(i, j int) bool {
+		return report.Scripts[i].Share > report.Scripts[j].Share
+	})
+
+	for language, weight := range languageWeights {
+		report.Languages = append(report.Languages, LanguageScore{
+			Language:   language,
+			Confidence: roundTo(weight/float64(report.SampledLetters), 3),
+		})
+	}
+	sort.Slice(report.Languages, func(i, j int) bool {
+		if report.Languages[i].Confidence == report.Languages[j].Confidence {
+			return report.Languages[i].Language < report.Languages[j].Language
+		}
+		return report.Languages[i].Confidence > report.Languages[j].Confidence
+	})
+
+	if len(report.Languages) > 0 {
+		report.PrimaryLanguage = report.Languages[0].Language
+		report.Confidence = report.Languages[0].Confidence
+	}
+	return report
+}
+
+// Letters per script in text, and the total letter count
+func countScripts(text string) (map[string]int, int) {
+	counts := make(map[string]int)
+	letters := 0
+
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+
+		script := "Other"
+		for _, st := range scriptTables {
+			if unicode.Is(st.table, r) {
+				script = st.name
+				break
+			}
+		}
+		counts[script]++
+	}
+
+	return counts, letters
+}
+
+// Language confidences for a single sample, they add up to at most 1
+func detectSampleLanguages(text string, scripts map[string]int, letters int) map[string]float64 {
+	languages := make(map[string]float64)
+	share := func(script string) float64 {
+		return float64(scripts[script]) / float64(letters)
+	}
+
+	// Kana marks Japanese, so Han characters alongside it count as Japanese too
+	if kana := scripts["Hiragana"] + scripts["Katakana"]; kana > 0 {
+		languages["ja"] += share("Hiragana") + share("Katakana") + share("Han")
+	} else if scripts["Han"] > 0 {
+		languages["zh"] += share("Han")
+	}
+
+	for script, language := range scriptLanguages {
+		if scripts[script] > 0 {
+			languages[language] += share(script)
+		}
+	}
+
+	if scripts["Arabic"] > 0 {
+		languages[arabicScriptLanguage(text)] += share("Arabic")
+	}
+	if scripts["Cyrillic"] > 0 {
+		languages[cyrillicScriptLanguage(text)] += share("Cyrillic")
+	}
+
+	if scripts["Latin"] > 0 {
+		for language, confidence := range detectLatinLanguage(text) {
+			languages[language] += confidence * share("Latin")
+		}
+	}
+
+	return languages
+}
+
+// Score Latin text against each stopword list
+func detectLatinLanguage(text string) map[string]float64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if len(words) == 0 {
+		return map[string]float64{languageUndetermined: 1}
+	}
+
+	hits := make(map[string]int)
+	totalHits := 0
+	for _, word := range words {
+		for language, stopwords := range latinStopwords {
+			for _, stopword := range stopwords {
+				if word == stopword {
+					hits[language]++
+					totalHits++
+					break
+				}
+			}
+		}
+	}
+
+	if totalHits == 0 {
+		return map[string]float64{languageUndetermined: 1}
+	}
+
+	// Function words are a large share of running prose, when fewer than 25%
+	// of the words are stopwords (tables, codes, names) confidence drops
+	coverage := math.Min(float64(totalHits)/float64(len(words))/0.25, 1)
+
+	// Give the best match most of the weight, ties split it
+	best := 0
+	for _, count := range hits {
+		if count > best {
+			best = count
+		}
+	}
+
+	scores := make(map[string]float64)
+	sum := 0.0
+	for language, count := range hits {
+		score := math.Pow(float64(count)/float64(best), 4)
+		scores[language] = score
+		sum += score
+	}
+	for language := range scores {
+		scores[language] = scores[language] / sum * coverage
+	}
+	if coverage < 1 {
+		scores[languageUndetermined] = 1 - coverage
+	}
+	return scores
+}
+
+// Persian adds letters that Arabic does not use
+func arabicScriptLanguage(text string) string {
+	if strings.ContainsAny(text, "پچژگ") {
+		return "fa"
+	}
+	return "ar"
+}
+
+// Ukrainian has letters Russian does not use
+func cyrillicScriptLanguage(text string) string {
+	if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+		return "uk"
+	}
+	return "ru"
+}
+
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
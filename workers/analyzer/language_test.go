@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTextSamplerCutsOnRuneBoundary(t *testing.T) {
+	// Two byte runes starting on odd offsets put a continuation byte at the cut
+	text := "a" + strings.Repeat("é", maxSampleCharsPerPage)
+
+	sampler := newTextSampler(maxLanguageSamples)
+	sampler.add(1, text)
+
+	sample := sampler.samples[0].Text
+	if !utf8.ValidString(sample) {
+		t.Fatalf("sample ends in a split rune: % x", sample[len(sample)-2:])
+	}
+	if len(sample) != maxSampleCharsPerPage-1 {
+		t.Errorf("sample is %d bytes, want %d", len(sample), maxSampleCharsPerPage-1)
+	}
+}
+
+func TestTextSamplerSpreadsAcrossDocument(t *testing.T) {
+	sampler := newTextSampler(10)
+	for page := 1; page <= 100; page++ {
+		sampler.add(page, "page text")
+	}
+
+	// The stride doubled until the samples fit, leaving every 16th page
+	var pages []int
+	for _, sample := range sampler.samples {
+		pages = append(pages, sample.PageNumber)
+	}
+	want := []int{1, 17, 33, 49, 65, 81, 97}
+	if len(pages) != len(want) {
+		t.Fatalf("sampled pages %v, want %v", pages, want)
+	}
+	for i := range want {
+		if pages[i] != want[i] {
+			t.Fatalf("sampled pages %v, want %v", pages, want)
+		}
+	}
+}
+
+const (
+	englishProse = "The parties agree that the supplier shall deliver the goods to the buyer within thirty days of the order, and that payment is due on delivery."
+	frenchProse  = "Les parties conviennent que le fournisseur livre les marchandises dans un délai de trente jours et que le paiement est dû à la livraison."
+	germanProse  = "Die Parteien vereinbaren, dass der Lieferant die Ware innerhalb von dreißig Tagen liefert und dass die Zahlung bei Lieferung fällig ist."
+)
+
+func TestDetectLanguages(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		primary string
+		script  string
+	}{
+		{"english", englishProse, "en", "Latin"},
+		{"french", frenchProse, "fr", "Latin"},
+		{"german", germanProse, "de", "Latin"},
+		{"russian", "Стороны договорились о поставке товара в течение тридцати дней.", "ru", "Cyrillic"},
+		{"ukrainian", "Сторони домовилися про постачання товару протягом тридцяти днів і оплату.", "uk", "Cyrillic"},
+		{"greek", "Τα μέρη συμφωνούν ότι ο προμηθευτής παραδίδει τα εμπορεύματα.", "el", "Greek"},
+		{"persian", "طرفین توافق کردند که پرداخت پس از تحویل کالا انجام شود و چک صادر گردد.", "fa", "Arabic"},
+		{"japanese", "当事者は、商品を三十日以内に引き渡すことに合意します。", "ja", "Han"},
+		{"chinese", "双方同意供应商在三十天内交付货物，货到付款。", "zh", "Han"},
+		{"part numbers", "XK-200 ZT-310 QRW-99 PLM-4471 VBN-12 HJK-8830", languageUndetermined, "Latin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := detectLanguages([]pageSample{{PageNumber: 1, Text: tt.text}})
+			if report.PrimaryLanguage != tt.primary {
+				t.Errorf("PrimaryLanguage = %q, want %q (%+v)", report.PrimaryLanguage, tt.primary, report.Languages)
+			}
+			if len(report.Scripts) == 0 || report.Scripts[0].Script != tt.script {
+				t.Errorf("Scripts = %+v, want %s first", report.Scripts, tt.script)
+			}
+		})
+	}
+}
+
+func TestDetectLanguagesWeighsPagesByLetters(t *testing.T) {
+	// A long English page outweighs a short French one, a page with too few
+	// letters is sampled but does not count
+	report := detectLanguages([]pageSample{
+		{PageNumber: 1, Text: englishProse + " " + englishProse},
+		{PageNumber: 5, Text: frenchProse},
+		{PageNumber: 9, Text: "p. 9"},
+	})
+
+	if report.PrimaryLanguage != "en" || len(report.Languages) < 2 || report.Languages[1].Language != "fr" {
+		t.Fatalf("languages %+v, want en then fr", report.Languages)
+	}
+	if report.Confidence <= 0.5 || report.Confidence >= 1 {
+		t.Errorf("Confidence = %v, want a majority short of certainty", report.Confidence)
+	}
+	if len(report.SampledPages) != 3 || report.SampledPages[2] != 9 {
+		t.Errorf("SampledPages = %v", report.SampledPages)
+	}
+	if _, letters := countScripts(englishProse); report.SampledLetters <= 2*letters {
+		t.Errorf("SampledLetters = %d, the French page is missing", report.SampledLetters)
+	}
+}
+
+func TestDetectLanguagesWithoutText(t *testing.T) {
+	report := detectLanguages([]pageSample{{PageNumber: 1, Text: "12 / 2024"}})
+	if report.PrimaryLanguage != "" || report.SampledLetters != 0 || len(report.Languages) != 0 {
+		t.Errorf("scanned document detected as %+v", report)
+	}
+	if len(report.SampledPages) != 1 {
+		t.Errorf("SampledPages = %v, want the page that was looked at", report.SampledPages)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Hard limits, anything past them is refused instead of risking the worker
+const (
+	defaultMaxFileSize   = 512 * 1024 * 1024
+	defaultMaxPages      = 10000
+	defaultMaxObjects    = 2000000
+	defaultMaxStreamSize = 128 * 1024 * 1024
+	defaultMaxMemory     = 1024 * 1024 * 1024
+)
+
+// Names of the limits, reported in LimitError
+const (
+	LimitFileSize   = "file_size"
+	LimitPages      = "pages"
+	LimitObjects    = "objects"
+	LimitStreamSize = "stream_size"
+	LimitMemory     = "memory"
+)
+
+// Forms measured per page, nested forms shared between many parents would
+// otherwise be decoded once per path through the tree
+const maxGuardedForms = 256
+
+// Bytes read around the trailer and cross-reference stream for the declared object count
+const trailerScanBytes = 4096
+
+// Most bytes deflate can expand one compressed byte to, 258 bytes from a 2 bit code
+const maxFlateRatio = 1032
+
+// Any resource limit, match with errors.Is
+var ErrLimitExceeded = errors.New("resource limit exceeded")
+
+// A document over one of the resource limits
+type LimitError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s reached %d, limit is %d", ErrLimitExceeded, e.Limit, e.Value, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Limits enforced while a document is parsed, zero disables a limit
+type ResourceLimits struct {
+	MaxFileSize   int64 // bytes of the stored document
+	MaxPages      int
+	MaxObjects    int64 // objects the cross-reference table declares
+	MaxStreamSize int64 // decoded bytes of any one stream the analyzer parses
+	MaxMemory     int64 // decoded bytes held at once, a page's content while it is parsed and images while they are decoded
+}
+
+func DefaultResourceLimits() ResourceLimits {
+	return ResourceLimits{
+		MaxFileSize:   defaultMaxFileSize,
+		MaxPages:      defaultMaxPages,
+		MaxObjects:    defaultMaxObjects,
+		MaxStreamSize: defaultMaxStreamSize,
+		MaxMemory:     defaultMaxMemory,
+	}
+}
+
+// Limits from ANALYZER_MAX_FILE_SIZE, ANALYZER_MAX_PAGES, ANALYZER_MAX_OBJECTS,
+// ANALYZER_MAX_STREAM_SIZE and ANALYZER_MAX_MEMORY, sizes in bytes
+func ResourceLimitsFromEnv() ResourceLimits {
+	limits := DefaultResourceLimits()
+	limits.MaxFileSize = envInt64("ANALYZER_MAX_FILE_SIZE", limits.MaxFileSize)
+	limits.MaxPages = envInt("ANALYZER_MAX_PAGES", limits.MaxPages)
+	limits.MaxObjects = envInt64("ANALYZER_MAX_OBJECTS", limits.MaxObjects)
+	limits.MaxStreamSize = envInt64("ANALYZER_MAX_STREAM_SIZE", limits.MaxStreamSize)
+	limits.MaxMemory = envInt64("ANALYZER_MAX_MEMORY", limits.MaxMemory)
+	return limits
+}
+
+func envInt64(key string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Enforces the limits for one analysis, safe to call on a nil guard
+type resourceGuard struct {
+	limits ResourceLimits
+	held   atomic.Int64 // a timed out page keeps its charge until its goroutine ends
+}
+
+func newResourceGuard(limits ResourceLimits) *resourceGuard {
+	return &resourceGuard{limits: limits}
+}
+
+func (rg *resourceGuard) checkFileSize(size int64) error {
+	if rg == nil || rg.limits.MaxFileSize <= 0 || size <= rg.limits.MaxFileSize {
+		return nil
+	}
+	return &LimitError{Limit: LimitFileSize, Value: size, Max: rg.limits.MaxFileSize}
+}
+
+func (rg *resourceGuard) checkPages(count int) error {
+	if rg == nil || rg.limits.MaxPages <= 0 || count <= rg.limits.MaxPages {
+		return nil
+	}
+	return &LimitError{Limit: LimitPages, Value: int64(count), Max: int64(rg.limits.MaxPages)}
+}
+
+var (
+	startXrefPattern = regexp.MustCompile(`startxref\s+(\d+)`)
+	trailerSizeKey   = regexp.MustCompile(`/Size\s+(\d+)`)
+)
+
+// The pdf package sizes its cross-reference table from the trailer's /Size
+// before anything else runs, so the declared count is read from the raw bytes
+// ahead of opening the reader
+func (rg *resourceGuard) checkObjects(file io.ReaderAt, size int64) error {
+	if rg == nil || rg.limits.MaxObjects <= 0 {
+		return nil
+	}
+
+	declared := int64(0)
+	scan := func(offset int64) []byte {
+		if offset < 0 {
+			offset = 0
+		}
+		buf := make([]byte, minInt64(trailerScanBytes, size-offset))
+		n, _ := file.ReadAt(buf, offset)
+		buf = buf[:n]
+		for _, match := range trailerSizeKey.FindAllSubmatch(buf, -1) {
+			if count, err := strconv.ParseInt(string(match[1]), 10, 64); err == nil && count > declared {
+				declared = count
+			}
+		}
+		return buf
+	}
+
+	// A classic trailer sits at the end, a cross-reference stream where startxref points
+	tail := scan(size - trailerScanBytes)
+	if matches := startXrefPattern.FindAllSubmatch(tail, -1); len(matches) > 0 {
+		if offset, err := strconv.ParseInt(string(matches[len(matches)-1][1]), 10, 64); err == nil && offset < size {
+			scan(offset)
+		}
+	}
+
+	if declared > rg.limits.MaxObjects {
+		return &LimitError{Limit: LimitObjects, Value: declared, Max: rg.limits.MaxObjects}
+	}
+	return nil
+}
+
+// Charge the content streams about to be parsed for a page to the memory
+// budget and check the forms it draws and the ToUnicode maps of its fonts
+// against the stream limit. Returns the bytes charged, released once the page
+// is parsed. Forms and font maps are interpreted as they stream, only the page
+// content is held, it is what text, rows and tables are built from.
+func (rg *resourceGuard) checkPage(page pdf.Page) (int64, error) {
+	if rg == nil {
+		return 0, nil
+	}
+
+	contents := page.V.Key("Contents")
+	streams := []pdf.Value{contents}
+	if contents.Kind() == pdf.Array {
+		streams = streams[:0]
+		for i := 0; i < contents.Len(); i++ {
+			streams = append(streams, contents.Index(i))
+		}
+	}
+	charged := int64(0)
+	for _, stream := range streams {
+		size, err := rg.chargeStream(stream)
+		if err != nil {
+			rg.release(charged)
+			return 0, err
+		}
+		charged += size
+	}
+	forms := 0
+	if err := rg.checkResources(page.Resources(), 0, &forms); err != nil {
+		rg.release(charged)
+		return 0, err
+	}
+	return charged, nil
+}
+
+// Fonts and forms of a resource dictionary, recursing into forms up to the
+// same depth the page walks use and checking at most maxGuardedForms forms
+func (rg *resourceGuard) checkResources(resources pdf.Value, depth int, forms *int) error {
+	fonts := resources.Key("Font")
+	for _, name := range fonts.Keys() {
+		if err := rg.checkStream(fonts.Key(name).Key("ToUnicode")); err != nil {
+			return err
+		}
+	}
+
+	xobjects := resources.Key("XObject")
+	for _, name := range xobjects.Keys() {
+		xobject := xobjects.Key(name)
+		if xobject.Key("Subtype").Name() != "Form" || *forms >= maxGuardedForms {
+			continue
+		}
+		*forms++
+		if err := rg.checkStream(xobject); err != nil {
+			return err
+		}
+		if depth < maxFormDepth {
+			if err := rg.checkResources(xobject.Key("Resources"), depth+1, forms); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check a stream against the stream limit. Inflating a stream only to measure
+// it doubles the work, so streams whose compressed length bounds them under
+// the limit are not decoded here.
+func (rg *resourceGuard) checkStream(stream pdf.Value) error {
+	if rg.limits.MaxStreamSize <= 0 {
+		return nil
+	}
+	if bound, ok := decodedBound(stream); ok && bound <= rg.limits.MaxStreamSize {
+		return nil
+	}
+	_, err := rg.measureStream(stream)
+	return err
+}
+
+// Charge a stream to the memory budget. The bound its compressed length allows
+// is charged without decoding while it fits the limits, a stream that could go
+// past them is decoded and measured. Returns the bytes charged.
+func (rg *resourceGuard) chargeStream(stream pdf.Value) (int64, error) {
+	if bound, ok := decodedBound(stream); ok && (rg.limits.MaxStreamSize <= 0 || bound <= rg.limits.MaxStreamSize) {
+		if rg.charge(bound) == nil {
+			return bound, nil
+		}
+	}
+
+	size, err := rg.measureStream(stream)
+	if err != nil {
+		return 0, err
+	}
+	if err := rg.charge(size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Most bytes a stream can decode to given its compressed length, false when a
+// filter has no known bound or the length is missing
+func decodedBound(stream pdf.Value) (int64, bool) {
+	if stream.Kind() != pdf.Stream {
+		return 0, true
+	}
+	bound := stream.Key("Length").Int64()
+	if bound <= 0 {
+		return 0, false
+	}
+
+	filter := stream.Key("Filter")
+	filters := []pdf.Value{filter}
+	if filter.Kind() == pdf.Array {
+		filters = filters[:0]
+		for i := 0; i < filter.Len(); i++ {
+			filters = append(filters, filter.Index(i))
+		}
+	}
+	for _, f := range filters {
+		switch f.Name() {
+		case "", "ASCII85Decode":
+			// Unfiltered, or 5 characters to 4 bytes
+		case "FlateDecode":
+			if bound > math.MaxInt64/maxFlateRatio {
+				return 0, false
+			}
+			bound *= maxFlateRatio
+		default:
+			return 0, false
+		}
+	}
+	return bound, true
+}
+
+// Decoded size of a stream, read and discarded up to one byte past the limit.
+// Streams the pdf package cannot decode count as empty, parsing them fails later.
+func (rg *resourceGuard) measureStream(stream pdf.Value) (size int64, err error) {
+	if stream.Kind() != pdf.Stream {
+		return 0, nil
+	}
+	defer func() {
+		if recover() != nil {
+			size, err = 0, nil
+		}
+	}()
+
+	rc := stream.Reader()
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if rg.limits.MaxStreamSize > 0 {
+		r = io.LimitReader(rc, rg.limits.MaxStreamSize+1)
+	}
+	size, _ = io.Copy(io.Discard, r)
+	if rg.limits.MaxStreamSize > 0 && size > rg.limits.MaxStreamSize {
+		return size, &LimitError{Limit: LimitStreamSize, Value: size, Max: rg.limits.MaxStreamSize}
+	}
+	return size, nil
+}
+
+// Hold decoded bytes against the memory budget until released. Nothing is
+// held when the budget would be overspent.
+func (rg *resourceGuard) charge(size int64) error {
+	if rg == nil {
+		return nil
+	}
+	held := rg.held.Add(size)
+	if rg.limits.MaxMemory > 0 && held > rg.limits.MaxMemory {
+		rg.held.Add(-size)
+		return &LimitError{Limit: LimitMemory, Value: held, Max: rg.limits.MaxMemory}
+	}
+	return nil
+}
+
+func (rg *resourceGuard) release(size int64) {
+	if rg != nil {
+		rg.held.Add(-size)
+	}
+}
+
+// Wrap a decompressing reader, such as a zip entry, so it fails with a
+// LimitError instead of inflating past the stream limit. Readers are parsed as
+// they stream rather than held, the memory budget does not apply.
+func (rg *resourceGuard) reader(r io.Reader) io.Reader {
+	if rg == nil {
+		return r
+	}
+	return &guardedReader{r: r, guard: rg}
+}
+
+type guardedReader struct {
+	r     io.Reader
+	guard *resourceGuard
+	read  int64
+}
+
+func (gr *guardedReader) Read(p []byte) (int, error) {
+	n, err := gr.r.Read(p)
+	gr.read += int64(n)
+	if max := gr.guard.limits.MaxStreamSize; max > 0 && gr.read > max {
+		return n, &LimitError{Limit: LimitStreamSize, Value: gr.read, Max: max}
+	}
+	return n, err
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
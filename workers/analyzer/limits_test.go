@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func TestResourceGuardChecks(t *testing.T) {
+	guard := newResourceGuard(ResourceLimits{MaxFileSize: 1000, MaxPages: 10})
+	unlimited := newResourceGuard(ResourceLimits{})
+	var nilGuard *resourceGuard
+
+	tests := []struct {
+		name  string
+		err   error
+		limit string
+	}{
+		{"file size at the limit", guard.checkFileSize(1000), ""},
+		{"file size over the limit", guard.checkFileSize(1001), LimitFileSize},
+		{"pages at the limit", guard.checkPages(10), ""},
+		{"pages over the limit", guard.checkPages(11), LimitPages},
+		{"unlimited file size", unlimited.checkFileSize(1 << 40), ""},
+		{"unlimited pages", unlimited.checkPages(1 << 20), ""},
+		{"nil guard", nilGuard.checkFileSize(1 << 40), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkLimitError(t, tt.err, tt.limit)
+		})
+	}
+}
+
+func TestResourceGuardCharge(t *testing.T) {
+	guard := newResourceGuard(ResourceLimits{MaxMemory: 100})
+
+	steps := []struct {
+		name    string
+		charge  int64
+		release int64
+		limit   string
+		held    int64
+	}{
+		{"within the budget", 60, 0, "", 60},
+		{"fills the budget", 40, 0, "", 100},
+		{"over the budget is not held", 1, 0, LimitMemory, 100},
+		{"release frees room", 0, 60, "", 40},
+		{"charge after a release", 50, 0, "", 90},
+	}
+
+	for _, step := range steps {
+		err := guard.charge(step.charge)
+		guard.release(step.release)
+		if got := guard.held.Load(); got != step.held {
+			t.Errorf("%s: held %d, want %d", step.name, got, step.held)
+		}
+		checkLimitError(t, err, step.limit)
+	}
+
+	var nilGuard *resourceGuard
+	if err := nilGuard.charge(1 << 40); err != nil {
+		t.Errorf("nil guard charge: %v", err)
+	}
+	nilGuard.release(1 << 40)
+}
+
+func TestResourceGuardReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		max   int64
+		size  int
+		limit string
+	}{
+		{"under the limit", 10, 10, ""},
+		{"over the limit", 10, 11, LimitStreamSize},
+		{"unlimited", 0, 1 << 16, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := newResourceGuard(ResourceLimits{MaxStreamSize: tt.max})
+			_, err := io.Copy(io.Discard, guard.reader(strings.NewReader(strings.Repeat("x", tt.size))))
+			checkLimitError(t, err, tt.limit)
+		})
+	}
+}
+
+func TestCheckObjects(t *testing.T) {
+	data := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+	)
+
+	tests := []struct {
+		name  string
+		max   int64
+		limit string
+	}{
+		{"declared count at the limit", 3, ""},
+		{"declared count over the limit", 2, LimitObjects},
+		{"unlimited", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := newResourceGuard(ResourceLimits{MaxObjects: tt.max})
+			checkLimitError(t, guard.checkObjects(bytes.NewReader(data), int64(len(data))), tt.limit)
+		})
+	}
+}
+
+func TestDecodedBound(t *testing.T) {
+	resources := testPDFResources(t)
+
+	tests := []struct {
+		name  string
+		value pdf.Value
+		bound int64
+		ok    bool
+	}{
+		{"unfiltered", resources.Key("Plain"), 10, true},
+		{"flate", resources.Key("Flate"), 10 * maxFlateRatio, true},
+		{"filter chain", resources.Key("Chain"), 10 * maxFlateRatio, true},
+		{"filter without a bound", resources.Key("DCT"), 0, false},
+		{"missing length", resources.Key("Empty"), 0, false},
+		{"not a stream", resources, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound, ok := decodedBound(tt.value)
+			if bound != tt.bound || ok != tt.ok {
+				t.Errorf("decodedBound() = %d, %v, want %d, %v", bound, ok, tt.bound, tt.ok)
+			}
+		})
+	}
+}
+
+func TestChargeStream(t *testing.T) {
+	plain := testPDFResources(t).Key("Plain")
+
+	tests := []struct {
+		name    string
+		limits  ResourceLimits
+		charged int64
+		limit   string
+	}{
+		{"bound fits", ResourceLimits{MaxStreamSize: 20, MaxMemory: 20}, 10, ""},
+		{"stream over the stream limit", ResourceLimits{MaxStreamSize: 5}, 0, LimitStreamSize},
+		{"stream over the memory budget", ResourceLimits{MaxMemory: 5}, 0, LimitMemory},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := newResourceGuard(tt.limits)
+			charged, err := guard.chargeStream(plain)
+			checkLimitError(t, err, tt.limit)
+			if charged != tt.charged || guard.held.Load() != tt.charged {
+				t.Errorf("charged %d, holding %d, want %d", charged, guard.held.Load(), tt.charged)
+			}
+		})
+	}
+}
+
+func TestAnalyzeOverLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		limits  ResourceLimits
+		limit   string
+	}{
+		{"file larger than allowed", "sample.pdf", ResourceLimits{MaxFileSize: 1000}, LimitFileSize},
+		{"more pages than allowed", "images.pdf", ResourceLimits{MaxPages: 1}, LimitPages},
+		{"more objects declared than allowed", "sample.pdf", ResourceLimits{MaxObjects: 2}, LimitObjects},
+		{"page content over the stream limit", "sample.pdf", ResourceLimits{MaxStreamSize: 10}, LimitStreamSize},
+		{"within every limit", "images.pdf", DefaultResourceLimits(), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			pa := newExtractingAnalyzer(t, root, WithResourceLimits(tt.limits))
+			input := DocumentInput{ID: "doc", FilePath: tt.fixture, ExtractText: true}
+			result, err := pa.AnalyzeDocument(context.Background(), input)
+			checkLimitError(t, err, tt.limit)
+			if tt.limit == "" {
+				return
+			}
+
+			// A refused document is not retried and leaves no artifact behind
+			if result != nil || !IsPermanentError(err) || errorKind(err) != "limit-exceeded" {
+				t.Errorf("result %+v, error %v of kind %s", result, err, errorKind(err))
+			}
+			filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					t.Errorf("artifact %s left for a refused document", path)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+// Fails the test unless err is a LimitError for limit, or nil when limit is empty
+func checkLimitError(t *testing.T, err error, limit string) {
+	t.Helper()
+
+	if limit == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Errorf("error = %v, want a %s limit error", err, limit)
+		return
+	}
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("errors.Is(%v, ErrLimitExceeded) = false", err)
+	}
+}
+
+// Resources of a one page document holding ten byte streams under different filters
+func testPDFResources(t *testing.T) pdf.Value {
+	t.Helper()
+
+	stream := func(dict string, data string) string {
+		return fmt.Sprintf("<< %s >>\nstream\n%s\nendstream", dict, data)
+	}
+	data := buildTestPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100 100] /Resources "+
+			"<< /Plain 4 0 R /Flate 5 0 R /Chain 6 0 R /DCT 7 0 R /Empty 8 0 R >> >>",
+		stream("/Length 10", "0123456789"),
+		stream("/Length 10 /Filter /FlateDecode", "0123456789"),
+		stream("/Length 10 /Filter [/ASCII85Decode /FlateDecode]", "0123456789"),
+		stream("/Length 10 /Filter /DCTDecode", "0123456789"),
+		stream("/Length 0", ""),
+	)
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader.Page(1).V.Key("Resources")
+}
+
+// PDF with the given objects numbered from 1, the first one is the catalog
+func buildTestPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -15,6 +16,15 @@ const HATCHET_HOST = "104ad.cloud.onhatchet.run"
 const HATCHET_PORT = 443
 
 func main() {
+	// With a command the analyzer runs locally instead of as a Hatchet worker
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	runWorker()
+}
+
+// Register the workflows and process documents until interrupted
+func runWorker() {
 	// init structured logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
@@ -37,29 +47,59 @@ func main() {
 		logger.WithError(err).Fatal("Failed to create Hatchet client")
 	}
 
-	// Initialize PDF Analayzer (will be created in step function)
-	// pdfAnalyzer := NewPDFAnalzyer(logger)
+	// Initialize PDF Analyzer, shared by every step run
+	pdfAnalyzer, closeAnalyzer := newAnalyzerFromEnv(logger)
+	defer closeAnalyzer()
 
 	// Create worker instance, process up to 10 jobs concurrently
 	w, err := worker.NewWorker(
 		worker.WithClient(c),
-		worker.WithMaxRuns(10),
+		worker.WithMaxRuns(maxConcurrentRuns),
 	)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to create worker")
 	}
 
-	// Register the analyzer workflow, trigger the worker on upload, retry up to 3x on failure
+	// Event pushed after analysis to start the processing pipeline
+	processEvents := ProcessEventConfigFromEnv()
+	logger.WithFields(logrus.Fields{
+		"enabled":      processEvents.Enabled,
+		"event":        processEvents.Event,
+		"per_strategy": processEvents.PerStrategy,
+	}).Info("Loaded process event config")
+
+	// Register the analyzer workflow, trigger the worker on upload, retry up to 3x on failure.
+	// Parallel documents fan out into one chunk workflow per page range, then join, and
+	// analyzed documents are handed to the processing pipeline by a follow-up event.
 	err = w.RegisterWorkflow(
 		&worker.WorkflowJob{
 			Name:        "analyze-document",
-			Description: "Analyzes PDF documents to determine processing strategy",
+			Description: "Analyzes uploaded documents to determine processing strategy",
 			On: worker.Events("document:uploaded"),
 			Steps: []*worker.WorkflowStep{
 				{
 					Name: "analyze",
-					Function: analyzeDocumentStep,
+					Function: analyzeDocumentStep(pdfAnalyzer),
 					Retries: 3,
+					Timeout: pdfAnalyzer.timeouts.StepTimeout(),
+				},
+				{
+					Name:     "fan-out",
+					Function: fanOutStep(pdfAnalyzer),
+					Parents:  []string{"analyze"},
+					Retries:  3, // children are spawned under stable keys, a retry waits on the same runs
+					Timeout:  "30m",
+				},
+				{
+					Name:     "join",
+					Function: joinStep(pdfAnalyzer),
+					Parents:  []string{"fan-out"},
+				},
+				{
+					Name:     "emit",
+					Function: emitStep(pdfAnalyzer, c.Event(), processEvents),
+					Parents:  []string{"join"},
+					Retries:  3,
 				},
 			},
 		},
@@ -68,7 +108,40 @@ func main() {
 		logger.WithError(err).Fatal("Failed to register workflow")
 	}
 
-	logger.Info("Workflow registered successfully")
+	// Chunks run on a worker of their own. A fan-out step holds its slot while
+	// it waits, on a shared worker enough parallel documents would fill every
+	// slot with waiting parents and leave none for their chunks.
+	chunkWorker, err := worker.NewWorker(
+		worker.WithClient(c),
+		worker.WithName("analyzer-chunks"),
+		worker.WithMaxRuns(pdfAnalyzer.chunks.MaxConcurrency),
+	)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create chunk worker")
+	}
+
+	// Register the child workflow that extracts a single chunk. It has no trigger,
+	// chunk runs are only spawned by the fan-out step and never by a pushed event.
+	err = chunkWorker.RegisterWorkflow(
+		&worker.WorkflowJob{
+			Name:        chunkWorkflowName,
+			Description: "Extracts one page range of a parallel document",
+			On:          worker.NoTrigger(),
+			Steps: []*worker.WorkflowStep{
+				{
+					Name:     "analyze-chunk",
+					Function: analyzeChunkStep(pdfAnalyzer),
+					Retries:  3,
+					Timeout:  pdfAnalyzer.timeouts.StepTimeout(),
+				},
+			},
+		},
+	)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to register chunk workflow")
+	}
+
+	logger.Info("Workflows registered successfully")
 
 	// Start the worker
 	logger.Info("Starting worker...")
@@ -76,55 +149,167 @@ func main() {
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to start worker")
 	}
+	cleanupChunks, err := chunkWorker.Start()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to start chunk worker")
+	}
 
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	// Serve synchronous analysis from the same process when an address is configured
+	var api *APIServer
+	if os.Getenv("ANALYZER_API_ADDR") != "" {
+		api = NewAPIServer(pdfAnalyzer, APIConfigFromEnv())
+		go func() {
+			if err := api.ListenAndServe(); err != nil {
+				logger.WithError(err).Error("HTTP API stopped")
+			}
+		}()
+	}
+
 	logger.Info("Analyzer worker is running. Press Ctrl+C to exit.")
 	<-quit
 
+	if api != nil {
+		logger.Info("Shutting down HTTP API...")
+		ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+		if err := api.Shutdown(ctx); err != nil {
+			logger.WithError(err).Error("Error during HTTP API shutdown")
+		}
+		cancel()
+	}
+
 	logger.Info("Shutting down worker...")
 
 	// Cleanup using the fn returned by Start()
 	if err := cleanup(); err != nil {
 		logger.WithError(err).Error("Error during worker shutdown")
 	}
+	if err := cleanupChunks(); err != nil {
+		logger.WithError(err).Error("Error during chunk worker shutdown")
+	}
 
 	logger.Info("Analyzer worker stopped")
 }
 
-// main step fn for document analysis
-// main step fn for document analysis
-func analyzeDocumentStep(ctx worker.HatchetContext, input *DocumentInput) (*AnalysisResult, error) {
-	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.InfoLevel)
+// Analyzer configured from the environment, shared by the worker and the HTTP
+// API; the returned func closes the duplicate index and logs cache stats
+func newAnalyzerFromEnv(logger *logrus.Logger) (*PDFAnalyzer, func()) {
+	// Load pricing rates used for cost estimates
+	pricing, err := LoadPricingFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load pricing config")
+	}
+	logger.WithField("pricing_version", pricing.Version).Info("Loaded pricing config")
 
-	pdfAnalyzer := NewPDFAnalzyer(logger)
+	// Load routing policy used to pick a processing strategy
+	routing, err := LoadRoutingFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load routing policy")
+	}
+	logger.WithField("routing_version", routing.Version).Info("Loaded routing policy")
 
-	// call logger with fields to start processing
-	logger.WithFields(logrus.Fields{
-		"workflow_run_id": ctx.WorkflowRunId(),
-		"step_run_id":     ctx.StepRunId(),
-		"document_id":     input.ID,
-	}).Info("Document analysis: PROCESSING")
+	// Load the index of analyzed documents used to flag re-uploads
+	duplicates, err := DuplicateIndexFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load duplicate index")
+	}
 
-	// Perform document analysis
-	result, err := pdfAnalyzer.AnalyzeDocument(*input)
+	// Cache results so retries and re-uploads skip the analysis
+	cache, err := ResultCacheFromEnv()
 	if err != nil {
-		logger.WithError(err).Error("Document analysis failed")
-		return nil, fmt.Errorf("document analysis failed: %w", err)
+		logger.WithError(err).Fatal("Failed to create result cache")
 	}
 
-	// call logger with fields again to finish processing
+	// Deadlines keep a pathological document from holding a worker slot
+	timeouts := TimeoutConfigFromEnv()
+	logger.WithFields(logrus.Fields{
+		"document_timeout": timeouts.Document.String(),
+		"page_timeout":     timeouts.Page.String(),
+	}).Info("Loaded analysis timeouts")
+
+	// Hard limits so a decompression bomb is refused rather than OOM-killing the worker
+	limits := ResourceLimitsFromEnv()
 	logger.WithFields(logrus.Fields{
-		"workflow_run_id": ctx.WorkflowRunId(),
-		"document_id":     result.DocumentID,
-		"process_type":    result.ProcessType,
-		"page_count":      result.PageCount,
-	}).Info("Document analysis: COMPLETED SUCCESSFULLY")
-
-	// return result
-	return result, nil
+		"max_file_size":   limits.MaxFileSize,
+		"max_pages":       limits.MaxPages,
+		"max_objects":     limits.MaxObjects,
+		"max_stream_size": limits.MaxStreamSize,
+		"max_memory":      limits.MaxMemory,
+	}).Info("Loaded resource limits")
+
+	// Artifacts written among the uploads could overwrite them
+	storage := NewStorageFromEnv()
+	extraction := ExtractionConfigFromEnv()
+	if err := storage.CheckArtifactPrefix(extraction.ArtifactPrefix); err != nil {
+		logger.WithError(err).Fatal("Invalid artifact location")
+	}
+
+	pdfAnalyzer := NewPDFAnalzyer(
+		logger,
+		WithStorage(storage),
+		WithPricing(pricing),
+		WithRouting(routing),
+		WithChunking(ChunkPlannerConfigFromEnv()),
+		WithTextExtraction(extraction),
+		WithDuplicateIndex(duplicates),
+		WithResultCache(cache),
+		WithTimeouts(timeouts),
+		WithResourceLimits(limits),
+	)
+
+	return pdfAnalyzer, func() {
+		duplicates.Close()
+		if cache != nil {
+			stats := cache.Stats()
+			logger.WithFields(logrus.Fields{
+				"cache_hits":      stats.Hits,
+				"cache_disk_hits": stats.DiskHits,
+				"cache_misses":    stats.Misses,
+				"cache_evictions": stats.Evictions,
+				"hit_ratio":       stats.HitRatio(),
+			}).Info("Result cache stats")
+		}
+	}
+}
+
+// main step fn for document analysis
+func analyzeDocumentStep(pdfAnalyzer *PDFAnalyzer) func(ctx worker.HatchetContext, input *DocumentInput) (*AnalysisResult, error) {
+	return func(ctx worker.HatchetContext, input *DocumentInput) (*AnalysisResult, error) {
+		logger := pdfAnalyzer.logger
+
+		// call logger with fields to start processing
+		logger.WithFields(logrus.Fields{
+			"workflow_run_id": ctx.WorkflowRunId(),
+			"step_run_id":     ctx.StepRunId(),
+			"document_id":     input.ID,
+			"file_path":       input.FilePath,
+		}).Info("Document analysis: PROCESSING")
+
+		// Perform document analysis
+		result, err := pdfAnalyzer.AnalyzeDocument(ctx, *input)
+		if err != nil {
+			// Broken or unreadable documents fail the same way on every retry
+			if IsPermanentError(err) {
+				logger.WithError(err).WithField("error_kind", errorKind(err)).Warn("Document analysis failed permanently, not retrying")
+				return rejectedResult(*input, err), nil
+			}
+
+			logger.WithError(err).Error("Document analysis failed")
+			return nil, fmt.Errorf("document analysis failed: %w", err)
+		}
+
+		// call logger with fields again to finish processing
+		logger.WithFields(logrus.Fields{
+			"workflow_run_id": ctx.WorkflowRunId(),
+			"document_id":     result.DocumentID,
+			"process_type":    result.ProcessType,
+			"page_count":      result.PageCount,
+		}).Info("Document analysis: COMPLETED SUCCESSFULLY")
+
+		// return result
+		return result, nil
+	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Bytes read from the start of the file to find the header and linearization dict
+const metadataHeaderBytes = 1024
+
+// Outline walks stop here so cyclic or huge outlines cannot stall analysis
+const maxOutlineItems = 10000
+
+var pdfVersionPattern = regexp.MustCompile(`^%PDF-(\d+\.\d+)`)
+
+// Document metadata from the trailer, info dictionary and file header
+type DocumentMetadata struct {
+	Title            string     `json:"title,omitempty"`
+	Author           string     `json:"author,omitempty"`
+	Subject          string     `json:"subject,omitempty"`
+	Keywords         string     `json:"keywords,omitempty"`
+	Creator          string     `json:"creator,omitempty"`
+	Producer         string     `json:"producer,omitempty"`
+	CreationDate     *time.Time `json:"creation_date,omitempty"`
+	ModificationDate *time.Time `json:"modification_date,omitempty"`
+	PDFVersion       string     `json:"pdf_version,omitempty"`
+	Linearized       bool       `json:"linearized"`
+	HasOutline       bool       `json:"has_outline"`
+	OutlineItems     int        `json:"outline_items"`
+}
+
+func extractMetadata(file io.ReaderAt, reader *pdf.Reader) DocumentMetadata {
+	info := reader.Trailer().Key("Info")
+	catalog := reader.Trailer().Key("Root")
+
+	metadata := DocumentMetadata{
+		Title:            info.Key("Title").Text(),
+		Author:           info.Key("Author").Text(),
+		Subject:          info.Key("Subject").Text(),
+		Keywords:         info.Key("Keywords").Text(),
+		Creator:          info.Key("Creator").Text(),
+		Producer:         info.Key("Producer").Text(),
+		CreationDate:     parsePDFDate(info.Key("CreationDate").Text()),
+		ModificationDate: parsePDFDate(info.Key("ModDate").Text()),
+	}
+
+	// Header version, unless the catalog declares a newer one
+	header := make([]byte, metadataHeaderBytes)
+	n, _ := file.ReadAt(header, 0)
+	header = header[:n]
+
+	if match := pdfVersionPattern.FindSubmatch(header); match != nil {
+		metadata.PDFVersion = string(match[1])
+	}
+	if catalogVersion := catalog.Key("Version").Name(); catalogVersion > metadata.PDFVersion {
+		metadata.PDFVersion = catalogVersion
+	}
+
+	// Linearized files start with a dict carrying the /Linearized key
+	metadata.Linearized = bytes.Contains(header, []byte("/Linearized"))
+
+	metadata.OutlineItems = countOutlineItems(catalog.Key("Outlines"))
+	metadata.HasOutline = metadata.OutlineItems > 0
+
+	return metadata
+}
+
+// Count outline entries breadth first, bounded by maxOutlineItems
+func countOutlineItems(outlines pdf.Value) int {
+	count := 0
+	queue := []pdf.Value{outlines.Key("First")}
+
+	for len(queue) > 0 && count < maxOutlineItems {
+		item := queue[0]
+		queue = queue[1:]
+
+		for ; item.Kind() == pdf.Dict && count < maxOutlineItems; item = item.Key("Next") {
+			count++
+			if first := item.Key("First"); first.Kind() == pdf.Dict {
+				queue = append(queue, first)
+			}
+		}
+	}
+
+	return count
+}
+
+// Parse a PDF date string (D:YYYYMMDDHHmmSSOHH'mm'), any trailing part may be omitted
+func parsePDFDate(raw string) *time.Time {
+	if len(raw) >= 2 && raw[:2] == "D:" {
+		raw = raw[2:]
+	}
+	if len(raw) < 4 {
+		return nil
+	}
+
+	// year, month, day, hour, minute, second with their defaults
+	fields := []int{0, 1, 1, 0, 0, 0}
+	widths := []int{4, 2, 2, 2, 2, 2}
+	pos := 0
+	for i, width := range widths {
+		if pos+width > len(raw) || !isDigits(raw[pos:pos+width]) {
+			break
+		}
+		fields[i], _ = strconv.Atoi(raw[pos : pos+width])
+		pos += width
+	}
+
+	location := time.UTC
+	if pos < len(raw) && (raw[pos] == '+' || raw[pos] == '-') {
+		sign := 1
+		if raw[pos] == '-' {
+			sign = -1
+		}
+
+		offset := raw[pos+1:]
+		hours, minutes := 0, 0
+		if len(offset) >= 2 && isDigits(offset[:2]) {
+			hours, _ = strconv.Atoi(offset[:2])
+			offset = offset[2:]
+		}
+		if len(offset) >= 1 && offset[0] == '\'' {
+			offset = offset[1:]
+		}
+		if len(offset) >= 2 && isDigits(offset[:2]) {
+			minutes, _ = strconv.Atoi(offset[:2])
+		}
+
+		location = time.FixedZone("", sign*(hours*3600+minutes*60))
+	}
+
+	t := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, location)
+	return &t
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
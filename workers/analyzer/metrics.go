@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+)
+
+// Saturation points for each complexity component, past these a
+// component contributes its full weight
+const (
+	complexityPageCeiling         = 200.0  // pages
+	complexityImageDensityCeiling = 2.0    // images per page
+	complexityFontCeiling         = 20.0   // distinct fonts
+	complexityProblemFontPenalty  = 3.0    // fonts each unembedded or Type3 font counts as
+	complexityTextDensityCeiling  = 2000.0 // chars per page
+)
+
+// Weights of each component, they add up to 100
+const (
+	complexityPageWeight         = 30.0
+	complexityImageDensityWeight = 25.0
+	complexityFontWeight         = 20.0
+	complexityTextDensityWeight  = 25.0
+)
+
+// Turn raw content stats into the metrics block returned to callers
+func buildProcessingMetrics(stats *contentStats) ProcessingMetrics {
+	metrics := ProcessingMetrics{
+		IsTextBased:  stats.IsTextBased,
+		PagesSampled: stats.PagesSampled,
+		ImageCount:   stats.ImageCount,
+		FontCount:    stats.FontCount,
+	}
+	if stats.Fonts != nil {
+		metrics.ProblemFontCount = stats.Fonts.Unembedded + stats.Fonts.Type3
+	}
+
+	if stats.PagesSampled > 0 {
+		metrics.TextDensity = float64(stats.TextChars) / float64(stats.PagesSampled)
+	}
+	if stats.PageCount > 0 {
+		metrics.ImageDensity = float64(stats.ImageCount) / float64(stats.PageCount)
+	}
+
+	metrics.ComplexityScore = calculateComplexityScore(
+		stats.PageCount,
+		metrics.ImageDensity,
+		stats.FontCount,
+		metrics.ProblemFontCount,
+		metrics.TextDensity,
+	)
+
+	return metrics
+}
+
+// Score from 0 (trivial) to 100 (hardest) describing how much work a document
+// is likely to take. Long, image heavy, font heavy documents with little
+// extractable text score highest. Fonts that are not embedded or are Type3
+// need substitution or rasterizing, so they weigh more than other fonts.
+func calculateComplexityScore(pageCount int, imageDensity float64, fontCount, problemFonts int, textDensity float64) float64 {
+	pageScore := saturate(float64(pageCount), complexityPageCeiling) * complexityPageWeight
+	imageScore := saturate(imageDensity, complexityImageDensityCeiling) * complexityImageDensityWeight
+	effectiveFonts := float64(fontCount) + float64(problemFonts)*(complexityProblemFontPenalty-1)
+	fontScore := saturate(effectiveFonts, complexityFontCeiling) * complexityFontWeight
+
+	// Sparse text means OCR or layout work, so low density is the expensive case
+	textScore := (1 - saturate(textDensity, complexityTextDensityCeiling)) * complexityTextDensityWeight
+
+	score := pageScore + imageScore + fontScore + textScore
+	return math.Round(score*100) / 100
+}
+
+// Scale value into [0, 1] relative to ceiling
+func saturate(value, ceiling float64) float64 {
+	if value <= 0 || ceiling <= 0 {
+		return 0
+	}
+	return math.Min(value/ceiling, 1)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCalculateComplexityScore(t *testing.T) {
+	// Nothing to process still costs the text component, no text means OCR
+	if got := calculateComplexityScore(0, 0, 0, 0, 0); got != complexityTextDensityWeight {
+		t.Errorf("empty document scores %v, want %v", got, complexityTextDensityWeight)
+	}
+
+	// Past every ceiling each component gives its full weight and no more
+	if got := calculateComplexityScore(10000, 50, 500, 100, 0); got != 100 {
+		t.Errorf("saturated document scores %v, want 100", got)
+	}
+	if got := calculateComplexityScore(10000, 50, 500, 100, 1e6); got != 75 {
+		t.Errorf("saturated text document scores %v, want 75", got)
+	}
+
+	// One unembedded or Type3 font weighs as much as three ordinary ones
+	if problem, plain := calculateComplexityScore(1, 0, 1, 1, 500), calculateComplexityScore(1, 0, 3, 0, 500); problem != plain {
+		t.Errorf("one problem font scores %v, three plain fonts %v", problem, plain)
+	}
+
+	// More pages, images or fonts never lower the score, more text never raises it
+	base := calculateComplexityScore(20, 0.5, 4, 0, 800)
+	for name, score := range map[string]float64{
+		"pages":  calculateComplexityScore(40, 0.5, 4, 0, 800),
+		"images": calculateComplexityScore(20, 1, 4, 0, 800),
+		"fonts":  calculateComplexityScore(20, 0.5, 8, 0, 800),
+	} {
+		if score <= base {
+			t.Errorf("more %s scores %v, not above %v", name, score, base)
+		}
+	}
+	if score := calculateComplexityScore(20, 0.5, 4, 0, 1600); score >= base {
+		t.Errorf("denser text scores %v, not below %v", score, base)
+	}
+}
+
+func TestBuildProcessingMetrics(t *testing.T) {
+	stats := &contentStats{
+		PageCount:    40,
+		IsTextBased:  true,
+		PagesSampled: 10,
+		TextChars:    12000,
+		ImageCount:   20,
+		FontCount:    5,
+		Fonts:        &FontReport{Unembedded: 2, Type3: 1},
+	}
+
+	metrics := buildProcessingMetrics(stats)
+	if !metrics.IsTextBased || metrics.PagesSampled != 10 || metrics.ImageCount != 20 || metrics.FontCount != 5 {
+		t.Errorf("counts not carried over: %+v", metrics)
+	}
+	if metrics.ProblemFontCount != 3 {
+		t.Errorf("ProblemFontCount = %d, want 3", metrics.ProblemFontCount)
+	}
+	// Text density is per sampled page, image density per page of the document
+	if metrics.TextDensity != 1200 || metrics.ImageDensity != 0.5 {
+		t.Errorf("densities = %v chars and %v images per page, want 1200 and 0.5", metrics.TextDensity, metrics.ImageDensity)
+	}
+	if want := calculateComplexityScore(40, 0.5, 5, 3, 1200); metrics.ComplexityScore != want {
+		t.Errorf("ComplexityScore = %v, want %v", metrics.ComplexityScore, want)
+	}
+
+	// Nothing sampled must not divide by zero
+	empty := buildProcessingMetrics(&contentStats{})
+	if empty.TextDensity != 0 || empty.ImageDensity != 0 || empty.ProblemFontCount != 0 {
+		t.Errorf("empty stats give %+v", empty)
+	}
+}
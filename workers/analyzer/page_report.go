@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/sirupsen/logrus"
+)
+
+// Pages with fewer visible characters than this carry no usable text layer
+const scannedPageCharThreshold = 20
+
+// Page tree levels followed for inherited attributes, real trees are a few
+// levels deep and a longer Parent chain is a cycle
+const maxPageTreeDepth = 64
+
+// Page content classifications
+const (
+	PageContentText    = "text"
+	PageContentScanned = "scanned"
+	PageContentMixed   = "mixed"
+	PageContentBlank   = "blank"
+)
+
+// Per-page breakdown used to route pages to OCR or text extraction
+type PageReport struct {
+	PageNumber    int     `json:"page_number"`
+	CharCount     int     `json:"char_count"`
+	TextExtracted bool    `json:"text_extracted"`
+	ImageCount    int     `json:"image_count"`
+	IsScanned     bool    `json:"is_scanned"`
+	ContentType   string  `json:"content_type"`
+	Rotation      int     `json:"rotation"`
+	Width         float64 `json:"width"`
+	Height        float64 `json:"height"`
+	TableCount    int     `json:"table_count,omitempty"`
+
+	Images       []ImageInfo `json:"images,omitempty"`
+	FullPageScan bool        `json:"full_page_scan"`
+
+	Fonts []string `json:"fonts,omitempty"`
+
+	// Set when reading the page took longer than the page timeout, the rest is empty
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// Walk every page once, building page reports and document wide counts. Each
+// page is read under the page timeout; when the document deadline passes the
+// walk stops and the pages read so far are reported.
+func (pa *PDFAnalyzer) analyzePages(ctx context.Context, reader *pdf.Reader, stats *contentStats, opts analysisOptions) error {
+	fonts := newFontInventory()
+	sampler := newTextSampler(maxLanguageSamples)
+	stats.Pages = make([]PageReport, 0, stats.PageCount)
+
+	// The catalog is walked under the page timeout like any page, a crafted
+	// name tree must not hold the worker outside the deadlines
+	actions := &actionScanner{}
+	var catalogActions actionScanner
+	err := runPage(ctx, pa.timeouts.Page, func() {
+		catalogActions.addCatalog(reader.Trailer().Key("Root"))
+	})
+	if err == nil {
+		actions.merge(&catalogActions)
+	} else if errors.Is(err, errPageTimeout) {
+		// Document level scripts may be missing, so the result is not trusted as complete
+		pa.logger.Warn("Catalog action scan timed out, skipping it")
+		stats.markPartial(PartialPageTimeout)
+	}
+
+	for i := 1; i <= stats.PageCount; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		if resourcesLoop(page) {
+			pa.logger.WithField("page", i).Warn("Page inherits its resources through a Parent cycle, skipping page")
+			continue
+		}
+
+		// Everything that parses the page runs under the page timeout and only
+		// fills these, shared state is updated below once the page is back
+		var (
+			report      PageReport
+			text        string
+			rows        []TextRow
+			tables      []Table
+			pageFonts   []FontInfo
+			pageActions actionScanner
+			limitErr    error
+		)
+		err := runPage(ctx, pa.timeouts.Page, func() {
+			// Refuse pages whose streams inflate past the limits before parsing
+			// them, the content is held against the memory budget while parsed
+			charged, err := opts.limits.checkPage(page)
+			if limitErr = err; limitErr != nil {
+				return
+			}
+			defer opts.limits.release(charged)
+
+			report, text = pa.analyzePage(page, i)
+			rows, tables = opts.layoutPage(page, i)
+
+			pageFonts = collectPageFonts(page)
+			pageActions.addPage(page, i)
+		})
+		if errors.Is(err, errPageTimeout) {
+			pa.logger.WithField("page", i).Warn("Page analysis timed out, skipping page")
+			stats.Pages = append(stats.Pages, PageReport{PageNumber: i, TimedOut: true})
+			if stats.pageTimedOut(i) {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if limitErr != nil {
+			return fmt.Errorf("page %d: %w", i, limitErr)
+		}
+
+		sampler.add(i, text)
+		if opts.detectTables {
+			report.TableCount = len(tables)
+			stats.Tables = appendTables(stats.Tables, tables)
+		}
+		if err := opts.writePage(page, i, report.Images, text, rows, tables); err != nil {
+			return err
+		}
+		opts.fingerprint.addText(text)
+
+		// Fonts are shared between pages, the inventory reports each one once
+		report.Fonts = fonts.addFonts(pageFonts, i)
+		actions.merge(&pageActions)
+
+		stats.ImageCount += report.ImageCount
+		stats.Pages = append(stats.Pages, report)
+	}
+
+	if err := checkStopped(ctx, stats); err != nil {
+		return err
+	}
+
+	stats.Fonts = fonts.report()
+	stats.FontCount = fonts.count()
+	stats.Actions = actions.actions
+	stats.TextSamples = sampler.samples
+
+	pa.logger.WithFields(logrus.Fields{
+		"pages_analyzed": len(stats.Pages),
+		"image_count":    stats.ImageCount,
+		"font_count":     stats.FontCount,
+		"font_warnings":  len(stats.Fonts.Warnings),
+		"table_count":    len(stats.Tables),
+	}).Info("Page analysis completed")
+
+	return nil
+}
+
+// Walk a page range for its extraction outputs alone: the text artifact, tables,
+// images and entities. Documents that fan out leave this to their chunks, the
+// analyze step only classifies their pages. Returns the pages extracted.
+func (pa *PDFAnalyzer) extractPages(ctx context.Context, reader *pdf.Reader, first, last int, stats *contentStats, opts analysisOptions) (int, error) {
+	extracted := 0
+	for i := first; i <= last; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+
+		page := reader.Page(i)
+		if page.V.IsNull() || resourcesLoop(page) {
+			continue
+		}
+
+		var (
+			report   PageReport
+			text     string
+			rows     []TextRow
+			tables   []Table
+			limitErr error
+		)
+		err := runPage(ctx, pa.timeouts.Page, func() {
+			charged, err := opts.limits.checkPage(page)
+			if limitErr = err; limitErr != nil {
+				return
+			}
+			defer opts.limits.release(charged)
+			report, text = pa.analyzePage(page, i)
+			rows, tables = opts.layoutPage(page, i)
+		})
+		if errors.Is(err, errPageTimeout) {
+			pa.logger.WithField("page", i).Warn("Page extraction timed out, skipping page")
+			if stats.pageTimedOut(i) {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if limitErr != nil {
+			return extracted, fmt.Errorf("page %d: %w", i, limitErr)
+		}
+
+		stats.Tables = appendTables(stats.Tables, tables)
+		if err := opts.writePage(page, i, report.Images, text, rows, tables); err != nil {
+			return extracted, err
+		}
+		extracted++
+	}
+
+	return extracted, checkStopped(ctx, stats)
+}
+
+// Positioned rows and tables of a page. Positioned words are costly, they are
+// only laid out when the artifact or table detection needs them.
+func (opts analysisOptions) layoutPage(page pdf.Page, pageNumber int) (rows []TextRow, tables []Table) {
+	if opts.extractor.wantsPositions() || opts.detectTables {
+		rows = pageTextRows(page)
+	}
+	if opts.detectTables {
+		tables = detectTables(pageNumber, rows)
+	}
+	return rows, tables
+}
+
+// Write a page's images and text and scan it for entities, on the walking goroutine
+func (opts analysisOptions) writePage(page pdf.Page, pageNumber int, images []ImageInfo, text string, rows []TextRow, tables []Table) error {
+	if err := opts.images.extractPage(page, images); err != nil {
+		return fmt.Errorf("writing page %d images: %w", pageNumber, err)
+	}
+
+	// Stream the page text out now rather than holding every page in memory
+	if err := opts.extractor.writePage(PageText{PageNumber: pageNumber, Text: text, Rows: rows, Tables: tables}); err != nil {
+		return fmt.Errorf("writing page %d text: %w", pageNumber, err)
+	}
+	opts.entities.scanPage(pageNumber, text)
+	return nil
+}
+
+// Report for a single page, along with the text extracted from it
+func (pa *PDFAnalyzer) analyzePage(page pdf.Page, pageNumber int) (PageReport, string) {
+	report := PageReport{
+		PageNumber: pageNumber,
+		Rotation:   pageRotation(page),
+	}
+	report.Width, report.Height = pageDimensions(page)
+
+	// Inventory the embedded images and where they are drawn
+	report.Images, report.FullPageScan = pageImageInventory(page)
+	report.ImageCount = len(report.Images)
+
+	// Grab the text content from page
+	text, err := page.GetPlainText(nil) // Pass nil for fonts map
+	if err != nil {
+		pa.logger.WithError(err).Warnf("Failed to extract text from page %d", pageNumber)
+	} else {
+		report.TextExtracted = true
+		report.CharCount = countVisibleChars(text)
+	}
+
+	report.ContentType = classifyPageContent(report.CharCount, report.ImageCount)
+	report.IsScanned = report.ContentType == PageContentScanned
+
+	return report, text
+}
+
+// Image-only pages need OCR, text pages with images are mixed
+func classifyPageContent(charCount, imageCount int) string {
+	hasText := charCount >= scannedPageCharThreshold
+
+	switch {
+	case hasText && imageCount > 0:
+		return PageContentMixed
+	case hasText:
+		return PageContentText
+	case imageCount > 0:
+		return PageContentScanned
+	}
+	return PageContentBlank
+}
+
+// Rotation in degrees, normalized to 0, 90, 180 or 270
+func pageRotation(page pdf.Page) int {
+	rotate := inheritedPageKey(page, "Rotate")
+	if rotate.IsNull() {
+		return 0
+	}
+
+	rotation := int(rotate.Int64()) % 360
+	if rotation < 0 {
+		rotation += 360
+	}
+	return rotation
+}
+
+// Unrotated width and height in points, from the crop box when present
+func pageDimensions(page pdf.Page) (float64, float64) {
+	box := pageBox(page)
+	return box[2] - box[0], box[3] - box[1]
+}
+
+// Visible page area, normalized so the first corner is the lower left
+func pageBox(page pdf.Page) rect {
+	box := inheritedPageKey(page, "CropBox")
+	if box.Len() != 4 {
+		box = inheritedPageKey(page, "MediaBox")
+	}
+	if box.Len() != 4 {
+		return rect{}
+	}
+
+	x0, y0 := box.Index(0).Float64(), box.Index(1).Float64()
+	x1, y1 := box.Index(2).Float64(), box.Index(3).Float64()
+	return rect{math.Min(x0, x1), math.Min(y0, y1), math.Max(x0, x1), math.Max(y0, y1)}
+}
+
+// Look up a page attribute, following Parent links for inherited values
+func inheritedPageKey(page pdf.Page, key string) pdf.Value {
+	v := page.V
+	for depth := 0; depth < maxPageTreeDepth && !v.IsNull(); depth++ {
+		if value := v.Key(key); !value.IsNull() {
+			return value
+		}
+		v = v.Key("Parent")
+	}
+	return pdf.Value{}
+}
+
+// The pdf package follows Parent links without a bound when it looks up a
+// page's resources, so a page that has none on a cyclic chain would hang it
+func resourcesLoop(page pdf.Page) bool {
+	if !inheritedPageKey(page, "Resources").IsNull() {
+		return false
+	}
+	v := page.V
+	for depth := 0; depth < maxPageTreeDepth && !v.IsNull(); depth++ {
+		v = v.Key("Parent")
+	}
+	return !v.IsNull()
+}
+
+func countVisibleChars(text string) int {
+	count := 0
+	for _, r := range strings.TrimSpace(text) {
+		if !unicode.IsSpace(r) {
+			count++
+		}
+	}
+	return count
+}
@@ -1,32 +1,174 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 	"time"
 
-	"github.com/ledongthuc/pdf"
 	"github.com/sirupsen/logrus"
 )
 
 type PDFAnalyzer struct {
-	logger *logrus.Logger
+	logger  *logrus.Logger
+	storage Storage
+	pricing *PricingConfig
+	routing *RoutingPolicy
+	chunks  ChunkPlannerConfig
+	formats map[string]formatAnalyzer
+
+	extraction ExtractionConfig
+	duplicates *DuplicateIndex
+	lookupOnly bool // flag duplicates without recording analyzed documents
+	cache      *ResultCache
+	timeouts   TimeoutConfig
+	limits     ResourceLimits
+}
+
+// Optional PDFAnalyzer configuration
+type AnalyzerOption func(*PDFAnalyzer)
+
+// Read documents from the given storage backend
+func WithStorage(storage Storage) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.storage = storage
+	}
+}
+
+// Price documents with the given pricing config
+func WithPricing(pricing *PricingConfig) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.pricing = pricing
+	}
+}
+
+// Pick processing strategies with the given routing policy
+func WithRouting(routing *RoutingPolicy) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.routing = routing
+	}
+}
+
+// Split parallel documents within the given chunking limits
+func WithChunking(chunks ChunkPlannerConfig) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.chunks = chunks
+	}
+}
+
+// Write page text artifacts with the given extraction settings
+func WithTextExtraction(extraction ExtractionConfig) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.extraction = extraction
+	}
+}
+
+// Record fingerprints in the given index to flag re-uploaded documents
+func WithDuplicateIndex(duplicates *DuplicateIndex) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.duplicates = duplicates
+	}
+}
+
+// Copy of the analyzer that flags duplicates against its index without adding
+// the documents it analyzes, sharing everything else
+func (pa *PDFAnalyzer) withDuplicateLookupOnly() *PDFAnalyzer {
+	copied := *pa
+	copied.lookupOnly = true
+	return &copied
+}
+
+// Look a document up in the duplicate index, recording it unless lookup only
+func (pa *PDFAnalyzer) checkDuplicate(input DocumentInput, contentHash, textFingerprint string) (string, string, error) {
+	if pa.lookupOnly {
+		duplicateOf, match := pa.duplicates.Lookup(input.TenantID, input.ID, contentHash, textFingerprint)
+		return duplicateOf, match, nil
+	}
+	return pa.duplicates.Check(input.TenantID, input.ID, contentHash, textFingerprint)
+}
+
+// Serve repeated analyses of the same bytes from the given cache, nil disables caching
+func WithResultCache(cache *ResultCache) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.cache = cache
+	}
+}
+
+// Deadlines for content analysis, past them a partial result is returned
+func WithTimeouts(timeouts TimeoutConfig) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.timeouts = timeouts
+	}
+}
+
+// Refuse documents past these limits with a LimitError instead of parsing them
+func WithResourceLimits(limits ResourceLimits) AnalyzerOption {
+	return func(pa *PDFAnalyzer) {
+		pa.limits = limits
+	}
 }
 
 // Input data for document analysis
 type DocumentInput struct {
 	ID       string `json:"id"`
 	FilePath string `json:"file_path"`
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// Request the full text artifact for this document even when it is off by default
+	ExtractText      bool `json:"extract_text,omitempty"`
+	ExtractPositions bool `json:"extract_positions,omitempty"`
+	DetectTables     bool `json:"detect_tables,omitempty"`
+	ExtractImages    bool `json:"extract_images,omitempty"`
+	DetectPII        bool `json:"detect_pii,omitempty"`
 }
 
 // Output of document analysis
 type AnalysisResult struct {
 	DocumentID    string  `json:"document_id"`
+	Format        string  `json:"format"`
+	MimeType      string  `json:"mime_type"`
 	PageCount     int     `json:"page_count"`
 	FileSize      int64   `json:"file_size"`
 	ProcessType   string  `json:"process_type"`
 	EstimatedCost float64 `json:"estimated_cost"`
+	Encrypted     bool    `json:"encrypted"`
+	Cached        bool    `json:"cached,omitempty"`
+
+	// Set when the same bytes or text were analyzed before under another ID
+	ContentHash     string `json:"content_hash"`
+	TextFingerprint string `json:"text_fingerprint,omitempty"`
+	DuplicateOf     string `json:"duplicate_of,omitempty"`
+	DuplicateMatch  string `json:"duplicate_match,omitempty"`
+
+	Routing   RoutingDecision `json:"routing"`
+	ChunkPlan *ChunkPlan      `json:"chunk_plan,omitempty"`
+
+	PricingVersion string        `json:"pricing_version"`
+	CostBreakdown  CostBreakdown `json:"cost_breakdown"`
+
+	Metadata    DocumentMetadata   `json:"metadata"`
+	Fonts       *FontReport        `json:"fonts,omitempty"`
+	Conformance *ConformanceReport `json:"conformance,omitempty"`
+	Security    *SecurityReport    `json:"security,omitempty"`
+	Language    *LanguageReport    `json:"language"`
+	Metrics     ProcessingMetrics  `json:"metrics"`
+	Pages       []PageReport       `json:"pages"`
+
+	Tables     []Table             `json:"tables,omitempty"`
+	Entities   *EntityReport       `json:"entities,omitempty"`
+	Extraction *ExtractionArtifact `json:"extraction,omitempty"`
+
+	ImageExtraction *ImageExtraction `json:"image_extraction,omitempty"`
+
+	// Set when the text artifact, tables, images and entities are left to the
+	// chunk workflows of a parallel document, the fields above are empty then
+	ExtractionDeferred bool `json:"extraction_deferred,omitempty"`
+
+	// Set when a deadline cut the analysis short, the figures above cover only the pages read
+	Partial *PartialResult `json:"partial,omitempty"`
+
+	Error *AnalysisError `json:"error,omitempty"`
 }
 
 // Additional analytics metrics
@@ -34,17 +176,73 @@ type ProcessingMetrics struct {
 	IsTextBased     bool    `json:"is_text_based"`
 	ComplexityScore float64 `json:"complexity_score"`
 	AnalysisTime    int64   `json:"analysis_time"`
+
+	PagesSampled int     `json:"pages_sampled"`
+	TextDensity  float64 `json:"text_density"`
+	ImageCount   int     `json:"image_count"`
+	ImageDensity float64 `json:"image_density"`
+	FontCount    int     `json:"font_count"`
+
+	// Unembedded and Type3 fonts, each one adds to the complexity score
+	ProblemFontCount int `json:"problem_font_count"`
+}
+
+// Raw figures collected while walking the pdf
+type contentStats struct {
+	PageCount    int
+	IsTextBased  bool
+	IsEncrypted  bool
+	PagesSampled int
+	TextChars    int
+	ImageCount   int
+	FontCount    int
+	Pages        []PageReport
+	Metadata     DocumentMetadata
+	TextSamples  []pageSample
+	Tables       []Table
+	Fonts        *FontReport
+	Actions      []DocumentAction
+	Conformance  *ConformanceReport
+	Security     *SecurityReport
+	Partial      *PartialResult
+
+	// The walk only classified the pages, see analysisOptions.deferFromPages
+	ExtractionDeferred bool
 }
 
 // Init PDFAnalyzer
-func NewPDFAnalzyer(logger *logrus.Logger) *PDFAnalyzer {
-	return &PDFAnalyzer{
-		logger: logger,
+func NewPDFAnalzyer(logger *logrus.Logger, opts ...AnalyzerOption) *PDFAnalyzer {
+	pa := &PDFAnalyzer{
+		logger:  logger,
+		storage: &StorageRouter{Local: NewLocalStorage(defaultStorageRoot), Artifacts: newArtifactStorage(defaultArtifactRoot)},
+		pricing: DefaultPricingConfig(),
+		routing: DefaultRoutingPolicy(),
+		chunks:  DefaultChunkPlannerConfig(),
+
+		extraction: DefaultExtractionConfig(),
+		duplicates: NewDuplicateIndex(),
+		timeouts:   DefaultTimeoutConfig(),
+		limits:     DefaultResourceLimits(),
+	}
+	pa.formats = pa.defaultFormatAnalyzers()
+
+	for _, opt := range opts {
+		opt(pa)
 	}
+
+	// Results cached under another analyzer version or config are stale
+	if pa.cache != nil {
+		if err := pa.cache.bind(pa.cacheScope()); err != nil {
+			logger.WithError(err).Warn("Failed to clear stale cached results")
+		}
+	}
+
+	return pa
 }
 
-// Perform PDF analysis
-func (pa *PDFAnalyzer) AnalyzeDocument(input DocumentInput) (*AnalysisResult, error) {
+// Perform PDF analysis. Cancelling ctx abandons the analysis; content analysis
+// also stops at the document timeout, returning a partial result.
+func (pa *PDFAnalyzer) AnalyzeDocument(ctx context.Context, input DocumentInput) (*AnalysisResult, error) {
 	// create a timestamp
 	startTime := time.Now()
 
@@ -54,110 +252,440 @@ func (pa *PDFAnalyzer) AnalyzeDocument(input DocumentInput) (*AnalysisResult, er
 		"file_path": input.FilePath,
 	}).Info("Starting document analysis")
 
-	// Build full file path
-	fullFilePath := filepath.Join(".", "storage", "uploads", fmt.Sprintf("%s.pdf", input.ID))
-
-	// Check if file exists
-	fileInfo, err := os.Stat(fullFilePath)
+	// Open the document from wherever the uploader put it
+	location := documentLocation(input)
+	object, err := pa.storage.Open(ctx, location)
 	if err != nil {
-		pa.logger.WithError(err).Error("Failed to access PDF file")
-		return nil, fmt.Errorf("failed to access PDF file: %w", err)
+		pa.logger.WithError(err).WithField("location", location).Error("Failed to access document")
+		return nil, err
+	}
+	defer object.Close()
+
+	return pa.analyzeObject(ctx, input, object, startTime)
+}
+
+// Log where an analyzer bug panicked, the error message alone does not say
+func (pa *PDFAnalyzer) logPanicStack(err error) {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		pa.logger.WithField("stack", string(panicErr.Stack)).Error("Recovered panic in analyzer")
 	}
+}
 
-	fileSize := fileInfo.Size()
+// Analyze a document that is already open, such as an upload spooled by the HTTP API
+func (pa *PDFAnalyzer) analyzeObject(ctx context.Context, input DocumentInput, object Object, startTime time.Time) (*AnalysisResult, error) {
+	fileSize := object.Size()
 	pa.logger.WithField("file_size", fileSize).Info("Retrieved file size")
 
-	// Open and analyze the pdf
-	pageCount, isTextBased, err := pa.analyzePDFContent(fullFilePath)
+	// Oversized files are refused before anything reads them
+	limits := newResourceGuard(pa.limits)
+	if err := limits.checkFileSize(fileSize); err != nil {
+		pa.logger.WithError(err).Warn("Document exceeds resource limits")
+		return nil, err
+	}
+
+	// Identify the format from its magic bytes, not the file name
+	format, err := sniffFormat(object)
+	if err != nil {
+		pa.logger.WithError(err).Error("Failed to identify document format")
+		return nil, err
+	}
+	pa.logger.WithField("format", format).Info("Detected document format")
+
+	contentHash, err := hashObject(object)
 	if err != nil {
-		pa.logger.WithError(err).Error("Failed to analyze PDF content")
-		return nil, fmt.Errorf("failed to analyze PDF content: %w", err)
+		pa.logger.WithError(err).Error("Failed to hash document")
+		return nil, fmt.Errorf("failed to hash document: %w", err)
 	}
 
-	// Determine processing based on pdf page count
-	processType := "simple"
-	if pageCount >= 10 {
-		processType = "parallel"
+	// Retries and re-uploads of the same bytes are served from the cache
+	var key string
+	if pa.cacheable(input) {
+		key = cacheKey(contentHash, input)
+		if cached, ok := pa.cache.Get(key); ok {
+			return pa.cachedResult(cached, input, startTime), nil
+		}
+	}
+
+	// Page text is streamed to storage while the format analyzer walks the pages
+	extractor, err := pa.newTextExtractor(ctx, input, textArtifactName)
+	if err != nil {
+		pa.logger.WithError(err).Error("Failed to create text artifact")
+		return nil, err
+	}
+
+	images, err := pa.newImageExtractor(ctx, input)
+	if err != nil {
+		extractor.abort()
+		pa.logger.WithError(err).Error("Failed to prepare image extraction")
+		return nil, err
+	}
+
+	// Entities and PII are detected in the same text the artifact gets
+	entities := pa.newEntityDetector(input)
+	fingerprint := newTextFingerprinter()
+
+	// Open and analyze the document with its format analyzer, within the document deadline
+	analysisCtx, cancel := pa.timeouts.documentContext(ctx)
+	defer cancel()
+	opts := analysisOptions{
+		extractor:      extractor,
+		images:         images,
+		entities:       entities,
+		fingerprint:    fingerprint,
+		limits:         limits,
+		detectTables:   pa.extraction.DetectTables || input.DetectTables,
+		deferFromPages: pa.routing.fanOutMinPages(),
+	}
+	stats, err := pa.formats[format].analyze(analysisCtx, object, opts)
+	if err == nil {
+		// Formats without an up-front page count are held to the same limit once read
+		err = limits.checkPages(stats.PageCount)
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		extractor.abort()
+		pa.logger.WithError(err).Warn("Document exceeds resource limits")
+		return nil, err
+	}
+	if err != nil {
+		extractor.abort()
+		pa.logPanicStack(err)
+		pa.logger.WithError(err).Error("Failed to analyze document content")
+		return nil, fmt.Errorf("failed to analyze %s content: %w", format, err)
+	}
+
+	if stats.Partial != nil {
+		stats.Partial.PagesAnalyzed = pagesAnalyzed(stats.Pages)
+		pa.logger.WithFields(logrus.Fields{
+			"reason":          stats.Partial.Reason,
+			"pages_analyzed":  stats.Partial.PagesAnalyzed,
+			"pages_timed_out": len(stats.Partial.PagesTimedOut),
+			"skipped_stages":  stats.Partial.SkippedStages,
+		}).Warn("Document analysis cut short, returning partial result")
+	}
+
+	// Flag documents analyzed before under another ID so the pipeline can skip them.
+	// Text from a partial walk would not match a full copy, only the bytes are indexed.
+	textFingerprint := fingerprint.sum()
+	if stats.Partial != nil {
+		textFingerprint = ""
+	}
+	duplicateOf, duplicateMatch, err := pa.checkDuplicate(input, contentHash, textFingerprint)
+	if err != nil {
+		// The lookup itself succeeded, a failed append only loses this entry
+		pa.logger.WithError(err).Warn("Failed to record document in duplicate index")
+	}
+	if duplicateOf != "" {
+		pa.logger.WithFields(logrus.Fields{
+			"duplicate_of": duplicateOf,
+			"match":        duplicateMatch,
+		}).Info("Document is a duplicate")
+	}
+
+	// Check if document is text-based
+	pa.detectTextContent(stats)
+	pageCount, isTextBased := stats.PageCount, stats.IsTextBased
+
+	metrics := buildProcessingMetrics(stats)
+
+	// Detect languages and scripts from text sampled across the document
+	language := detectLanguages(stats.TextSamples)
+	pa.logger.WithFields(logrus.Fields{
+		"primary_language": language.PrimaryLanguage,
+		"confidence":       language.Confidence,
+	}).Info("Language detection completed")
+
+	// Determine processing strategy from the routing policy
+	facts := RoutingFacts{
+		PageCount:   pageCount,
+		FileSize:    fileSize,
+		TextDensity: metrics.TextDensity,
+		IsTextBased: isTextBased,
+		Encrypted:   stats.IsEncrypted,
+		Language:    routingLanguage(language),
+		RiskScore:   riskScore(stats.Security),
+		Partial:     stats.Partial != nil,
+	}
+	routing := pa.routing.Route(facts)
+
+	// A document that might fan out was only classified. Its chunks extract it
+	// when it does; otherwise it is extracted here, unless the walk already ran
+	// out of time, and routed again should the extraction run out of time.
+	if stats.ExtractionDeferred && !isParallelStrategy(routing.Strategy) {
+		stats.ExtractionDeferred = false
+		if stats.Partial == nil {
+			if err := pa.extractDocument(analysisCtx, object, stats, opts); err != nil {
+				extractor.abort()
+				pa.logPanicStack(err)
+				pa.logger.WithError(err).Error("Failed to extract document content")
+				return nil, fmt.Errorf("failed to extract %s content: %w", format, err)
+			}
+			if stats.Partial != nil {
+				stats.Partial.PagesAnalyzed = pagesAnalyzed(stats.Pages)
+				facts.Partial = true
+				routing = pa.routing.Route(facts)
+			}
+		} else {
+			pa.logger.Warn("Skipping extraction of a document cut short by a deadline")
+		}
+	}
+	if stats.ExtractionDeferred {
+		extractor.abort()
+		extractor, images, entities = nil, nil, nil
+	}
+
+	var extraction *ExtractionArtifact
+	if extractor != nil {
+		if extraction, err = extractor.commit(); err != nil {
+			pa.logger.WithError(err).Error("Failed to write text artifact")
+			return nil, err
+		}
+		pa.logger.WithFields(logrus.Fields{
+			"location":      extraction.Location,
+			"pages_written": extraction.PagesWritten,
+			"bytes":         extraction.Bytes,
+		}).Info("Text artifact written")
+	}
+
+	// Plan page ranges for documents that fan out, chunk workflows read pdfs only
+	var chunkPlan *ChunkPlan
+	if isParallelStrategy(routing.Strategy) && format == FormatPDF {
+		chunkPlan = PlanChunks(pa.chunks, pageCount, stats.Pages)
+		pa.logger.WithFields(logrus.Fields{
+			"chunk_count": chunkPlan.ChunkCount,
+			"waves":       chunkPlan.Waves,
+		}).Info("Chunk plan created")
 	}
 
 	// Calculate estimated cost
-	estimatedCost := pa.calculateEstimatedCost(pageCount, fileSize, isTextBased)
+	costBreakdown := pa.calculateEstimatedCost(input.TenantID, pageCount, fileSize, isTextBased)
 
-	analysisTime := time.Since(startTime).Milliseconds()
+	metrics.AnalysisTime = time.Since(startTime).Milliseconds()
 
 	result := &AnalysisResult{
 		DocumentID:    input.ID,
+		Format:        format,
+		MimeType:      formatMimeTypes[format],
 		PageCount:     pageCount,
 		FileSize:      fileSize,
-		ProcessType:   processType,
-		EstimatedCost: estimatedCost,
+		ProcessType:   routing.Strategy,
+		EstimatedCost: costBreakdown.Total,
+		Encrypted:     stats.IsEncrypted,
+
+		ContentHash:     contentHash,
+		TextFingerprint: textFingerprint,
+		DuplicateOf:     duplicateOf,
+		DuplicateMatch:  duplicateMatch,
+
+		Routing:   routing,
+		ChunkPlan: chunkPlan,
+
+		PricingVersion: costBreakdown.PricingVersion,
+		CostBreakdown:  costBreakdown,
+
+		Metadata:    stats.Metadata,
+		Fonts:       stats.Fonts,
+		Conformance: stats.Conformance,
+		Security:    stats.Security,
+		Language:    language,
+		Metrics:     metrics,
+		Pages:       stats.Pages,
+
+		Tables:     stats.Tables,
+		Entities:   entities.result(),
+		Extraction: extraction,
+
+		ImageExtraction:    images.result(),
+		ExtractionDeferred: stats.ExtractionDeferred,
+
+		Partial: stats.Partial,
+	}
+
+	// A partial result depends on how busy the worker was, the next attempt may finish
+	if key != "" && result.Partial == nil {
+		if err := pa.cache.Put(key, result); err != nil {
+			pa.logger.WithError(err).Warn("Failed to write cached result")
+		}
 	}
 
 	pa.logger.WithFields(logrus.Fields{
-		"document_id":     result.DocumentID,
-		"page_count":      result.PageCount,
-		"file_size":       result.FileSize,
-		"process_type":    result.ProcessType,
-		"estimated_cost":  result.EstimatedCost,
-		"analysis_time":   analysisTime,
-		"is_text_based":   isTextBased,
+		"document_id":      result.DocumentID,
+		"format":           result.Format,
+		"page_count":       result.PageCount,
+		"file_size":        result.FileSize,
+		"process_type":     result.ProcessType,
+		"routing_rule":     result.Routing.Rule,
+		"estimated_cost":   result.EstimatedCost,
+		"pricing_version":  result.PricingVersion,
+		"analysis_time":    metrics.AnalysisTime,
+		"is_text_based":    isTextBased,
+		"complexity_score": metrics.ComplexityScore,
 	}).Info("Document analysis completed")
 
 	// return the result
 	return result, nil
 }
 
-// Perform basic text detection
-func (pa *PDFAnalyzer) analyzePDFContent(filePath string) (int, bool, error) {
-	// Open the file, reader, error etc
-	file, reader, err := pdf.Open(filePath)
+// A cached result relabelled for this document, with its own duplicate lookup
+func (pa *PDFAnalyzer) cachedResult(result *AnalysisResult, input DocumentInput, startTime time.Time) *AnalysisResult {
+	result.DocumentID = input.ID
+	result.Cached = true
+
+	var err error
+	result.DuplicateOf, result.DuplicateMatch, err = pa.checkDuplicate(input, result.ContentHash, result.TextFingerprint)
 	if err != nil {
-		return 0, false, fmt.Errorf("failed to open PDF: %w", err)
+		pa.logger.WithError(err).Warn("Failed to record document in duplicate index")
 	}
-	defer file.Close()
 
-	// Get the page count
-	pageCount := reader.NumPage()
-	pa.logger.WithField("page_count", pageCount).Info("Extracted page count")
+	result.Metrics.AnalysisTime = time.Since(startTime).Milliseconds()
 
-	// Check if document is text-based
-	isTextBased := pa.detectTextContent(reader, pageCount)
+	stats := pa.cache.Stats()
+	pa.logger.WithFields(logrus.Fields{
+		"document_id":   result.DocumentID,
+		"process_type":  result.ProcessType,
+		"duplicate_of":  result.DuplicateOf,
+		"cache_hits":    stats.Hits,
+		"cache_misses":  stats.Misses,
+		"hit_ratio":     stats.HitRatio(),
+		"analysis_time": result.Metrics.AnalysisTime,
+	}).Info("Document analysis served from cache")
+
+	return result
+}
 
-	// return page count, and whether its text based
-	return pageCount, isTextBased, nil
+// Storage location for a document, falls back to <id>.pdf in the upload root
+func documentLocation(input DocumentInput) string {
+	if input.FilePath != "" {
+		return input.FilePath
+	}
+	return fmt.Sprintf("%s.pdf", input.ID)
 }
 
-func (pa *PDFAnalyzer) detectTextContent(reader *pdf.Reader, pageCount int) bool {
-	// Check up to the first three pgs
-	pagesToCheck := 3
-	if pageCount < pagesToCheck {
-		pagesToCheck = pageCount
+// Perform basic text detection. When ctx reaches the document deadline the pages
+// read so far are kept and the stages that did not get to run are listed as skipped.
+func (pa *PDFAnalyzer) analyzePDFContent(ctx context.Context, file io.ReaderAt, fileSize int64, opts analysisOptions) (stats *contentStats, err error) {
+	// Malformed objects deep in the file make the pdf package panic
+	defer recoverPDFPanic(&err)
+
+	// A huge declared object count would be allocated while opening the reader
+	if err := opts.limits.checkObjects(file, fileSize); err != nil {
+		return nil, err
 	}
 
-	textCharCount := 0
-	totalChecked := 0
+	// Open the pdf reader
+	reader, err := openPDFReader(file, fileSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+	if err := opts.limits.checkPages(reader.NumPage()); err != nil {
+		return nil, err
+	}
 
-	for i := 1; i <= pagesToCheck; i++ {
-		page := reader.Page(i)
+	// Get the page count
+	stats = &contentStats{
+		PageCount:   reader.NumPage(),
+		IsEncrypted: !reader.Trailer().Key("Encrypt").IsNull(),
+	}
+	pa.logger.WithField("page_count", stats.PageCount).Info("Extracted page count")
 
-		if page.V.IsNull() {
-			continue
+	// Read document info, version and outline
+	stats.Metadata = extractMetadata(file, reader)
+
+	// Documents that may fan out are only classified here, chunks extract them
+	if opts.deferFromPages > 0 && stats.PageCount >= opts.deferFromPages && opts.extracts() {
+		stats.ExtractionDeferred = true
+		opts = opts.withoutExtraction()
+	}
+
+	// Encoded image streams are read straight from the file
+	opts.images.attach(file, fileSize, stats.IsEncrypted, opts.limits)
+
+	// Build a report for every page, counting images and fonts as we go
+	if err := pa.analyzePages(ctx, reader, stats, opts); err != nil {
+		return nil, err
+	}
+	// The context may also end between the last page and the stages after it
+	if ctx.Err() != nil {
+		if err := checkStopped(ctx, stats); err != nil {
+			return nil, err
 		}
+		stats.Partial.SkippedStages = []string{StageConformance, StageSecurity}
+		return stats, nil
+	}
 
-		// Grab the text content from page
-		text, err := page.GetPlainText(nil) // Pass nil for fonts map
-		if err != nil {
-			pa.logger.WithError(err).Warnf("Failed to extract text from page %d", i)
+	// Structural PDF/A pre-check, ahead of archiving. It walks the catalog, so
+	// it runs under the document deadline like the pages.
+	var conformance *ConformanceReport
+	if err := runPage(ctx, 0, func() { conformance = checkConformance(reader, stats) }); err != nil {
+		if err := checkStopped(ctx, stats); err != nil {
+			return nil, err
+		}
+		stats.Partial.SkippedStages = []string{StageConformance, StageSecurity}
+		return stats, nil
+	}
+	stats.Conformance = conformance
+	pa.logger.WithFields(logrus.Fields{
+		"claimed":    stats.Conformance.Claimed,
+		"plausible":  stats.Conformance.Plausible,
+		"violations": len(stats.Conformance.Violations),
+	}).Info("Conformance check completed")
+
+	// Active content scan, routing quarantines risky documents before parsing
+	if stats.Security, err = scanSecurity(ctx, file, fileSize, reader, stats); err != nil {
+		if ctx.Err() == nil {
+			return nil, fmt.Errorf("security scan failed: %w", err)
+		}
+		if err := checkStopped(ctx, stats); err != nil {
+			return nil, err
+		}
+		stats.Partial.SkippedStages = append(stats.Partial.SkippedStages, StageSecurity)
+		return stats, nil
+	}
+	pa.logger.WithFields(logrus.Fields{
+		"risk_score": stats.Security.RiskScore,
+		"risk_level": stats.Security.RiskLevel,
+		"findings":   len(stats.Security.Findings),
+	}).Info("Security scan completed")
+
+	// return the collected stats
+	return stats, nil
+}
+
+// Second walk extracting a document whose first walk deferred extraction but
+// that is not fanned out after all
+func (pa *PDFAnalyzer) extractDocument(ctx context.Context, object Object, stats *contentStats, opts analysisOptions) (err error) {
+	defer recoverPDFPanic(&err)
+
+	reader, err := openPDFReader(object, object.Size())
+	if err != nil {
+		return fmt.Errorf("failed to open PDF: %w", err)
+	}
+	opts.images.attach(object, object.Size(), stats.IsEncrypted, opts.limits)
+
+	_, err = pa.extractPages(ctx, reader, 1, stats.PageCount, stats, opts)
+	return err
+}
+
+func (pa *PDFAnalyzer) detectTextContent(stats *contentStats) {
+	textCharCount := 0
+	totalChecked := 0
+
+	// Sum the text found on every page that could be read
+	for _, page := range stats.Pages {
+		if !page.TextExtracted {
 			continue
 		}
 
-		textCharCount += len(text)
+		textCharCount += page.CharCount
 		totalChecked++
 	}
 
+	stats.PagesSampled = totalChecked
+	stats.TextChars = textCharCount
+
 	// if average chars per page is > 100, its text based
 	if totalChecked == 0 {
-		return false
+		return
 	}
 
 	avgCharsPerPage := textCharCount / totalChecked
@@ -170,38 +698,32 @@ func (pa *PDFAnalyzer) detectTextContent(reader *pdf.Reader, pageCount int) bool
 		"is_text_based":        isTextBased,
 	}).Info("Text content analysis completed")
 
-	// return whether or not this is a text based doc or not
-	return isTextBased
+	// record whether or not this is a text based doc
+	stats.IsTextBased = isTextBased
 }
 
 // calculate processing cost based on document characteristics
 func (pa *PDFAnalyzer) calculateEstimatedCost(
+	tenantID string,
 	pageCount int,
 	fileSize int64,
 	isTextBased bool,
-) float64 {
-	// Set base cost per doc, cost per page and cost per mb
-	baseCost := 0.10 // Base cost per document
-	pageCost := float64(pageCount) * 0.05 // $0.05 per page
-	sizeCost := float64(fileSize) / (1024 * 1024) * 0.02 // $0.02 per MB
-
-	// If the page isn't a text based doc its 50% more expensive
-	multiplier := 1.0
-	if !isTextBased {
-		multiplier = 1.5 // 50% more expensive for image-heavy documents
-	}
-
-	totalCost := (baseCost + pageCost + sizeCost) * multiplier
+) CostBreakdown {
+	// Price the document with the configured rates
+	breakdown := pa.pricing.Estimate(tenantID, pageCount, fileSize, isTextBased)
 
 	// Log details
 	pa.logger.WithFields(logrus.Fields{
-		"base_cost":    baseCost,
-		"page_cost":    pageCost,
-		"size_cost":    sizeCost,
-		"multiplier":   multiplier,
-		"total_cost":   totalCost,
+		"pricing_version": breakdown.PricingVersion,
+		"tenant_id":       breakdown.TenantID,
+		"base_cost":       breakdown.BaseCost,
+		"page_cost":       breakdown.PageCost,
+		"size_cost":       breakdown.SizeCost,
+		"multiplier":      breakdown.Multiplier,
+		"discount":        breakdown.Discount,
+		"total_cost":      breakdown.Total,
 	}).Debug("Cost calculation completed")
 
-	// return total cost
-	return totalCost
+	// return itemized cost
+	return breakdown
 }
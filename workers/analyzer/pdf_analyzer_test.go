@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Analyzer reading documents from testdata, logging nowhere
+func newTestAnalyzer(t *testing.T, opts ...AnalyzerOption) *PDFAnalyzer {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	storage := &StorageRouter{Local: &LocalStorage{Root: "testdata"}, Artifacts: newArtifactStorage(t.TempDir())}
+	return NewPDFAnalzyer(logger, append([]AnalyzerOption{WithStorage(storage)}, opts...)...)
+}
+
+// Analyze a testdata document, failing the test on error
+func analyzeFixture(t *testing.T, pa *PDFAnalyzer, name string) *AnalysisResult {
+	t.Helper()
+
+	result, err := pa.AnalyzeDocument(context.Background(), DocumentInput{ID: name, FilePath: name})
+	if err != nil {
+		t.Fatalf("analyzing %s: %v", name, err)
+	}
+	return result
+}
+
+func TestAnalyzeDocumentMetrics(t *testing.T) {
+	result := analyzeFixture(t, newTestAnalyzer(t), "sample.pdf")
+	metrics := result.Metrics
+
+	// One text page set in unembedded Helvetica
+	if !metrics.IsTextBased || metrics.PagesSampled != 1 || metrics.ImageCount != 0 {
+		t.Errorf("unexpected counts: %+v", metrics)
+	}
+	if metrics.FontCount != 1 || metrics.ProblemFontCount != 1 {
+		t.Errorf("got %d fonts, %d with problems, want 1 and 1", metrics.FontCount, metrics.ProblemFontCount)
+	}
+	if metrics.TextDensity != 440 {
+		t.Errorf("TextDensity = %v, want 440", metrics.TextDensity)
+	}
+	if metrics.ComplexityScore != 22.65 {
+		t.Errorf("ComplexityScore = %v, want 22.65", metrics.ComplexityScore)
+	}
+	if metrics.AnalysisTime < 0 {
+		t.Errorf("AnalysisTime = %d", metrics.AnalysisTime)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Kinds of PDF failures, match with errors.Is
+var (
+	ErrEncryptedPDF   = errors.New("pdf is encrypted")
+	ErrTruncatedPDF   = errors.New("pdf is truncated")
+	ErrNotPDF         = errors.New("not a pdf file")
+	ErrUnsupportedPDF = errors.New("pdf uses an unsupported feature")
+	ErrCorruptedPDF   = errors.New("pdf is corrupted")
+)
+
+// A bug in the analyzer rather than a problem with the document, retryable
+var ErrInternal = errors.New("internal analyzer error")
+
+// A classified PDF failure, Kind is one of the Err*PDF sentinels
+type PDFError struct {
+	Kind error
+	Err  error
+}
+
+func (e *PDFError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *PDFError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PDFError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// A panic raised outside the pdf package, with the stack it was raised on
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: panic: %v", ErrInternal, e.Value)
+}
+
+func (e *PanicError) Is(target error) bool {
+	return target == ErrInternal
+}
+
+// Failure details reported in place of a result for permanent errors
+type AnalysisError struct {
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+// Errors that will fail the same way no matter how often they are retried
+var permanentErrors = []error{
+	ErrEncryptedPDF,
+	ErrTruncatedPDF,
+	ErrNotPDF,
+	ErrUnsupportedPDF,
+	ErrCorruptedPDF,
+	ErrUnsupportedFormat,
+	ErrCorruptedDocument,
+	ErrPathTraversal,
+	ErrAccessDenied,
+	ErrUnsupportedLocation,
+	ErrLimitExceeded,
+	ErrInvalidChunk,
+}
+
+func IsPermanentError(err error) bool {
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Short machine readable name for an error kind
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrEncryptedPDF):
+		return "encrypted"
+	case errors.Is(err, ErrTruncatedPDF):
+		return "truncated"
+	case errors.Is(err, ErrNotPDF):
+		return "not-a-pdf"
+	case errors.Is(err, ErrUnsupportedPDF):
+		return "unsupported-feature"
+	case errors.Is(err, ErrCorruptedPDF), errors.Is(err, ErrCorruptedDocument):
+		return "corrupted"
+	case errors.Is(err, ErrUnsupportedFormat):
+		return "unsupported-format"
+	case errors.Is(err, ErrPathTraversal):
+		return "path-traversal"
+	case errors.Is(err, ErrAccessDenied):
+		return "access-denied"
+	case errors.Is(err, ErrUnsupportedLocation):
+		return "unsupported-location"
+	case errors.Is(err, ErrObjectNotFound):
+		return "not-found"
+	case errors.Is(err, ErrLimitExceeded):
+		return "limit-exceeded"
+	case errors.Is(err, ErrInvalidChunk):
+		return "invalid-chunk"
+	}
+	return "internal"
+}
+
+func newAnalysisError(err error) *AnalysisError {
+	return &AnalysisError{
+		Kind:      errorKind(err),
+		Message:   err.Error(),
+		Retryable: !IsPermanentError(err),
+	}
+}
+
+// Result for a document that can never be analyzed. Hatchet retries every
+// error a step returns, so permanent failures complete with a reject instead.
+func rejectedResult(input DocumentInput, err error) *AnalysisResult {
+	// Documents over a resource limit get their own rule so they can be told apart
+	rule := "permanent-error"
+	if errors.Is(err, ErrLimitExceeded) {
+		rule = "resource-limit"
+	}
+
+	return &AnalysisResult{
+		DocumentID:  input.ID,
+		ProcessType: StrategyReject,
+		Routing: RoutingDecision{
+			Strategy: StrategyReject,
+			Rule:     rule,
+		},
+		Error: newAnalysisError(err),
+		Pages: []PageReport{},
+	}
+}
+
+// Open a pdf reader, classifying any failure into a PDFError
+func openPDFReader(file io.ReaderAt, fileSize int64) (reader *pdf.Reader, err error) {
+	// The pdf package panics on some malformed input
+	defer recoverPDFPanic(&err)
+
+	header := make([]byte, 8)
+	n, _ := file.ReadAt(header, 0)
+	header = header[:n]
+
+	if !bytes.HasPrefix(header, []byte("%PDF-")) {
+		return nil, &PDFError{Kind: ErrNotPDF, Err: errors.New("missing %PDF- header")}
+	}
+	if !bytes.HasPrefix(header, []byte("%PDF-1.")) {
+		return nil, &PDFError{Kind: ErrUnsupportedPDF, Err: fmt.Errorf("pdf version %q", bytes.TrimSpace(header[5:]))}
+	}
+
+	// Too short to hold a trailer, the reader would index past the buffer
+	if fileSize < 64 {
+		return nil, &PDFError{Kind: ErrTruncatedPDF, Err: fmt.Errorf("file is only %d bytes", fileSize)}
+	}
+
+	reader, err = pdf.NewReader(file, fileSize)
+	if err != nil {
+		return nil, classifyPDFError(err)
+	}
+	return reader, nil
+}
+
+// Map pdf package errors onto PDFError kinds
+func classifyPDFError(err error) error {
+	var pdfErr *PDFError
+	if errors.As(err, &pdfErr) {
+		return err
+	}
+
+	message := err.Error()
+	switch {
+	case errors.Is(err, pdf.ErrInvalidPassword),
+		strings.HasPrefix(message, "unsupported PDF: encryption"),
+		strings.Contains(message, "encryption key"),
+		strings.Contains(message, "encryption parameters"):
+		return &PDFError{Kind: ErrEncryptedPDF, Err: err}
+	case strings.HasPrefix(message, "not a PDF file: invalid header"):
+		return &PDFError{Kind: ErrNotPDF, Err: err}
+	case strings.Contains(message, "missing %%EOF"),
+		strings.Contains(message, "startxref"),
+		strings.Contains(message, "cross-reference table not found"):
+		return &PDFError{Kind: ErrTruncatedPDF, Err: err}
+	case strings.HasPrefix(message, "unsupported"):
+		return &PDFError{Kind: ErrUnsupportedPDF, Err: err}
+	}
+	return &PDFError{Kind: ErrCorruptedPDF, Err: err}
+}
+
+// Turn a panic from the pdf package into a classified error. Any other panic
+// is a bug, reported as a PanicError so it is retried and its stack logged.
+func recoverPDFPanic(err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	p := capturePanic(recovered)
+	if !p.fromPDF {
+		*err = &PanicError{Value: p.value, Stack: p.stack}
+		return
+	}
+
+	panicErr, ok := p.value.(error)
+	if !ok {
+		panicErr = fmt.Errorf("%v", p.value)
+	}
+	*err = classifyPDFError(panicErr)
+}
+
+const pdfPackage = "github.com/ledongthuc/pdf."
+
+// A recovered panic and where it was raised, captured by the deferred
+// function that recovered it while the panicking stack is still live
+type capturedPanic struct {
+	value   any
+	fromPDF bool
+	stack   []byte
+}
+
+// Capture a value returned by recover, must be called from the deferred
+// function itself. A panic re-raised by runPage keeps its original origin.
+func capturePanic(recovered any) *capturedPanic {
+	if p, ok := recovered.(*capturedPanic); ok {
+		return p
+	}
+	return &capturedPanic{value: recovered, fromPDF: panickedInPDF(), stack: debug.Stack()}
+}
+
+// Whether the innermost frame below the panic that belongs to the pdf package
+// or the analyzer is in the pdf package. Runtime and standard library frames,
+// such as an index check or a reader the pdf package called, are skipped.
+func panickedInPDF() bool {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			panicking = true
+		case !panicking:
+		case strings.HasPrefix(frame.Function, pdfPackage):
+			return true
+		case strings.HasPrefix(frame.Function, "main."):
+			return false
+		}
+		if !more {
+			return false
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// A trailer pointing past the end of the file, the pdf package panics reading it
+var xrefPastEnd = "%PDF-1.4\n" + strings.Repeat(" ", 80) + "\nstartxref\n999999\n%%EOF\n"
+
+func TestOpenPDFReader(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"sample", string(sample), nil},
+		{"not a pdf", "PK\x03\x04 a zip archive, not a pdf at all", ErrNotPDF},
+		{"pdf 2.0", "%PDF-2.0\n" + strings.Repeat(" ", 64) + "\n%%EOF\n", ErrUnsupportedPDF},
+		{"too short for a trailer", "%PDF-1.4\n%%EOF\n", ErrTruncatedPDF},
+		{"cut off", string(sample[:len(sample)/2]), ErrTruncatedPDF},
+		{"no cross-reference table", "%PDF-1.4\n" + strings.Repeat("x", 64) + "\nstartxref\n9\n%%EOF\n", ErrTruncatedPDF},
+		{"unsupported encryption", "%PDF-1.4\nxref\n0 1\n0000000000 65535 f \ntrailer\n" +
+			"<< /Size 1 /Root 1 0 R /Encrypt << /Filter /Standard /V 9 >> >>\nstartxref\n9\n%%EOF\n", ErrEncryptedPDF},
+		{"panic in the pdf package", xrefPastEnd, ErrCorruptedPDF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := openPDFReader(strings.NewReader(tt.data), int64(len(tt.data)))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("openPDFReader() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && reader.NumPage() != 1 {
+				t.Errorf("NumPage() = %d, want 1", reader.NumPage())
+			}
+			if err != nil && !IsPermanentError(err) {
+				t.Errorf("%v is retryable, malformed documents never parse", err)
+			}
+		})
+	}
+}
+
+func TestRecoverPDFPanic(t *testing.T) {
+	analyzerBug := func() {
+		var pages map[int]string
+		pages[1] = "nil map"
+	}
+	pdfPanic := func() {
+		pdf.NewReader(bytes.NewReader([]byte(xrefPastEnd)), int64(len(xrefPastEnd)))
+	}
+	recovered := func(fn func()) (err error) {
+		defer recoverPDFPanic(&err)
+		fn()
+		return nil
+	}
+	onPage := func(fn func()) func() {
+		return func() { runPage(context.Background(), 0, fn) }
+	}
+
+	tests := []struct {
+		name string
+		fn   func()
+		want error
+	}{
+		{"pdf package", pdfPanic, ErrCorruptedPDF},
+		{"pdf package on a page goroutine", onPage(pdfPanic), ErrCorruptedPDF},
+		{"analyzer", analyzerBug, ErrInternal},
+		{"analyzer on a page goroutine", onPage(analyzerBug), ErrInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := recovered(tt.fn)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("recovered %v, want %v", err, tt.want)
+			}
+			if tt.want != ErrInternal {
+				return
+			}
+
+			// Analyzer bugs are retried and keep the stack of the original panic
+			if IsPermanentError(err) || errorKind(err) != "internal" {
+				t.Errorf("%v: permanent %v, kind %q", err, IsPermanentError(err), errorKind(err))
+			}
+			var panicErr *PanicError
+			if !errors.As(err, &panicErr) || !strings.Contains(string(panicErr.Stack), "TestRecoverPDFPanic") {
+				t.Errorf("stack does not show where the panic was raised: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Entity types the detector reports
+const (
+	EntityEmail      = "email"
+	EntityPhone      = "phone"
+	EntityIBAN       = "iban"
+	EntityCreditCard = "credit_card"
+	EntitySSN        = "ssn"
+	EntityDate       = "date"
+	EntityMoney      = "money"
+)
+
+// Personal data is masked in the report and listed for redaction,
+// dates and amounts are reported as found
+var piiEntityTypes = map[string]bool{
+	EntityEmail:      true,
+	EntityPhone:      true,
+	EntityIBAN:       true,
+	EntityCreditCard: true,
+	EntitySSN:        true,
+}
+
+// Report size limits, counts always cover the whole document
+const (
+	maxReportedEntities = 1000
+	maxRedactionSpans   = 10000
+)
+
+// An entity found in page text; offsets are byte offsets into the page text
+// as written to the text artifact
+type Entity struct {
+	Type  string `json:"type"`
+	Page  int    `json:"page"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Value string `json:"value"`
+	PII   bool   `json:"pii"`
+}
+
+// A byte range of page text to black out, adjacent entities are merged
+type RedactionSpan struct {
+	Page  int      `json:"page"`
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+	Types []string `json:"types"`
+}
+
+// Entities and personal data found across the document
+type EntityReport struct {
+	Counts     map[string]int  `json:"counts"`
+	PIICount   int             `json:"pii_count"`
+	Entities   []Entity        `json:"entities"`
+	Redactions []RedactionSpan `json:"redactions"`
+	Truncated  bool            `json:"truncated,omitempty"`
+}
+
+// A pattern for one entity type, check returns how many bytes of the match
+// form a valid entity or zero to reject it
+type entityPattern struct {
+	entityType string
+	pattern    *regexp.Regexp
+	check      func(match string) int
+}
+
+const monthNames = `(?:Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|June?|July?|Aug(?:ust)?|Sep(?:t(?:ember)?)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?)`
+
+const moneyAmount = `\d+(?:[,.' ]\d{3})*(?:[.,]\d{1,2})?`
+
+// Patterns in priority order, a match overlapping an earlier type's match is dropped
+var entityPatterns = []entityPattern{
+	{
+		entityType: EntityEmail,
+		pattern:    regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+		check:      func(match string) int { return len(match) },
+	},
+	{
+		entityType: EntityIBAN,
+		pattern:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+		check:      checkIBAN,
+	},
+	{
+		entityType: EntityCreditCard,
+		pattern:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		check:      checkCardNumber,
+	},
+	{
+		entityType: EntitySSN,
+		pattern:    regexp.MustCompile(`\b\d{3}[- ]\d{2}[- ]\d{4}\b`),
+		check:      checkSSN,
+	},
+	{
+		entityType: EntityDate,
+		pattern: regexp.MustCompile(`(?i)\b\d{4}-\d{1,2}-\d{1,2}\b` +
+			`|\b\d{1,2}[/.]\d{1,2}[/.](?:\d{4}|\d{2})\b` +
+			`|\b\d{1,2}(?:st|nd|rd|th)? ` + monthNames + `\.?,? \d{4}\b` +
+			`|\b` + monthNames + `\.? \d{1,2}(?:st|nd|rd|th)?,? \d{4}\b`),
+		check: checkDate,
+	},
+	{
+		entityType: EntityPhone,
+		pattern:    regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,4}\b`),
+		check:      checkPhone,
+	},
+	{
+		entityType: EntityMoney,
+		pattern: regexp.MustCompile(`[$€£¥₹] ?` + moneyAmount +
+			`|\b(?:USD|EUR|GBP|CHF|JPY|CAD|AUD|CNY|INR) ?` + moneyAmount +
+			`|\b` + moneyAmount + ` ?(?:(?:USD|EUR|GBP|CHF|JPY|CAD|AUD|CNY|INR)\b|[€£$])`),
+		check: func(match string) int { return len(match) },
+	},
+}
+
+// Runs the entity patterns over each page's text as the format analyzers walk it
+type entityDetector struct {
+	report *EntityReport
+}
+
+// Detector for a document, nil when PII detection is off
+func (pa *PDFAnalyzer) newEntityDetector(input DocumentInput) *entityDetector {
+	if !pa.extraction.DetectPII && !input.DetectPII {
+		return nil
+	}
+	return &entityDetector{report: &EntityReport{
+		Counts:     make(map[string]int),
+		Entities:   []Entity{},
+		Redactions: []RedactionSpan{},
+	}}
+}
+
+// Detect entities on one page, safe to call on a nil detector
+func (ed *entityDetector) scanPage(pageNumber int, text string) {
+	if ed == nil || text == "" {
+		return
+	}
+
+	entities := detectEntities(pageNumber, text)
+	for _, entity := range entities {
+		ed.report.Counts[entity.Type]++
+		if entity.PII {
+			ed.report.PIICount++
+		}
+		if len(ed.report.Entities) < maxReportedEntities {
+			ed.report.Entities = append(ed.report.Entities, entity)
+		} else {
+			ed.report.Truncated = true
+		}
+	}
+
+	for _, span := range redactionSpans(entities) {
+		if len(ed.report.Redactions) < maxRedactionSpans {
+			ed.report.Redactions = append(ed.report.Redactions, span)
+		} else {
+			ed.report.Truncated = true
+		}
+	}
+}
+
+// Everything found so far, nil on a nil detector
+func (ed *entityDetector) result() *EntityReport {
+	if ed == nil {
+		return nil
+	}
+	return ed.report
+}
+
+// Non-overlapping entities on a page in text order
+func detectEntities(pageNumber int, text string) []Entity {
+	var entities []Entity
+	for _, ep := range entityPatterns {
+		for _, loc := range ep.pattern.FindAllStringIndex(text, -1) {
+			start := loc[0]
+			end := start + ep.check(text[loc[0]:loc[1]])
+			if end == start || overlapsEntity(entities, start, end) {
+				continue
+			}
+
+			entity := Entity{
+				Type:  ep.entityType,
+				Page:  pageNumber,
+				Start: start,
+				End:   end,
+				Value: text[start:end],
+				PII:   piiEntityTypes[ep.entityType],
+			}
+			if entity.PII {
+				entity.Value = maskEntity(entity.Type, entity.Value)
+			}
+
+			// Keep entities sorted by offset for the overlap search
+			i := sort.Search(len(entities), func(i int) bool { return entities[i].Start >= start })
+			entities = append(entities, Entity{})
+			copy(entities[i+1:], entities[i:])
+			entities[i] = entity
+		}
+	}
+	return entities
+}
+
+func overlapsEntity(entities []Entity, start, end int) bool {
+	i := sort.Search(len(entities), func(i int) bool { return entities[i].Start >= start })
+	if i > 0 && entities[i-1].End > start {
+		return true
+	}
+	return i < len(entities) && entities[i].Start < end
+}
+
+// Redaction spans for the personal data among a page's sorted entities
+func redactionSpans(entities []Entity) []RedactionSpan {
+	var spans []RedactionSpan
+	for _, entity := range entities {
+		if !entity.PII {
+			continue
+		}
+		if n := len(spans); n > 0 && spans[n-1].End == entity.Start {
+			spans[n-1].End = entity.End
+			if !containsString(spans[n-1].Types, entity.Type) {
+				spans[n-1].Types = append(spans[n-1].Types, entity.Type)
+			}
+			continue
+		}
+		spans = append(spans, RedactionSpan{Page: entity.Page, Start: entity.Start, End: entity.End, Types: []string{entity.Type}})
+	}
+	return spans
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask personal data so the report itself carries none, keeping the email
+// domain and the last four characters of numbers
+func maskEntity(entityType, value string) string {
+	if entityType == EntityEmail {
+		at := strings.LastIndexByte(value, '@')
+		return value[:1] + strings.Repeat("*", at-1) + value[at:]
+	}
+
+	keep := 4
+	masked := []rune(value)
+	for i := len(masked) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(masked[i]) && !unicode.IsDigit(masked[i]) {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		masked[i] = '*'
+	}
+	return string(masked)
+}
+
+// ==================== VALIDATION ====================
+
+// Account number lengths by country, IBANs from unlisted countries are
+// accepted on the checksum alone
+var ibanLengths = map[string]int{
+	"AT": 20, "BE": 16, "BG": 22, "CH": 21, "CY": 28, "CZ": 24, "DE": 22, "DK": 18,
+	"EE": 20, "ES": 24, "FI": 18, "FR": 27, "GB": 22, "GR": 27, "HR": 21, "HU": 28,
+	"IE": 22, "IS": 26, "IT": 27, "LI": 21, "LT": 20, "LU": 20, "LV": 21, "MC": 27,
+	"MT": 31, "NL": 18, "NO": 15, "PL": 28, "PT": 25, "RO": 24, "SE": 24, "SI": 19,
+	"SK": 24, "SM": 27, "TR": 26,
+}
+
+// IBANs are valid when the rearranged number is 1 mod 97; the match is cut
+// to the country's length when the pattern ran into the following text
+func checkIBAN(match string) int {
+	end := len(match)
+	if length, ok := ibanLengths[match[:2]]; ok {
+		chars := 0
+		end = -1
+		for i := 0; i < len(match); i++ {
+			if match[i] != ' ' {
+				chars++
+			}
+			if chars == length {
+				end = i + 1
+				break
+			}
+		}
+		// Cut inside a group means the account number is too short
+		if end < 0 || (end < len(match) && match[end] != ' ') {
+			return 0
+		}
+	}
+
+	compact := strings.ReplaceAll(match[:end], " ", "")
+	rearranged := compact[4:] + compact[:4]
+
+	var digits strings.Builder
+	for _, c := range rearranged {
+		if c >= 'A' && c <= 'Z' {
+			digits.WriteString(strconv.Itoa(int(c-'A') + 10))
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok || new(big.Int).Mod(n, big.NewInt(97)).Int64() != 1 {
+		return 0
+	}
+	return end
+}
+
+// Card numbers pass the Luhn check, a run of digits longer than any card
+// is tried at the usual card lengths where a separator allows a cut
+func checkCardNumber(match string) int {
+	var offsets []int
+	for i := 0; i < len(match); i++ {
+		if match[i] >= '0' && match[i] <= '9' {
+			offsets = append(offsets, i)
+		}
+	}
+
+	for _, length := range []int{16, 15, 19, 18, 17, 14, 13} {
+		if length > len(offsets) {
+			continue
+		}
+		end := offsets[length-1] + 1
+		if end < len(match) && match[end] != ' ' && match[end] != '-' {
+			continue
+		}
+
+		number := strings.NewReplacer(" ", "", "-", "").Replace(match[:end])
+		if luhnValid(number) && strings.Trim(number, number[:1]) != "" {
+			return end
+		}
+	}
+	return 0
+}
+
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// US social security numbers, area 000, 666 and 900+ are never issued
+func checkSSN(match string) int {
+	if match[3] != match[6] {
+		return 0
+	}
+	area, group, serial := match[0:3], match[4:6], match[7:11]
+	if area == "000" || area == "666" || area[0] == '9' || group == "00" || serial == "0000" {
+		return 0
+	}
+	return len(match)
+}
+
+// Numeric dates need a plausible day and month in either order
+func checkDate(match string) int {
+	if !unicode.IsDigit(rune(match[0])) || strings.ContainsAny(match, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		return len(match)
+	}
+
+	parts := strings.FieldsFunc(match, func(r rune) bool { return r == '-' || r == '/' || r == '.' })
+	if len(parts) != 3 {
+		return 0
+	}
+	a, _ := strconv.Atoi(parts[0])
+	b, _ := strconv.Atoi(parts[1])
+	c, _ := strconv.Atoi(parts[2])
+
+	// ISO year-month-day
+	if len(parts[0]) == 4 {
+		if b >= 1 && b <= 12 && c >= 1 && c <= 31 {
+			return len(match)
+		}
+		return 0
+	}
+	if a >= 1 && b >= 1 && a <= 31 && b <= 31 && (a <= 12 || b <= 12) {
+		return len(match)
+	}
+	return 0
+}
+
+// Phone numbers have 10 to 15 digits, or at least 8 with an international
+// prefix; a bare run of digits is more often a reference number unless it has 10
+func checkPhone(match string) int {
+	digits := 0
+	for i := 0; i < len(match); i++ {
+		if match[i] >= '0' && match[i] <= '9' {
+			digits++
+		}
+	}
+
+	minDigits := 10
+	if match[0] == '+' {
+		minDigits = 8
+	}
+	if digits < minDigits || digits > 15 || (digits == len(match) && digits != 10) {
+		return 0
+	}
+	return len(match)
+}
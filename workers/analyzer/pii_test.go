@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"79927398713", true},
+		{"79927398710", false},
+		{"4111111111111111", true},
+		{"4111111111111112", false},
+		{"378282246310005", true},
+		{"0", true},
+	}
+
+	for _, tt := range tests {
+		if got := luhnValid(tt.number); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCardNumber(t *testing.T) {
+	tests := []struct {
+		name  string
+		match string
+		want  int
+	}{
+		{"spaced visa", "4111 1111 1111 1111", 19},
+		{"dashed visa", "4111-1111-1111-1111", 19},
+		{"amex", "378282246310005", 15},
+		{"bad checksum", "4111 1111 1111 1112", 0},
+		{"repeated digit", "0000 0000 0000 0000", 0},
+		{"cut before the following group", "4111 1111 1111 1111 2222", 19},
+		{"no separator to cut at", "41111111111111112222", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkCardNumber(tt.match); got != tt.want {
+				t.Errorf("checkCardNumber(%q) = %d, want %d", tt.match, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIBAN(t *testing.T) {
+	tests := []struct {
+		name  string
+		match string
+		want  int
+	}{
+		{"german", "DE89 3704 0044 0532 0130 00", 27},
+		{"compact", "DE89370400440532013000", 22},
+		{"british", "GB82 WEST 1234 5698 7654 32", 27},
+		{"bad checksum", "DE89 3704 0044 0532 0130 01", 0},
+		{"cut at the country length", "DE89 3704 0044 0532 0130 00 1234", 27},
+		{"too short", "DE89 3704 0044 0532 0130", 0},
+		{"length ends inside a group", "DE89 3704 0044 0532 0130 0012", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkIBAN(tt.match); got != tt.want {
+				t.Errorf("checkIBAN(%q) = %d, want %d", tt.match, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSSN(t *testing.T) {
+	tests := []struct {
+		name  string
+		match string
+		want  int
+	}{
+		{"dashed", "123-45-6789", 11},
+		{"spaced", "123 45 6789", 11},
+		{"mixed separators", "123-45 6789", 0},
+		{"area 000", "000-45-6789", 0},
+		{"area 666", "666-45-6789", 0},
+		{"area 900 and up", "912-45-6789", 0},
+		{"group 00", "123-00-6789", 0},
+		{"serial 0000", "123-45-0000", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkSSN(tt.match); got != tt.want {
+				t.Errorf("checkSSN(%q) = %d, want %d", tt.match, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectPIIInPDF(t *testing.T) {
+	root := t.TempDir()
+	pa := newExtractingAnalyzer(t, root)
+
+	// Off unless the config or the request asks for it
+	if result := analyzeFixture(t, pa, "sample.pdf"); result.Entities != nil {
+		t.Fatalf("entities detected without being asked for: %+v", result.Entities)
+	}
+
+	input := DocumentInput{ID: "contract", FilePath: "sample.pdf", DetectPII: true, ExtractText: true}
+	result, err := pa.AnalyzeDocument(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := result.Entities
+	want := map[string]int{
+		EntityEmail: 1, EntityPhone: 1, EntityDate: 1, EntityCreditCard: 1,
+		EntityMoney: 1, EntityIBAN: 1, EntitySSN: 1,
+	}
+	if report == nil || !reflect.DeepEqual(report.Counts, want) || report.PIICount != 5 || len(report.Redactions) != 5 {
+		t.Fatalf("entities %+v, want counts %v", report, want)
+	}
+
+	// Offsets point into the page text as written to the artifact
+	page := readTextArtifact(t, filepath.Join(root, result.Extraction.Location))[0]
+	for _, span := range report.Redactions {
+		if got := page.Text[span.Start:span.End]; got == "" || strings.TrimSpace(got) != got {
+			t.Errorf("redaction %+v covers %q", span, got)
+		}
+	}
+	if email := page.Text[report.Redactions[0].Start:report.Redactions[0].End]; email != "jane.doe@example.com" {
+		t.Errorf("first redaction covers %q, want the email", email)
+	}
+
+	// The report itself carries no personal data
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{"jane.doe", "4111 1111 1111 1111", "123-45-6789"} {
+		if strings.Contains(string(data), raw) {
+			t.Errorf("report holds %q unmasked", raw)
+		}
+	}
+}
+
+func TestDetectEntities(t *testing.T) {
+	text := "Call +1 (555) 123-4567 or mail jane.doe@example.com by 12/03/2024, pay $1,250.00 to " +
+		"DE89 3704 0044 0532 0130 00, card 4111 1111 1111 1111, SSN 123-45-6789."
+
+	type found struct{ kind, value string }
+	var got []found
+	for _, entity := range detectEntities(2, text) {
+		if entity.Page != 2 || entity.PII != piiEntityTypes[entity.Type] {
+			t.Errorf("entity %+v", entity)
+		}
+		got = append(got, found{entity.Type, entity.Value})
+	}
+
+	// In text order, the digit groups of the IBAN and card are not phones too
+	want := []found{
+		{EntityPhone, "+* (***) ***-4567"},
+		{EntityEmail, "j*******@example.com"},
+		{EntityDate, "12/03/2024"},
+		{EntityMoney, "$1,250.00"},
+		{EntityIBAN, "**** **** **** **** **30 00"},
+		{EntityCreditCard, "**** **** **** 1111"},
+		{EntitySSN, "***-**-6789"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("detectEntities()\n got %v\nwant %v", got, want)
+	}
+
+	for _, text := range []string{"Invoice 20240312 of 31/31/2024", "ref 123456789, 4111 1111 1111 1112"} {
+		if entities := detectEntities(1, text); len(entities) != 0 {
+			t.Errorf("found %+v in %q", entities, text)
+		}
+	}
+}
+
+func TestRedactionSpans(t *testing.T) {
+	entities := detectEntities(1, "jane@example.com+44 20 7946 0958 on 2024-01-02, 123-45-6789")
+	spans := redactionSpans(entities)
+
+	// The email and the phone run into each other, the date is not redacted
+	want := []RedactionSpan{
+		{Page: 1, Start: 0, End: 32, Types: []string{EntityEmail, EntityPhone}},
+		{Page: 1, Start: 48, End: 59, Types: []string{EntitySSN}},
+	}
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("redactionSpans() = %+v, want %+v", spans, want)
+	}
+}
+
+func TestEntityReportLimits(t *testing.T) {
+	pa := newTestAnalyzer(t)
+	detector := pa.newEntityDetector(DocumentInput{DetectPII: true})
+
+	page := strings.Repeat("a@example.com ", maxReportedEntities/2+1)
+	detector.scanPage(1, page)
+	detector.scanPage(2, page)
+
+	// Counts keep going past what the report lists
+	report := detector.result()
+	total := maxReportedEntities + 2
+	if report.Counts[EntityEmail] != total || report.PIICount != total || len(report.Entities) != maxReportedEntities || !report.Truncated {
+		t.Errorf("counts %v, %d listed, truncated %v", report.Counts, len(report.Entities), report.Truncated)
+	}
+	if len(report.Redactions) != total {
+		t.Errorf("%d redactions, want %d", len(report.Redactions), total)
+	}
+
+	var off *entityDetector
+	off.scanPage(1, page)
+	if off.result() != nil {
+		t.Error("nil detector reported entities")
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Rates the analyzer used before pricing became configurable
+const defaultPricingVersion = "2024-default"
+
+// Versioned pricing used to estimate document processing cost
+type PricingConfig struct {
+	Version              string                   `json:"version"`
+	Currency             string                   `json:"currency"`
+	BaseCost             float64                  `json:"base_cost"`
+	PageTiers            []PageTier               `json:"page_tiers"`
+	CostPerMB            float64                  `json:"cost_per_mb"`
+	ImageHeavyMultiplier float64                  `json:"image_heavy_multiplier"`
+	TenantOverrides      map[string]TenantPricing `json:"tenant_overrides,omitempty"`
+}
+
+// Price per page for pages up to and including UpTo, 0 means no upper bound
+type PageTier struct {
+	UpTo         int     `json:"up_to"`
+	PricePerPage float64 `json:"price_per_page"`
+}
+
+// Per-tenant rates, unset fields fall back to the base config
+type TenantPricing struct {
+	BaseCost             *float64   `json:"base_cost,omitempty"`
+	PageTiers            []PageTier `json:"page_tiers,omitempty"`
+	CostPerMB            *float64   `json:"cost_per_mb,omitempty"`
+	ImageHeavyMultiplier *float64   `json:"image_heavy_multiplier,omitempty"`
+	Discount             float64    `json:"discount,omitempty"` // fraction, 0.1 = 10% off
+}
+
+// Itemized cost estimate, kept with the result so invoices can be audited
+type CostBreakdown struct {
+	PricingVersion string       `json:"pricing_version"`
+	TenantID       string       `json:"tenant_id,omitempty"`
+	TenantOverride bool         `json:"tenant_override"`
+	Currency       string       `json:"currency"`
+	BaseCost       float64      `json:"base_cost"`
+	PageCost       float64      `json:"page_cost"`
+	PageTiers      []TierCharge `json:"page_tiers"`
+	SizeCost       float64      `json:"size_cost"`
+	Subtotal       float64      `json:"subtotal"`
+	Multiplier     float64      `json:"multiplier"`
+	Discount       float64      `json:"discount"`
+	Total          float64      `json:"total"`
+}
+
+// Pages billed within a single tier
+type TierCharge struct {
+	FromPage     int     `json:"from_page"`
+	ToPage       int     `json:"to_page"`
+	Pages        int     `json:"pages"`
+	PricePerPage float64 `json:"price_per_page"`
+	Cost         float64 `json:"cost"`
+}
+
+// Flat rates matching the original hard-coded cost model
+func DefaultPricingConfig() *PricingConfig {
+	return &PricingConfig{
+		Version:  defaultPricingVersion,
+		Currency: "USD",
+		BaseCost: 0.10,
+		PageTiers: []PageTier{
+			{UpTo: 0, PricePerPage: 0.05},
+		},
+		CostPerMB:            0.02,
+		ImageHeavyMultiplier: 1.5,
+	}
+}
+
+// Load pricing from ANALYZER_PRICING_FILE or inline ANALYZER_PRICING_JSON,
+// falling back to the default rates
+func LoadPricingFromEnv() (*PricingConfig, error) {
+	if path := os.Getenv("ANALYZER_PRICING_FILE"); path != "" {
+		return LoadPricingFile(path)
+	}
+
+	if raw := os.Getenv("ANALYZER_PRICING_JSON"); raw != "" {
+		return ParsePricingConfig([]byte(raw))
+	}
+
+	return DefaultPricingConfig(), nil
+}
+
+func LoadPricingFile(path string) (*PricingConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	config, err := ParsePricingConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pricing file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse a pricing config. Unknown keys are errors, a mistyped rate must not
+// quietly fall back to zero.
+func ParsePricingConfig(data []byte) (*PricingConfig, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	config := &PricingConfig{}
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after pricing config")
+	}
+
+	if config.Currency == "" {
+		config.Currency = "USD"
+	}
+	if config.ImageHeavyMultiplier == 0 {
+		config.ImageHeavyMultiplier = 1
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Reject configs that would produce unauditable or negative prices
+func (pc *PricingConfig) Validate() error {
+	if pc.Version == "" {
+		return errors.New("pricing version is required")
+	}
+	if pc.BaseCost < 0 || pc.CostPerMB < 0 || pc.ImageHeavyMultiplier < 0 {
+		return errors.New("pricing rates must not be negative")
+	}
+	if err := validatePageTiers(pc.PageTiers); err != nil {
+		return err
+	}
+
+	for tenant, override := range pc.TenantOverrides {
+		if len(override.PageTiers) > 0 {
+			if err := validatePageTiers(override.PageTiers); err != nil {
+				return fmt.Errorf("tenant %s: %w", tenant, err)
+			}
+		}
+		if negativeRate(override.BaseCost) || negativeRate(override.CostPerMB) || negativeRate(override.ImageHeavyMultiplier) {
+			return fmt.Errorf("tenant %s: pricing rates must not be negative", tenant)
+		}
+		if override.Discount < 0 || override.Discount > 1 {
+			return fmt.Errorf("tenant %s: discount must be between 0 and 1", tenant)
+		}
+	}
+
+	return nil
+}
+
+// Tiers must be ascending and end with an unbounded tier
+func validatePageTiers(tiers []PageTier) error {
+	if len(tiers) == 0 {
+		return errors.New("at least one page tier is required")
+	}
+
+	previous := 0
+	for i, tier := range tiers {
+		if tier.PricePerPage < 0 {
+			return fmt.Errorf("page tier %d has a negative price", i)
+		}
+
+		last := i == len(tiers)-1
+		if tier.UpTo == 0 && !last {
+			return fmt.Errorf("page tier %d is unbounded but is not the last tier", i)
+		}
+		if last && tier.UpTo != 0 {
+			return errors.New("last page tier must be unbounded (up_to 0)")
+		}
+		if !last && tier.UpTo <= previous {
+			return fmt.Errorf("page tier %d must end after page %d", i, previous)
+		}
+		previous = tier.UpTo
+	}
+
+	return nil
+}
+
+// Unset override rates fall back to the base config, only set ones are checked
+func negativeRate(rate *float64) bool {
+	return rate != nil && *rate < 0
+}
+
+// Estimate the cost of a document for a tenant
+func (pc *PricingConfig) Estimate(tenantID string, pageCount int, fileSize int64, isTextBased bool) CostBreakdown {
+	baseCost := pc.BaseCost
+	tiers := pc.PageTiers
+	costPerMB := pc.CostPerMB
+	imageHeavyMultiplier := pc.ImageHeavyMultiplier
+	discount := 0.0
+
+	override, hasOverride := pc.TenantOverrides[tenantID]
+	if hasOverride {
+		if override.BaseCost != nil {
+			baseCost = *override.BaseCost
+		}
+		if len(override.PageTiers) > 0 {
+			tiers = override.PageTiers
+		}
+		if override.CostPerMB != nil {
+			costPerMB = *override.CostPerMB
+		}
+		if override.ImageHeavyMultiplier != nil {
+			imageHeavyMultiplier = *override.ImageHeavyMultiplier
+		}
+		discount = override.Discount
+	}
+
+	tierCharges, pageCost := chargePageTiers(tiers, pageCount)
+	sizeCost := float64(fileSize) / (1024 * 1024) * costPerMB
+
+	// Image heavy documents need OCR so they cost more
+	multiplier := 1.0
+	if !isTextBased {
+		multiplier = imageHeavyMultiplier
+	}
+
+	subtotal := baseCost + pageCost + sizeCost
+	total := subtotal * multiplier * (1 - discount)
+
+	return CostBreakdown{
+		PricingVersion: pc.Version,
+		TenantID:       tenantID,
+		TenantOverride: hasOverride,
+		Currency:       pc.Currency,
+		BaseCost:       baseCost,
+		PageCost:       pageCost,
+		PageTiers:      tierCharges,
+		SizeCost:       sizeCost,
+		Subtotal:       subtotal,
+		Multiplier:     multiplier,
+		Discount:       discount,
+		Total:          total,
+	}
+}
+
+// Split pages across tiers, pricing each slice at its tier rate
+func chargePageTiers(tiers []PageTier, pageCount int) ([]TierCharge, float64) {
+	charges := []TierCharge{}
+	total := 0.0
+	from := 1
+
+	for _, tier := range tiers {
+		if from > pageCount {
+			break
+		}
+
+		to := pageCount
+		if tier.UpTo != 0 && tier.UpTo < pageCount {
+			to = tier.UpTo
+		}
+		if to < from {
+			continue
+		}
+
+		pages := to - from + 1
+		cost := float64(pages) * tier.PricePerPage
+		charges = append(charges, TierCharge{
+			FromPage:     from,
+			ToPage:       to,
+			Pages:        pages,
+			PricePerPage: tier.PricePerPage,
+			Cost:         cost,
+		})
+
+		total += cost
+		from = to + 1
+	}
+
+	return charges, total
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestChargePageTiers(t *testing.T) {
+	tiers := []PageTier{
+		{UpTo: 10, PricePerPage: 0.10},
+		{UpTo: 100, PricePerPage: 0.05},
+		{UpTo: 0, PricePerPage: 0.01},
+	}
+
+	tests := []struct {
+		name    string
+		pages   int
+		charges []TierCharge
+		total   float64
+	}{
+		{"no pages", 0, []TierCharge{}, 0},
+		{"first tier", 4, []TierCharge{
+			{FromPage: 1, ToPage: 4, Pages: 4, PricePerPage: 0.10, Cost: 0.40},
+		}, 0.40},
+		{"tier boundary", 10, []TierCharge{
+			{FromPage: 1, ToPage: 10, Pages: 10, PricePerPage: 0.10, Cost: 1.00},
+		}, 1.00},
+		{"one past the boundary", 11, []TierCharge{
+			{FromPage: 1, ToPage: 10, Pages: 10, PricePerPage: 0.10, Cost: 1.00},
+			{FromPage: 11, ToPage: 11, Pages: 1, PricePerPage: 0.05, Cost: 0.05},
+		}, 1.05},
+		{"unbounded tier", 150, []TierCharge{
+			{FromPage: 1, ToPage: 10, Pages: 10, PricePerPage: 0.10, Cost: 1.00},
+			{FromPage: 11, ToPage: 100, Pages: 90, PricePerPage: 0.05, Cost: 4.50},
+			{FromPage: 101, ToPage: 150, Pages: 50, PricePerPage: 0.01, Cost: 0.50},
+		}, 6.00},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charges, total := chargePageTiers(tiers, tt.pages)
+			if len(charges) != len(tt.charges) {
+				t.Fatalf("got %d tier charges, want %d: %+v", len(charges), len(tt.charges), charges)
+			}
+			for i, charge := range charges {
+				want := tt.charges[i]
+				if charge.FromPage != want.FromPage || charge.ToPage != want.ToPage || charge.Pages != want.Pages ||
+					charge.PricePerPage != want.PricePerPage || !almostEqual(charge.Cost, want.Cost) {
+					t.Errorf("tier %d = %+v, want %+v", i, charge, want)
+				}
+			}
+			if !almostEqual(total, tt.total) {
+				t.Errorf("total = %v, want %v", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestPricingEstimate(t *testing.T) {
+	config, err := LoadPricingFile("pricing.example.json")
+	if err != nil {
+		t.Fatalf("pricing.example.json: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		tenant      string
+		pages       int
+		fileSize    int64
+		textBased   bool
+		override    bool
+		multiplier  float64
+		total       float64
+		tierCharges int
+	}{
+		// 0.10 base + 10 * 0.05 pages + 1MB * 0.02
+		{"text document", "", 10, 1024 * 1024, true, false, 1, 0.62, 1},
+		// 0.10 + 50 * 0.05 + 450 * 0.03 + 100 * 0.02
+		{"spans every tier", "", 600, 0, true, false, 1, 18.10, 3},
+		{"image heavy", "", 10, 0, false, false, 1.5, 0.90, 1},
+		// acme has no base cost and 15% off
+		{"tenant override", "acme", 10, 0, true, true, 1, 0.425, 1},
+		{"unknown tenant", "other", 10, 0, true, false, 1, 0.60, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate := config.Estimate(tt.tenant, tt.pages, tt.fileSize, tt.textBased)
+			if estimate.TenantOverride != tt.override {
+				t.Errorf("TenantOverride = %v, want %v", estimate.TenantOverride, tt.override)
+			}
+			if estimate.Multiplier != tt.multiplier {
+				t.Errorf("Multiplier = %v, want %v", estimate.Multiplier, tt.multiplier)
+			}
+			if len(estimate.PageTiers) != tt.tierCharges {
+				t.Errorf("got %d tier charges, want %d", len(estimate.PageTiers), tt.tierCharges)
+			}
+			if !almostEqual(estimate.Total, tt.total) {
+				t.Errorf("Total = %v, want %v", estimate.Total, tt.total)
+			}
+			if estimate.PricingVersion != "2025-Q1" || estimate.Currency != "USD" {
+				t.Errorf("priced as %s %s, want 2025-Q1 USD", estimate.PricingVersion, estimate.Currency)
+			}
+		})
+	}
+}
+
+func TestParsePricingConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"minimal", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}]}`, false},
+		{"missing version", `{"page_tiers":[{"up_to":0,"price_per_page":0.01}]}`, true},
+		{"no tiers", `{"version":"v1"}`, true},
+		{"bounded last tier", `{"version":"v1","page_tiers":[{"up_to":10,"price_per_page":0.01}]}`, true},
+		{"unbounded tier first", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.02},{"up_to":0,"price_per_page":0.01}]}`, true},
+		{"descending tiers", `{"version":"v1","page_tiers":[{"up_to":20,"price_per_page":0.02},{"up_to":10,"price_per_page":0.02},{"up_to":0,"price_per_page":0.01}]}`, true},
+		{"negative rate", `{"version":"v1","cost_per_mb":-1,"page_tiers":[{"up_to":0,"price_per_page":0.01}]}`, true},
+		{"discount over one", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}],"tenant_overrides":{"acme":{"discount":1.5}}}`, true},
+		{"free tenant", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}],"tenant_overrides":{"acme":{"base_cost":0,"cost_per_mb":0}}}`, false},
+		{"negative tenant base cost", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}],"tenant_overrides":{"acme":{"base_cost":-5}}}`, true},
+		{"negative tenant size rate", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}],"tenant_overrides":{"acme":{"cost_per_mb":-0.01}}}`, true},
+		{"negative tenant multiplier", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}],"tenant_overrides":{"acme":{"image_heavy_multiplier":-1}}}`, true},
+		{"negative tenant tier", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}],"tenant_overrides":{"acme":{"page_tiers":[{"up_to":0,"price_per_page":-0.01}]}}}`, true},
+		{"unknown key", `{"version":"v1","cost_per_page":0.05,"page_tiers":[{"up_to":0,"price_per_page":0.01}]}`, true},
+		{"trailing data", `{"version":"v1","page_tiers":[{"up_to":0,"price_per_page":0.01}]} {}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParsePricingConfig([]byte(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePricingConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (config.Currency != "USD" || config.ImageHeavyMultiplier != 1) {
+				t.Errorf("defaults not applied: currency %q, multiplier %v", config.Currency, config.ImageHeavyMultiplier)
+			}
+		})
+	}
+}
+
+func TestDefaultPricingMatchesFlatRates(t *testing.T) {
+	estimate := DefaultPricingConfig().Estimate("", 20, 2*1024*1024, false)
+	want := []TierCharge{{FromPage: 1, ToPage: 20, Pages: 20, PricePerPage: 0.05, Cost: 1}}
+	if !reflect.DeepEqual(estimate.PageTiers, want) {
+		t.Errorf("PageTiers = %+v, want %+v", estimate.PageTiers, want)
+	}
+	// (0.10 + 20 * 0.05 + 2 * 0.02) * 1.5
+	if !almostEqual(estimate.Total, 1.71) {
+		t.Errorf("Total = %v, want 1.71", estimate.Total)
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
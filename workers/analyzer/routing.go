@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Processing strategies a routing policy can pick
+const (
+	StrategySimple          = "simple"
+	StrategyParallel        = "parallel"
+	StrategyParallelChunked = "parallel-chunked"
+	StrategyOCR             = "ocr"
+	StrategyReject          = "reject"
+	StrategyManualReview    = "manual-review"
+	StrategyQuarantine      = "quarantine"
+)
+
+var knownStrategies = map[string]bool{
+	StrategySimple:          true,
+	StrategyParallel:        true,
+	StrategyParallelChunked: true,
+	StrategyOCR:             true,
+	StrategyReject:          true,
+	StrategyManualReview:    true,
+	StrategyQuarantine:      true,
+}
+
+const defaultRoutingVersion = "2024-default"
+
+// Ordered rules deciding how a document is processed, first match wins
+type RoutingPolicy struct {
+	Version         string        `json:"version"`
+	DefaultStrategy string        `json:"default_strategy"`
+	Rules           []RoutingRule `json:"rules"`
+}
+
+type RoutingRule struct {
+	Name     string         `json:"name"`
+	Strategy string         `json:"strategy"`
+	When     RuleConditions `json:"when"`
+}
+
+// Every set condition must hold for a rule to match, unset ones are ignored
+type RuleConditions struct {
+	MinPages         *int     `json:"min_pages,omitempty"`
+	MaxPages         *int     `json:"max_pages,omitempty"`
+	MinFileSize      *int64   `json:"min_file_size,omitempty"`
+	MaxFileSize      *int64   `json:"max_file_size,omitempty"`
+	MinTextDensity   *float64 `json:"min_text_density,omitempty"`
+	MaxTextDensity   *float64 `json:"max_text_density,omitempty"`
+	TextBased        *bool    `json:"text_based,omitempty"`
+	Encrypted        *bool    `json:"encrypted,omitempty"`
+	Languages        []string `json:"languages,omitempty"`
+	ExcludeLanguages []string `json:"exclude_languages,omitempty"`
+	MinRiskScore     *int     `json:"min_risk_score,omitempty"`
+	Partial          *bool    `json:"partial,omitempty"`
+}
+
+// Document facts a policy routes on
+type RoutingFacts struct {
+	PageCount   int
+	FileSize    int64
+	TextDensity float64
+	IsTextBased bool
+	Encrypted   bool
+	Language    string // primary language code, empty when unknown
+	RiskScore   int    // security scan score, 0 when the format is not scanned
+	Partial     bool   // a deadline cut the analysis short
+}
+
+// Strategy picked for a document and the rule that picked it
+type RoutingDecision struct {
+	Strategy      string `json:"strategy"`
+	Rule          string `json:"rule"`
+	PolicyVersion string `json:"policy_version"`
+}
+
+func intPtr(v int) *int       { return &v }
+func int64Ptr(v int64) *int64 { return &v }
+func boolPtr(v bool) *bool    { return &v }
+
+// Built-in policy, keeps the original 10 page parallel threshold
+func DefaultRoutingPolicy() *RoutingPolicy {
+	return &RoutingPolicy{
+		Version:         defaultRoutingVersion,
+		DefaultStrategy: StrategySimple,
+		Rules: []RoutingRule{
+			{
+				Name:     "risky-content-quarantine",
+				Strategy: StrategyQuarantine,
+				When:     RuleConditions{MinRiskScore: intPtr(50)},
+			},
+			{
+				Name:     "partial-analysis",
+				Strategy: StrategyManualReview,
+				When:     RuleConditions{Partial: boolPtr(true)},
+			},
+			{
+				Name:     "encrypted-needs-review",
+				Strategy: StrategyManualReview,
+				When:     RuleConditions{Encrypted: boolPtr(true)},
+			},
+			{
+				Name:     "oversized-file",
+				Strategy: StrategyReject,
+				When:     RuleConditions{MinFileSize: int64Ptr(200 * 1024 * 1024)},
+			},
+			{
+				Name:     "very-large-document",
+				Strategy: StrategyParallelChunked,
+				When:     RuleConditions{MinPages: intPtr(100)},
+			},
+			{
+				Name:     "large-document",
+				Strategy: StrategyParallel,
+				When:     RuleConditions{MinPages: intPtr(10)},
+			},
+			{
+				Name:     "image-based-document",
+				Strategy: StrategyOCR,
+				When:     RuleConditions{TextBased: boolPtr(false)},
+			},
+		},
+	}
+}
+
+// Load routing from ANALYZER_ROUTING_FILE or inline ANALYZER_ROUTING_JSON,
+// falling back to the default policy
+func LoadRoutingFromEnv() (*RoutingPolicy, error) {
+	if path := os.Getenv("ANALYZER_ROUTING_FILE"); path != "" {
+		return LoadRoutingFile(path)
+	}
+
+	if raw := os.Getenv("ANALYZER_ROUTING_JSON"); raw != "" {
+		return ParseRoutingPolicy([]byte(raw))
+	}
+
+	return DefaultRoutingPolicy(), nil
+}
+
+func LoadRoutingFile(path string) (*RoutingPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing file: %w", err)
+	}
+
+	policy, err := ParseRoutingPolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing file %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Parse a routing policy. Unknown keys are errors, a mistyped condition would
+// leave a rule matching every document.
+func ParseRoutingPolicy(data []byte) (*RoutingPolicy, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	policy := &RoutingPolicy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after routing policy")
+	}
+
+	if policy.DefaultStrategy == "" {
+		policy.DefaultStrategy = StrategySimple
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (rp *RoutingPolicy) Validate() error {
+	if rp.Version == "" {
+		return errors.New("routing version is required")
+	}
+	if !knownStrategies[rp.DefaultStrategy] {
+		return fmt.Errorf("unknown default strategy %q", rp.DefaultStrategy)
+	}
+
+	for i, rule := range rp.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("routing rule %d has no name", i)
+		}
+		if !knownStrategies[rule.Strategy] {
+			return fmt.Errorf("routing rule %s has unknown strategy %q", rule.Name, rule.Strategy)
+		}
+		if rule.When.empty() {
+			return fmt.Errorf("routing rule %s has no conditions, use default_strategy to route every document", rule.Name)
+		}
+	}
+
+	return nil
+}
+
+// Fewest pages a document needs to be routed to a parallel strategy, zero when
+// that cannot be told from the page count alone. Documents below it never fan
+// out, so only those at or above it leave extraction to chunk workflows.
+func (rp *RoutingPolicy) fanOutMinPages() int {
+	if isParallelStrategy(rp.DefaultStrategy) {
+		return 0
+	}
+
+	minPages := 0
+	for _, rule := range rp.Rules {
+		if !isParallelStrategy(rule.Strategy) {
+			continue
+		}
+		if rule.When.MinPages == nil || *rule.When.MinPages < 1 {
+			return 0
+		}
+		if minPages == 0 || *rule.When.MinPages < minPages {
+			minPages = *rule.When.MinPages
+		}
+	}
+	return minPages
+}
+
+// Pick a strategy for the document, first matching rule wins
+func (rp *RoutingPolicy) Route(facts RoutingFacts) RoutingDecision {
+	for _, rule := range rp.Rules {
+		if rule.When.Matches(facts) {
+			return RoutingDecision{
+				Strategy:      rule.Strategy,
+				Rule:          rule.Name,
+				PolicyVersion: rp.Version,
+			}
+		}
+	}
+
+	return RoutingDecision{
+		Strategy:      rp.DefaultStrategy,
+		Rule:          "default",
+		PolicyVersion: rp.Version,
+	}
+}
+
+// A rule without conditions matches every document
+func (rc RuleConditions) empty() bool {
+	return rc.MinPages == nil && rc.MaxPages == nil &&
+		rc.MinFileSize == nil && rc.MaxFileSize == nil &&
+		rc.MinTextDensity == nil && rc.MaxTextDensity == nil &&
+		rc.TextBased == nil && rc.Encrypted == nil &&
+		rc.MinRiskScore == nil && rc.Partial == nil &&
+		len(rc.Languages) == 0 && len(rc.ExcludeLanguages) == 0
+}
+
+func (rc RuleConditions) Matches(facts RoutingFacts) bool {
+	if rc.MinPages != nil && facts.PageCount < *rc.MinPages {
+		return false
+	}
+	if rc.MaxPages != nil && facts.PageCount > *rc.MaxPages {
+		return false
+	}
+	if rc.MinFileSize != nil && facts.FileSize < *rc.MinFileSize {
+		return false
+	}
+	if rc.MaxFileSize != nil && facts.FileSize > *rc.MaxFileSize {
+		return false
+	}
+	if rc.MinTextDensity != nil && facts.TextDensity < *rc.MinTextDensity {
+		return false
+	}
+	if rc.MaxTextDensity != nil && facts.TextDensity > *rc.MaxTextDensity {
+		return false
+	}
+	if rc.TextBased != nil && facts.IsTextBased != *rc.TextBased {
+		return false
+	}
+	if rc.Encrypted != nil && facts.Encrypted != *rc.Encrypted {
+		return false
+	}
+	if rc.MinRiskScore != nil && facts.RiskScore < *rc.MinRiskScore {
+		return false
+	}
+	if rc.Partial != nil && facts.Partial != *rc.Partial {
+		return false
+	}
+
+	// Language conditions only apply once a language has been detected
+	if len(rc.Languages) > 0 && !containsLanguage(rc.Languages, facts.Language) {
+		return false
+	}
+	if len(rc.ExcludeLanguages) > 0 && (facts.Language == "" || containsLanguage(rc.ExcludeLanguages, facts.Language)) {
+		return false
+	}
+
+	return true
+}
+
+// Risk score to route on, documents without a security scan score zero
+func riskScore(report *SecurityReport) int {
+	if report == nil {
+		return 0
+	}
+	return report.RiskScore
+}
+
+// Primary language to route on, empty unless detection is confident
+func routingLanguage(report *LanguageReport) string {
+	if report == nil || report.PrimaryLanguage == languageUndetermined || report.Confidence < minLanguageConfidence {
+		return ""
+	}
+	return report.PrimaryLanguage
+}
+
+func containsLanguage(languages []string, language string) bool {
+	for _, l := range languages {
+		if strings.EqualFold(l, language) {
+			return true
+		}
+	}
+	return false
+}
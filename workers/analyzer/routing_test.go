@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDefaultRoutingPolicyRoute(t *testing.T) {
+	policy := DefaultRoutingPolicy()
+
+	tests := []struct {
+		name     string
+		facts    RoutingFacts
+		strategy string
+		rule     string
+	}{
+		{"small text document", RoutingFacts{PageCount: 3, IsTextBased: true}, StrategySimple, "default"},
+		{"ten pages", RoutingFacts{PageCount: 10, IsTextBased: true}, StrategyParallel, "large-document"},
+		{"hundred pages", RoutingFacts{PageCount: 100, IsTextBased: true}, StrategyParallelChunked, "very-large-document"},
+		{"scanned", RoutingFacts{PageCount: 2}, StrategyOCR, "image-based-document"},
+		{"encrypted", RoutingFacts{PageCount: 2, IsTextBased: true, Encrypted: true}, StrategyManualReview, "encrypted-needs-review"},
+		{"partial", RoutingFacts{PageCount: 200, Partial: true}, StrategyManualReview, "partial-analysis"},
+		{"oversized", RoutingFacts{PageCount: 1, IsTextBased: true, FileSize: 200 * 1024 * 1024}, StrategyReject, "oversized-file"},
+		{"risky wins over everything", RoutingFacts{PageCount: 500, Encrypted: true, RiskScore: 50}, StrategyQuarantine, "risky-content-quarantine"},
+		{"risk below threshold", RoutingFacts{PageCount: 1, IsTextBased: true, RiskScore: 49}, StrategySimple, "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := policy.Route(tt.facts)
+			if decision.Strategy != tt.strategy || decision.Rule != tt.rule {
+				t.Errorf("Route() = %s by %s, want %s by %s", decision.Strategy, decision.Rule, tt.strategy, tt.rule)
+			}
+			if decision.PolicyVersion != defaultRoutingVersion {
+				t.Errorf("PolicyVersion = %q, want %q", decision.PolicyVersion, defaultRoutingVersion)
+			}
+		})
+	}
+}
+
+func TestRuleConditionsMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		when  RuleConditions
+		facts RoutingFacts
+		want  bool
+	}{
+		{"no conditions", RuleConditions{}, RoutingFacts{}, true},
+		{"page range inside", RuleConditions{MinPages: intPtr(5), MaxPages: intPtr(10)}, RoutingFacts{PageCount: 10}, true},
+		{"page range above", RuleConditions{MinPages: intPtr(5), MaxPages: intPtr(10)}, RoutingFacts{PageCount: 11}, false},
+		{"max file size", RuleConditions{MaxFileSize: int64Ptr(1024)}, RoutingFacts{FileSize: 1025}, false},
+		{"text density", RuleConditions{MaxTextDensity: floatPtr(100)}, RoutingFacts{TextDensity: 99.5}, true},
+		{"all conditions must hold", RuleConditions{MinPages: intPtr(10), TextBased: boolPtr(false)}, RoutingFacts{PageCount: 20, IsTextBased: true}, false},
+		{"language listed", RuleConditions{Languages: []string{"de", "fr"}}, RoutingFacts{Language: "FR"}, true},
+		{"language not listed", RuleConditions{Languages: []string{"de"}}, RoutingFacts{Language: "en"}, false},
+		{"language unknown", RuleConditions{Languages: []string{"de"}}, RoutingFacts{}, false},
+		{"excluded language", RuleConditions{ExcludeLanguages: []string{"en"}}, RoutingFacts{Language: "en"}, false},
+		{"other language", RuleConditions{ExcludeLanguages: []string{"en"}}, RoutingFacts{Language: "de"}, true},
+		{"exclusion needs a detected language", RuleConditions{ExcludeLanguages: []string{"en"}}, RoutingFacts{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.when.Matches(tt.facts); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRoutingPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"valid", `{"version":"v1","rules":[{"name":"big","strategy":"parallel","when":{"min_pages":10}}]}`, false},
+		{"missing version", `{"rules":[]}`, true},
+		{"unknown strategy", `{"version":"v1","rules":[{"name":"big","strategy":"fast","when":{"min_pages":10}}]}`, true},
+		{"unnamed rule", `{"version":"v1","rules":[{"strategy":"ocr","when":{"text_based":false}}]}`, true},
+		{"unknown default", `{"version":"v1","default_strategy":"fast"}`, true},
+		{"malformed", `{"version":`, true},
+		{"mistyped condition", `{"version":"v1","rules":[{"name":"huge","strategy":"reject","when":{"min_page":1000}}]}`, true},
+		{"unknown key", `{"version":"v1","default":"ocr"}`, true},
+		{"rule without conditions", `{"version":"v1","rules":[{"name":"huge","strategy":"reject","when":{}}]}`, true},
+		{"rule without when", `{"version":"v1","rules":[{"name":"huge","strategy":"reject"}]}`, true},
+		{"trailing data", `{"version":"v1"} {"version":"v2"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseRoutingPolicy([]byte(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRoutingPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && policy.DefaultStrategy != StrategySimple {
+				t.Errorf("DefaultStrategy = %q, want %q", policy.DefaultStrategy, StrategySimple)
+			}
+		})
+	}
+
+	if _, err := LoadRoutingFile("routing.example.json"); err != nil {
+		t.Errorf("routing.example.json: %v", err)
+	}
+}
+
+func TestFanOutMinPages(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *RoutingPolicy
+		want   int
+	}{
+		{"default policy", DefaultRoutingPolicy(), 10},
+		{"no parallel rules", &RoutingPolicy{DefaultStrategy: StrategySimple}, 0},
+		{"parallel default", &RoutingPolicy{DefaultStrategy: StrategyParallel}, 0},
+		{"parallel rule without a page bound", &RoutingPolicy{DefaultStrategy: StrategySimple, Rules: []RoutingRule{
+			{Name: "big", Strategy: StrategyParallel, When: RuleConditions{MinPages: intPtr(20)}},
+			{Name: "scanned", Strategy: StrategyParallelChunked, When: RuleConditions{TextBased: boolPtr(false)}},
+		}}, 0},
+		{"smallest bound", &RoutingPolicy{DefaultStrategy: StrategySimple, Rules: []RoutingRule{
+			{Name: "huge", Strategy: StrategyParallelChunked, When: RuleConditions{MinPages: intPtr(100)}},
+			{Name: "big", Strategy: StrategyParallel, When: RuleConditions{MinPages: intPtr(20)}},
+		}}, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.fanOutMinPages(); got != tt.want {
+				t.Errorf("fanOutMinPages() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func floatPtr(v float64) *float64 { return &v }
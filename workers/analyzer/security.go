@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Security finding codes
+const (
+	SecurityJavaScript       = "javascript"
+	SecurityLaunchAction     = "launch_action"
+	SecurityAutoRun          = "auto_run"
+	SecurityEmbeddedFile     = "embedded_file"
+	SecurityExecutableFile   = "executable_attachment"
+	SecurityExternalURI      = "external_uri"
+	SecurityRemoteAction     = "remote_action"
+	SecurityXFAForm          = "xfa_form"
+	SecurityRichMedia        = "rich_media"
+	SecurityObfuscatedName   = "obfuscated_name"
+	SecuritySuspiciousObjStm = "suspicious_object_stream"
+)
+
+// Risk levels derived from the score
+const (
+	RiskNone   = "none"
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// Score added by each finding, a finding counts once however often it occurs
+var securityWeights = map[string]int{
+	SecurityJavaScript:       40,
+	SecurityLaunchAction:     50,
+	SecurityAutoRun:          15,
+	SecurityEmbeddedFile:     15,
+	SecurityExecutableFile:   40,
+	SecurityExternalURI:      5,
+	SecurityRemoteAction:     10,
+	SecurityXFAForm:          15,
+	SecurityRichMedia:        20,
+	SecurityObfuscatedName:   30,
+	SecuritySuspiciousObjStm: 20,
+}
+
+// Attachments that run when opened
+var executableExtensions = map[string]bool{
+	".exe": true, ".dll": true, ".scr": true, ".com": true, ".bat": true, ".cmd": true,
+	".ps1": true, ".vbs": true, ".vbe": true, ".js": true, ".jse": true, ".wsf": true,
+	".hta": true, ".msi": true, ".jar": true, ".lnk": true, ".sh": true, ".app": true,
+	".docm": true, ".xlsm": true, ".pptm": true,
+}
+
+// Actions that reach outside the document for something other than a link
+var remoteActions = map[string]bool{
+	"GoToR": true, "GoToE": true, "SubmitForm": true, "ImportData": true,
+}
+
+// Annotations that embed players or 3D content
+var richMediaAnnotations = map[string]bool{
+	"RichMedia": true, "Movie": true, "Sound": true, "Screen": true, "3D": true,
+}
+
+// Objects listed per finding, the count keeps the full total
+const maxFindingObjects = 5
+
+// Result of the active content scan, routing quarantines on the risk score
+type SecurityReport struct {
+	RiskScore int               `json:"risk_score"`
+	RiskLevel string            `json:"risk_level"`
+	Findings  []SecurityFinding `json:"findings"`
+
+	JavaScriptCount   int `json:"javascript_count"`
+	LaunchActionCount int `json:"launch_action_count"`
+	EmbeddedFileCount int `json:"embedded_file_count"`
+	ExternalURICount  int `json:"external_uri_count"`
+	ObjectStreamCount int `json:"object_stream_count"`
+}
+
+// One reason for the risk score
+type SecurityFinding struct {
+	Code     string   `json:"code"`
+	Severity string   `json:"severity"`
+	Score    int      `json:"score"`
+	Count    int      `json:"count"`
+	Message  string   `json:"message"`
+	Page     int      `json:"page,omitempty"`
+	Objects  []string `json:"objects,omitempty"`
+}
+
+// Embedded files and interactive content found while walking the document
+type securityScanner struct {
+	files     []embeddedFile
+	richMedia []richMediaAnnotation
+	xfa       bool
+}
+
+type embeddedFile struct {
+	name string
+	page int
+}
+
+type richMediaAnnotation struct {
+	subtype string
+	page    int
+}
+
+// Document attachments and XFA forms
+func (ss *securityScanner) addCatalog(catalog pdf.Value) {
+	for _, spec := range nameTreeValues(catalog.Key("Names").Key("EmbeddedFiles")) {
+		ss.files = append(ss.files, embeddedFile{name: fileSpecName(spec)})
+	}
+	ss.xfa = !catalog.Key("AcroForm").Key("XFA").IsNull()
+}
+
+// File attachment and rich media annotations on a page
+func (ss *securityScanner) addPage(page pdf.Page, pageNumber int) {
+	annots := page.V.Key("Annots")
+	for i := 0; i < annots.Len() && len(ss.files)+len(ss.richMedia) < maxActions; i++ {
+		annot := annots.Index(i)
+		subtype := annot.Key("Subtype").Name()
+		switch {
+		case subtype == "FileAttachment":
+			ss.files = append(ss.files, embeddedFile{name: fileSpecName(annot.Key("FS")), page: pageNumber})
+		case richMediaAnnotations[subtype]:
+			ss.richMedia = append(ss.richMedia, richMediaAnnotation{subtype: subtype, page: pageNumber})
+		}
+	}
+}
+
+// Scan the document for active content, combining the actions found while
+// walking the pages with attachments, interactive annotations and a raw byte
+// scan. Returns the context error when ctx ends first, a partial scan is not scored.
+func scanSecurity(ctx context.Context, file io.ReaderAt, fileSize int64, reader *pdf.Reader, stats *contentStats) (*SecurityReport, error) {
+	scanner := &securityScanner{}
+	if err := runPage(ctx, 0, func() { scanner.addCatalog(reader.Trailer().Key("Root")) }); err != nil {
+		return nil, err
+	}
+	for i := 1; i <= stats.PageCount; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if page := reader.Page(i); !page.V.IsNull() {
+			scanner.addPage(page, i)
+		}
+	}
+
+	raw, err := scanRawPDF(ctx, file, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	return assessSecurity(scanner, stats.Actions, raw, stats.Metadata.PDFVersion), nil
+}
+
+// Score the structure found by the parser together with the raw file scan
+func assessSecurity(scanner *securityScanner, actions []DocumentAction, raw rawSecurityScan, pdfVersion string) *SecurityReport {
+	report := &SecurityReport{Findings: []SecurityFinding{}, ObjectStreamCount: raw.keywords["ObjStm"]}
+	findings := make(map[string]*SecurityFinding)
+	add := func(code, message string, page int, object string) {
+		finding, ok := findings[code]
+		if !ok {
+			finding = &SecurityFinding{Code: code, Score: securityWeights[code], Message: message, Page: page}
+			findings[code] = finding
+		}
+		finding.Count++
+		if object != "" && len(finding.Objects) < maxFindingObjects {
+			finding.Objects = append(finding.Objects, object)
+		}
+	}
+
+	for _, action := range actions {
+		object := action.Trigger
+		if action.Target != "" {
+			object = action.Target
+		}
+
+		switch {
+		case action.Type == "JavaScript":
+			report.JavaScriptCount++
+			add(SecurityJavaScript, "document contains JavaScript", action.Page, action.Trigger)
+		case action.Type == "Launch":
+			report.LaunchActionCount++
+			add(SecurityLaunchAction, "document launches external applications", action.Page, object)
+		case action.Type == "URI":
+			report.ExternalURICount++
+			add(SecurityExternalURI, "document links to external URIs", action.Page, object)
+		case remoteActions[action.Type]:
+			add(SecurityRemoteAction, "document opens, submits to or imports from other files", action.Page, action.Type+": "+object)
+		default:
+			continue
+		}
+
+		// Anything but a link that runs without a click
+		if action.Type != "URI" && isAutomaticTrigger(action.Trigger) {
+			add(SecurityAutoRun, "active content runs automatically on open or page events", action.Page, action.Type+" on "+action.Trigger)
+		}
+	}
+
+	for _, file := range scanner.files {
+		report.EmbeddedFileCount++
+		add(SecurityEmbeddedFile, "document carries embedded files", file.page, file.name)
+		if executableExtensions[strings.ToLower(path.Ext(file.name))] {
+			add(SecurityExecutableFile, "embedded file is an executable or script", file.page, file.name)
+		}
+	}
+
+	if scanner.xfa {
+		add(SecurityXFAForm, "document contains an XFA form, which can carry scripts", 0, "AcroForm/XFA")
+	}
+	for _, media := range scanner.richMedia {
+		add(SecurityRichMedia, "document embeds media or 3D players", media.page, "annotation/"+media.subtype)
+	}
+
+	// Hex escaped names such as /J#61vaScript only exist to slip past scanners
+	for _, name := range raw.obfuscated {
+		add(SecurityObfuscatedName, "security relevant names are hex escaped to hide them", 0, name)
+	}
+
+	// Active content the parser sees but the raw file does not is packed into object streams
+	if report.ObjectStreamCount > 0 {
+		hidden := map[string]bool{
+			"JavaScript":   report.JavaScriptCount > 0 && raw.keywords["JS"]+raw.keywords["JavaScript"] == 0,
+			"Launch":       report.LaunchActionCount > 0 && raw.keywords["Launch"] == 0,
+			"EmbeddedFile": report.EmbeddedFileCount > 0 && raw.keywords["EmbeddedFile"]+raw.keywords["EmbeddedFiles"]+raw.keywords["FileAttachment"] == 0,
+		}
+		for _, name := range []string{"JavaScript", "Launch", "EmbeddedFile"} {
+			if hidden[name] && len(raw.obfuscated) == 0 {
+				add(SecuritySuspiciousObjStm, "active content is only reachable through compressed object streams", 0, name)
+			}
+		}
+
+		// Object streams arrived with PDF 1.5
+		if pdfVersion != "" && pdfVersion < "1.5" {
+			add(SecuritySuspiciousObjStm, "object streams in a file declaring a version that predates them", 0, "PDF "+pdfVersion)
+		}
+	}
+
+	for _, finding := range findings {
+		finding.Severity = findingSeverity(finding.Score)
+		report.RiskScore += finding.Score
+		report.Findings = append(report.Findings, *finding)
+	}
+	if report.RiskScore > 100 {
+		report.RiskScore = 100
+	}
+	report.RiskLevel = riskLevel(report.RiskScore)
+
+	// Highest scoring reasons first
+	sort.Slice(report.Findings, func(i, j int) bool {
+		if report.Findings[i].Score != report.Findings[j].Score {
+			return report.Findings[i].Score > report.Findings[j].Score
+		}
+		return report.Findings[i].Code < report.Findings[j].Code
+	})
+	return report
+}
+
+// Open actions and additional actions fire without the reader clicking anything
+func isAutomaticTrigger(trigger string) bool {
+	return trigger == "OpenAction" || trigger == "Names/JavaScript" ||
+		strings.HasPrefix(trigger, "catalog/AA/") || strings.HasPrefix(trigger, "page/AA/")
+}
+
+func findingSeverity(score int) string {
+	switch {
+	case score >= 30:
+		return RiskHigh
+	case score >= 15:
+		return RiskMedium
+	}
+	return RiskLow
+}
+
+func riskLevel(score int) string {
+	switch {
+	case score >= 50:
+		return RiskHigh
+	case score >= 25:
+		return RiskMedium
+	case score > 0:
+		return RiskLow
+	}
+	return RiskNone
+}
+
+// ==================== RAW SCAN ====================
+
+// Chunk size for the raw scan, matches keep this much overlap between chunks
+const (
+	rawScanChunk   = 1024 * 1024
+	rawScanOverlap = 256
+)
+
+// Names whose occurrences the raw scan counts, the parser resolves them
+// through object streams but a byte scan cannot
+var rawSecurityKeywords = map[string]bool{
+	"JS": true, "JavaScript": true, "Launch": true, "EmbeddedFile": true, "EmbeddedFiles": true,
+	"FileAttachment": true, "OpenAction": true, "AA": true, "XFA": true, "RichMedia": true,
+	"URI": true, "ObjStm": true, "SubmitForm": true, "ImportData": true, "AcroForm": true,
+}
+
+// A PDF name ends at whitespace or a delimiter
+var rawNamePattern = regexp.MustCompile(`/([^\s()<>\[\]{}/%]+)[\s()<>\[\]{}/%]`)
+
+var hexEscapedName = regexp.MustCompile(`#([0-9A-Fa-f]{2})`)
+
+// Keyword counts read straight from the file bytes
+type rawSecurityScan struct {
+	keywords   map[string]int
+	obfuscated []string
+}
+
+// Scan the file for security relevant names, including hex escaped ones
+func scanRawPDF(ctx context.Context, file io.ReaderAt, size int64) (rawSecurityScan, error) {
+	scan := rawSecurityScan{keywords: make(map[string]int)}
+	seenObfuscated := make(map[string]bool)
+
+	buf := make([]byte, rawScanChunk+rawScanOverlap)
+	window := buf[:0]
+	for offset := int64(0); ; {
+		if ctx.Err() != nil {
+			return scan, ctx.Err()
+		}
+
+		n, err := file.ReadAt(buf[len(window):len(window)+rawScanChunk], offset)
+		offset += int64(n)
+		window = buf[:len(window)+n]
+		done := err != nil || offset >= size
+
+		// Matches starting in the overlap are counted with the next chunk
+		limit := len(window)
+		if !done && limit > rawScanOverlap {
+			limit -= rawScanOverlap
+		}
+
+		for _, match := range rawNamePattern.FindAllSubmatchIndex(window, -1) {
+			if match[0] >= limit {
+				break
+			}
+			name := string(window[match[2]:match[3]])
+			if strings.Contains(name, "#") {
+				decoded := decodePDFName(name)
+				if rawSecurityKeywords[decoded] && !seenObfuscated[name] && len(scan.obfuscated) < maxFindingObjects {
+					seenObfuscated[name] = true
+					scan.obfuscated = append(scan.obfuscated, "/"+name)
+				}
+				continue
+			}
+			if rawSecurityKeywords[name] {
+				scan.keywords[name]++
+			}
+		}
+
+		if done {
+			break
+		}
+		window = buf[:copy(buf, window[limit:])]
+	}
+	return scan, nil
+}
+
+// Resolve #xx escapes in a name
+func decodePDFName(name string) string {
+	return hexEscapedName.ReplaceAllStringFunc(name, func(escape string) string {
+		b, err := strconv.ParseUint(escape[1:], 16, 8)
+		if err != nil {
+			return escape
+		}
+		return string(rune(b))
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecurityScan(t *testing.T) {
+	// Each fixture opens with a script and carries an attachment and a payment link
+	tests := []struct {
+		fixture  string
+		findings []string // highest scoring first
+		score    int
+	}{
+		{"sample.pdf", nil, 0},
+		{"risky.pdf", []string{SecurityExecutableFile, SecurityJavaScript, SecurityAutoRun, SecurityEmbeddedFile, SecurityExternalURI}, 100},
+		{
+			// The script is packed into a compressed object stream
+			"hidden.pdf", []string{SecurityJavaScript, SecuritySuspiciousObjStm, SecurityAutoRun, SecurityEmbeddedFile, SecurityExternalURI}, 95,
+		},
+		{
+			// The script action is named /J#61vaScript
+			"obfuscated.pdf", []string{SecurityJavaScript, SecurityObfuscatedName, SecurityAutoRun, SecurityEmbeddedFile, SecurityExternalURI}, 100,
+		},
+	}
+
+	pa := newTestAnalyzer(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			result := analyzeFixture(t, pa, tt.fixture)
+			report := result.Security
+
+			var codes []string
+			for _, finding := range report.Findings {
+				codes = append(codes, finding.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.findings) {
+				t.Errorf("findings %v, want %v", codes, tt.findings)
+			}
+			if report.RiskScore != tt.score || report.RiskLevel != riskLevel(tt.score) {
+				t.Errorf("risk %d %s, want %d", report.RiskScore, report.RiskLevel, tt.score)
+			}
+
+			// The default policy quarantines anything high risk
+			if quarantined := result.Routing.Strategy == StrategyQuarantine; quarantined != (tt.score >= 50) {
+				t.Errorf("routed to %s with a risk score of %d", result.Routing.Strategy, report.RiskScore)
+			}
+		})
+	}
+}
+
+func TestSecurityFindingDetails(t *testing.T) {
+	report := analyzeFixture(t, newTestAnalyzer(t), "risky.pdf").Security
+	findings := make(map[string]SecurityFinding)
+	for _, finding := range report.Findings {
+		findings[finding.Code] = finding
+	}
+
+	if exe := findings[SecurityExecutableFile]; !reflect.DeepEqual(exe.Objects, []string{"setup.exe"}) || exe.Severity != RiskHigh {
+		t.Errorf("executable finding %+v", exe)
+	}
+	if link := findings[SecurityExternalURI]; link.Page != 1 || !reflect.DeepEqual(link.Objects, []string{"https://example.com/pay"}) {
+		t.Errorf("link finding %+v, want the URI on page 1", link)
+	}
+	if report.JavaScriptCount != 1 || report.EmbeddedFileCount != 1 || report.ExternalURICount != 1 || report.LaunchActionCount != 0 {
+		t.Errorf("counts %+v", report)
+	}
+}
+
+func TestScanRawPDFAcrossChunks(t *testing.T) {
+	// Names straddling a chunk boundary, or inside the overlap carried into
+	// the next chunk, are counted exactly once
+	data := bytes.Repeat([]byte(" "), 3*rawScanChunk)
+	copy(data[rawScanChunk-4:], "/JavaScript ")
+	copy(data[2*rawScanChunk-rawScanOverlap+10:], "/Launch ")
+	copy(data[len(data)-10:], "/J#53 ")
+
+	scan, err := scanRawPDF(context.Background(), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.keywords["JavaScript"] != 1 || scan.keywords["Launch"] != 1 {
+		t.Errorf("keywords %v, want one JavaScript and one Launch", scan.keywords)
+	}
+	if !reflect.DeepEqual(scan.obfuscated, []string{"/J#53"}) {
+		t.Errorf("obfuscated %v, want /J#53", scan.obfuscated)
+	}
+}
+
+func TestDecodePDFName(t *testing.T) {
+	for name, want := range map[string]string{
+		"J#61vaScript": "JavaScript",
+		"#4Caunch":     "Launch",
+		"Plain":        "Plain",
+		"Bad#zz":       "Bad#zz",
+	} {
+		if got := decodePDFName(name); got != want {
+			t.Errorf("decodePDFName(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if strings.Contains(decodePDFName("A#20B"), "#") {
+		t.Error("escaped space left undecoded")
+	}
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Typed storage errors, match with errors.Is
+var (
+	ErrObjectNotFound      = errors.New("storage object not found")
+	ErrPathTraversal       = errors.New("path escapes storage root")
+	ErrAccessDenied        = errors.New("storage access denied")
+	ErrUnsupportedLocation = errors.New("unsupported storage location")
+)
+
+// Wraps a storage failure with the backend and location that caused it
+type StorageError struct {
+	Backend  string
+	Location string
+	Err      error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("%s storage: %s: %v", e.Backend, e.Location, e.Err)
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// A stored document opened for random access reads
+type Object interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// Storage backend the analyzer reads documents from
+type Storage interface {
+	Open(ctx context.Context, location string) (Object, error)
+}
+
+// An artifact being written, only visible at its location once committed
+type ArtifactWriter interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// Storage backend the analyzer can also write artifacts to
+type WritableStorage interface {
+	Storage
+	Create(ctx context.Context, location string) (ArtifactWriter, error)
+}
+
+// ==================== LOCAL STORAGE ====================
+
+// Path the frontend addresses uploads under, e.g. file_path /uploads/<id>.pdf
+const defaultUploadPathPrefix = "/uploads"
+
+// Reads documents from a directory, locations are relative to Root
+type LocalStorage struct {
+	Root string
+
+	// Prefix standing in for Root in locations, /uploads/a.pdf reads Root/a.pdf
+	PathPrefix string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root, PathPrefix: defaultUploadPathPrefix}
+}
+
+// Local storage for artifacts, their locations are never upload paths
+func newArtifactStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root}
+}
+
+// Location with PathPrefix dropped, relative to Root from there on
+func (ls *LocalStorage) relative(location string) string {
+	prefix := strings.TrimRight(ls.PathPrefix, "/")
+	if prefix != "" && strings.HasPrefix(location, prefix+"/") {
+		return strings.TrimPrefix(location, prefix+"/")
+	}
+	return location
+}
+
+func (ls *LocalStorage) Open(ctx context.Context, location string) (Object, error) {
+	fullPath, err := resolveWithinRoot(ls.Root, ls.relative(location))
+	if err != nil {
+		return nil, &StorageError{Backend: "local", Location: location, Err: err}
+	}
+
+	return openLocalFile("local", location, fullPath)
+}
+
+func (ls *LocalStorage) Create(ctx context.Context, location string) (ArtifactWriter, error) {
+	fullPath, err := resolveWithinRoot(ls.Root, ls.relative(location))
+	if err != nil {
+		return nil, &StorageError{Backend: "local", Location: location, Err: err}
+	}
+
+	return createLocalFile("local", location, fullPath)
+}
+
+// ==================== FILE URI STORAGE ====================
+
+// Reads documents addressed as file:// URIs, restricted to Root
+type FileURIStorage struct {
+	Root string
+}
+
+func NewFileURIStorage(root string) *FileURIStorage {
+	return &FileURIStorage{Root: root}
+}
+
+func (fs *FileURIStorage) Open(ctx context.Context, location string) (Object, error) {
+	fullPath, err := fs.resolve(location)
+	if err != nil {
+		return nil, &StorageError{Backend: "file", Location: location, Err: err}
+	}
+
+	return openLocalFile("file", location, fullPath)
+}
+
+func (fs *FileURIStorage) Create(ctx context.Context, location string) (ArtifactWriter, error) {
+	fullPath, err := fs.resolve(location)
+	if err != nil {
+		return nil, &StorageError{Backend: "file", Location: location, Err: err}
+	}
+
+	return createLocalFile("file", location, fullPath)
+}
+
+// Maps a file:// URI onto a path within Root
+func (fs *FileURIStorage) resolve(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "file" {
+		return "", ErrUnsupportedLocation
+	}
+
+	// Only local hosts make sense for file URIs
+	if u.Host != "" && u.Host != "localhost" {
+		return "", ErrUnsupportedLocation
+	}
+
+	return resolveWithinRoot(fs.Root, u.Path)
+}
+
+// Joins location onto root and rejects anything that lands outside of it, also
+// once symlinks are followed. Absolute locations already inside root are kept,
+// any other absolute location is taken as relative to root.
+func resolveWithinRoot(root, location string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	fullPath := filepath.Clean(location)
+	if !filepath.IsAbs(location) || !withinDir(absRoot, fullPath) {
+		fullPath = filepath.Join(absRoot, location)
+	}
+	if !withinDir(absRoot, fullPath) {
+		return "", ErrPathTraversal
+	}
+
+	// A symlink inside root may still point outside of it
+	if !withinDir(resolveSymlinks(absRoot), resolveSymlinks(fullPath)) {
+		return "", ErrPathTraversal
+	}
+
+	return fullPath, nil
+}
+
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Path with symlinks followed as far as it exists and the missing rest appended,
+// so artifacts about to be created are checked as well
+func resolveSymlinks(path string) string {
+	missing := ""
+	for {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(real, missing)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, missing)
+		}
+		missing = filepath.Join(filepath.Base(path), missing)
+		path = parent
+	}
+}
+
+type localObject struct {
+	*os.File
+	size int64
+}
+
+func (lo *localObject) Size() int64 {
+	return lo.size
+}
+
+func openLocalFile(backend, location, fullPath string) (Object, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, &StorageError{Backend: backend, Location: location, Err: translateFSError(err)}
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, &StorageError{Backend: backend, Location: location, Err: translateFSError(err)}
+	}
+
+	if fileInfo.IsDir() {
+		file.Close()
+		return nil, &StorageError{Backend: backend, Location: location, Err: ErrObjectNotFound}
+	}
+
+	return &localObject{File: file, size: fileInfo.Size()}, nil
+}
+
+// Writes next to the final path and renames into place on Commit,
+// so readers never see a half written artifact
+type localArtifact struct {
+	*os.File
+	backend  string
+	location string
+	fullPath string
+}
+
+func createLocalFile(backend, location, fullPath string) (ArtifactWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return nil, &StorageError{Backend: backend, Location: location, Err: translateFSError(err)}
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".*")
+	if err != nil {
+		return nil, &StorageError{Backend: backend, Location: location, Err: translateFSError(err)}
+	}
+
+	return &localArtifact{File: file, backend: backend, location: location, fullPath: fullPath}, nil
+}
+
+func (la *localArtifact) Commit() error {
+	if err := la.File.Close(); err != nil {
+		os.Remove(la.File.Name())
+		return &StorageError{Backend: la.backend, Location: la.location, Err: err}
+	}
+
+	if err := os.Rename(la.File.Name(), la.fullPath); err != nil {
+		os.Remove(la.File.Name())
+		return &StorageError{Backend: la.backend, Location: la.location, Err: translateFSError(err)}
+	}
+	return nil
+}
+
+func (la *localArtifact) Abort() error {
+	la.File.Close()
+	return os.Remove(la.File.Name())
+}
+
+func translateFSError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: %v", ErrObjectNotFound, err)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %v", ErrAccessDenied, err)
+	}
+	return err
+}
+
+// ==================== S3 STORAGE ====================
+
+// Connection settings for an S3-compatible API (AWS, MinIO, etc.)
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UsePathStyle    bool
+
+	// Objects larger than this are refused rather than downloaded, zero for no limit
+	MaxObjectSize int64
+}
+
+// Reads documents addressed as s3://bucket/key using SigV4 signed requests
+type S3Storage struct {
+	config     S3Config
+	httpClient *http.Client
+}
+
+func NewS3Storage(config S3Config) *S3Storage {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+
+	return &S3Storage{
+		config:     config,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (s3 *S3Storage) Open(ctx context.Context, location string) (Object, error) {
+	bucket, key, err := parseS3Location(location)
+	if err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	req, err := s3.newRequest(ctx, http.MethodGet, bucket, key)
+	if err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	resp, err := s3.httpClient.Do(req)
+	if err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if err := s3StatusError(resp); err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	// Refuse oversized objects before downloading them when S3 says how big they are
+	max := s3.config.MaxObjectSize
+	if max > 0 && resp.ContentLength > max {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: &LimitError{Limit: LimitFileSize, Value: resp.ContentLength, Max: max}}
+	}
+
+	// pdf.Reader needs random access, so spool the object to a temp file
+	tmp, err := os.CreateTemp("", "analyzer-s3-*")
+	if err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	// Stop one byte past the limit in case the length was missing or wrong
+	var body io.Reader = resp.Body
+	if max > 0 {
+		body = io.LimitReader(resp.Body, max+1)
+	}
+	size, err := io.Copy(tmp, body)
+	if err == nil && max > 0 && size > max {
+		err = &LimitError{Limit: LimitFileSize, Value: size, Max: max}
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	return &tempObject{localObject: localObject{File: tmp, size: size}}, nil
+}
+
+func (s3 *S3Storage) Create(ctx context.Context, location string) (ArtifactWriter, error) {
+	bucket, key, err := parseS3Location(location)
+	if err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	// PUT needs the content length up front, so spool to a temp file first
+	tmp, err := os.CreateTemp("", "analyzer-s3-put-*")
+	if err != nil {
+		return nil, &StorageError{Backend: "s3", Location: location, Err: err}
+	}
+
+	return &s3Artifact{File: tmp, s3: s3, ctx: ctx, location: location, bucket: bucket, key: key}, nil
+}
+
+// Temp file uploaded to S3 with a single PUT on Commit
+type s3Artifact struct {
+	*os.File
+	s3       *S3Storage
+	ctx      context.Context
+	location string
+	bucket   string
+	key      string
+}
+
+func (sa *s3Artifact) Commit() error {
+	defer sa.Abort()
+
+	if err := sa.upload(); err != nil {
+		return &StorageError{Backend: "s3", Location: sa.location, Err: err}
+	}
+	return nil
+}
+
+func (sa *s3Artifact) upload() error {
+	size, err := sa.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := sa.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := sa.s3.newRequest(sa.ctx, http.MethodPut, sa.bucket, sa.key)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(sa.File)
+	req.ContentLength = size
+
+	resp, err := sa.s3.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return s3StatusError(resp)
+}
+
+func (sa *s3Artifact) Abort() error {
+	sa.File.Close()
+	return os.Remove(sa.File.Name())
+}
+
+// Splits s3://bucket/key into its parts
+func parseS3Location(location string) (string, string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", ErrUnsupportedLocation
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" {
+		return "", "", ErrUnsupportedLocation
+	}
+
+	return u.Host, key, nil
+}
+
+func (s3 *S3Storage) objectURL(bucket, key string) (*url.URL, error) {
+	endpoint, err := url.Parse(s3.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *endpoint
+	if s3.config.UsePathStyle {
+		u.Path = "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + endpoint.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+
+	return &u, nil
+}
+
+// Build a SigV4 signed request for a single object
+func (s3 *S3Storage) newRequest(ctx context.Context, method, bucket, key string) (*http.Request, error) {
+	u, err := s3.objectURL(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s3.sign(req, "UNSIGNED-PAYLOAD", time.Now().UTC())
+	return req, nil
+}
+
+func (s3 *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// Anonymous access when no credentials are configured
+	if s3.config.AccessKeyID == "" {
+		return
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := shortDate + "/" + s3.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s3.config.SecretAccessKey), shortDate)
+	signingKey = hmacSHA256(signingKey, s3.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URI-encode each path segment the way SigV4 expects
+func s3EscapePath(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+func s3StatusError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrObjectNotFound
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return ErrAccessDenied
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// Temp file copy of a remote object, removed on Close
+type tempObject struct {
+	localObject
+}
+
+func (to *tempObject) Close() error {
+	err := to.File.Close()
+	os.Remove(to.File.Name())
+	return err
+}
+
+// ==================== ROUTING ====================
+
+// Dispatches locations to a backend based on their URI scheme
+type StorageRouter struct {
+	Local   Storage
+	FileURI Storage
+	S3      Storage
+
+	// Writes to local locations, which are only ever artifacts, so they stay
+	// out of the uploads Local reads from; Local when unset
+	Artifacts Storage
+}
+
+func (sr *StorageRouter) Open(ctx context.Context, location string) (Object, error) {
+	backend, err := sr.backendFor(location)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Open(ctx, location)
+}
+
+func (sr *StorageRouter) Create(ctx context.Context, location string) (ArtifactWriter, error) {
+	backend, err := sr.backendFor(location)
+	if err != nil {
+		return nil, err
+	}
+	if backend == sr.Local && sr.Artifacts != nil {
+		backend = sr.Artifacts
+	}
+
+	writable, ok := backend.(WritableStorage)
+	if !ok {
+		return nil, &StorageError{
+			Backend:  "router",
+			Location: location,
+			Err:      fmt.Errorf("%w: backend is read only", ErrUnsupportedLocation),
+		}
+	}
+	return writable.Create(ctx, location)
+}
+
+func (sr *StorageRouter) backendFor(location string) (Storage, error) {
+	var backend Storage
+	scheme := "local"
+
+	switch {
+	case strings.HasPrefix(location, "file://"):
+		backend, scheme = sr.FileURI, "file"
+	case strings.HasPrefix(location, "s3://"):
+		backend, scheme = sr.S3, "s3"
+	case strings.Contains(location, "://"):
+		return nil, &StorageError{Backend: "router", Location: location, Err: ErrUnsupportedLocation}
+	default:
+		backend = sr.Local
+	}
+
+	if backend == nil {
+		return nil, &StorageError{
+			Backend:  "router",
+			Location: location,
+			Err:      fmt.Errorf("%w: %s backend not configured", ErrUnsupportedLocation, scheme),
+		}
+	}
+	return backend, nil
+}
+
+// Rejects an artifact prefix whose directory overlaps the local upload root,
+// where artifacts could overwrite uploads or be read back as one
+func (sr *StorageRouter) CheckArtifactPrefix(prefix string) error {
+	uploads, ok := sr.Local.(*LocalStorage)
+	if !ok {
+		return nil
+	}
+	artifacts := uploads
+	if sr.Artifacts != nil {
+		if artifacts, ok = sr.Artifacts.(*LocalStorage); !ok {
+			return nil
+		}
+	}
+
+	dir, err := resolveWithinRoot(artifacts.Root, artifacts.relative(strings.TrimRight(prefix, "/")))
+	if err != nil {
+		return fmt.Errorf("artifact prefix %q: %w", prefix, err)
+	}
+	uploadRoot, err := filepath.Abs(uploads.Root)
+	if err != nil {
+		return err
+	}
+
+	dir, uploadRoot = resolveSymlinks(dir), resolveSymlinks(uploadRoot)
+	if withinDir(uploadRoot, dir) || withinDir(dir, uploadRoot) {
+		return fmt.Errorf("artifact prefix %q resolves to %s, which overlaps the upload root %s", prefix, dir, uploadRoot)
+	}
+	return nil
+}
+
+// Default upload directory used when nothing else is configured
+const defaultStorageRoot = "./storage/uploads"
+
+// Default directory local artifacts are written under, beside the uploads
+const defaultArtifactRoot = "./storage"
+
+// Builds the storage router from ANALYZER_* and S3_* environment variables
+func NewStorageFromEnv() *StorageRouter {
+	root := os.Getenv("ANALYZER_STORAGE_ROOT")
+	if root == "" {
+		root = defaultStorageRoot
+	}
+
+	fileRoot := os.Getenv("ANALYZER_FILE_URI_ROOT")
+	if fileRoot == "" {
+		fileRoot = root
+	}
+
+	local := NewLocalStorage(root)
+	if prefix, ok := os.LookupEnv("ANALYZER_STORAGE_PATH_PREFIX"); ok {
+		local.PathPrefix = prefix
+	}
+
+	artifactRoot := os.Getenv("ANALYZER_ARTIFACT_ROOT")
+	if artifactRoot == "" {
+		artifactRoot = defaultArtifactRoot
+	}
+	router := &StorageRouter{
+		Local:     local,
+		FileURI:   NewFileURIStorage(fileRoot),
+		Artifacts: newArtifactStorage(artifactRoot),
+	}
+
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" || os.Getenv("S3_ACCESS_KEY_ID") != "" {
+		router.S3 = NewS3Storage(S3Config{
+			Endpoint:        endpoint,
+			Region:          os.Getenv("S3_REGION"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			UsePathStyle:    os.Getenv("S3_USE_PATH_STYLE") == "true",
+			MaxObjectSize:   ResourceLimitsFromEnv().MaxFileSize,
+		})
+	}
+
+	return router
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Words further apart than this many font sizes sit in different cells
+const cellGapRatio = 1.0
+
+// Rows further apart than this many font sizes end a table
+const tableRowGapRatio = 2.5
+
+// Smallest grid reported as a table, header row included
+const (
+	minTableRows    = 3
+	minTableColumns = 2
+)
+
+// Prose laid out in columns has long cells, tables rarely do
+const maxAverageCellChars = 30
+
+// Tables kept on the analysis result, every table still goes to the text artifact
+const maxReportedTables = 50
+
+// A table found from the alignment of positioned words on a page
+type Table struct {
+	PageNumber  int         `json:"page_number"`
+	BoundingBox BoundingBox `json:"bounding_box"`
+	RowCount    int         `json:"row_count"`
+	ColumnCount int         `json:"column_count"`
+	Cells       [][]string  `json:"cells"`
+}
+
+// Rectangle in PDF points, origin at the bottom left of the page
+type BoundingBox struct {
+	X0 float64 `json:"x0"`
+	Y0 float64 `json:"y0"`
+	X1 float64 `json:"x1"`
+	Y1 float64 `json:"y1"`
+}
+
+// Words on one row close enough together to read as a single cell
+type cellSpan struct {
+	Text     string
+	X0       float64
+	X1       float64
+	FontSize float64
+}
+
+// A row of the page split into cells
+type spanRow struct {
+	Y     float64
+	Spans []cellSpan
+}
+
+// Find tables on a page: runs of consecutive rows split into several cells
+// whose horizontal extents line up into shared columns
+func detectTables(pageNumber int, rows []TextRow) []Table {
+	var tables []Table
+	var block []spanRow
+
+	closeBlock := func() {
+		if table, ok := buildTable(pageNumber, block); ok {
+			tables = append(tables, table)
+		}
+		block = nil
+	}
+
+	for _, row := range rows {
+		spans := splitCells(row)
+		if len(spans) < minTableColumns {
+			closeBlock()
+			continue
+		}
+
+		current := spanRow{Y: row.Y, Spans: spans}
+		if len(block) > 0 {
+			previous := block[len(block)-1]
+			if previous.Y-current.Y > tableRowGapRatio*rowFontSize(current) {
+				closeBlock()
+			}
+		}
+		block = append(block, current)
+	}
+	closeBlock()
+
+	return tables
+}
+
+// Group a row's words into cells on wide horizontal gaps
+func splitCells(row TextRow) []cellSpan {
+	var spans []cellSpan
+	for _, word := range row.Words {
+		if n := len(spans); n > 0 {
+			last := &spans[n-1]
+			if word.X-last.X1 <= cellGapRatio*math.Max(word.FontSize, last.FontSize) {
+				last.Text += " " + word.Text
+				last.X1 = math.Max(last.X1, word.X+word.Width)
+				continue
+			}
+		}
+		spans = append(spans, cellSpan{
+			Text:     word.Text,
+			X0:       word.X,
+			X1:       word.X + word.Width,
+			FontSize: word.FontSize,
+		})
+	}
+	return spans
+}
+
+func rowFontSize(row spanRow) float64 {
+	size := 0.0
+	for _, span := range row.Spans {
+		size = math.Max(size, span.FontSize)
+	}
+	if size == 0 {
+		size = 12
+	}
+	return size
+}
+
+// Turn a block of multi-cell rows into a table when its cells share columns
+func buildTable(pageNumber int, block []spanRow) (Table, bool) {
+	if len(block) < minTableRows {
+		return Table{}, false
+	}
+
+	columns := tableColumns(block)
+	if len(columns) < minTableColumns {
+		return Table{}, false
+	}
+
+	table := Table{
+		PageNumber:  pageNumber,
+		RowCount:    len(block),
+		ColumnCount: len(columns),
+		Cells:       make([][]string, len(block)),
+		BoundingBox: BoundingBox{
+			X0: columns[0][0],
+			X1: columns[len(columns)-1][1],
+			Y0: block[len(block)-1].Y,
+			Y1: block[0].Y + rowFontSize(block[0]),
+		},
+	}
+
+	chars, cells, collisions := 0, 0, 0
+	for r, row := range block {
+		table.Cells[r] = make([]string, len(columns))
+		collided := false
+		for _, span := range row.Spans {
+			c := columnIndex(columns, span)
+			if table.Cells[r][c] != "" {
+				table.Cells[r][c] += " "
+				collided = true
+			}
+			table.Cells[r][c] += span.Text
+			chars += utf8.RuneCountInString(span.Text)
+			cells++
+		}
+		if collided {
+			collisions++
+		}
+	}
+
+	// Two columns of running text line up too, but its cells are long
+	if chars/cells > maxAverageCellChars {
+		return Table{}, false
+	}
+
+	// Rows that fold several cells into one column mean the columns don't really align
+	if collisions*4 > len(block) {
+		return Table{}, false
+	}
+
+	table.BoundingBox = roundBox(table.BoundingBox)
+	return table, true
+}
+
+// Merge overlapping cell extents across every row into column ranges, left to right
+func tableColumns(block []spanRow) [][2]float64 {
+	var extents [][2]float64
+	for _, row := range block {
+		for _, span := range row.Spans {
+			extents = append(extents, [2]float64{span.X0, span.X1})
+		}
+	}
+	sort.Slice(extents, func(i, j int) bool { return extents[i][0] < extents[j][0] })
+
+	var columns [][2]float64
+	for _, extent := range extents {
+		if n := len(columns); n > 0 && extent[0] <= columns[n-1][1] {
+			columns[n-1][1] = math.Max(columns[n-1][1], extent[1])
+			continue
+		}
+		columns = append(columns, extent)
+	}
+	return columns
+}
+
+func columnIndex(columns [][2]float64, span cellSpan) int {
+	for i, column := range columns {
+		if span.X0 >= column[0] && span.X0 <= column[1] {
+			return i
+		}
+	}
+	return len(columns) - 1
+}
+
+func roundBox(box BoundingBox) BoundingBox {
+	return BoundingBox{
+		X0: roundTo(box.X0, 2),
+		Y0: roundTo(box.Y0, 2),
+		X1: roundTo(box.X1, 2),
+		Y1: roundTo(box.Y1, 2),
+	}
+}
+
+// Keep the first maxReportedTables tables for the analysis result
+func appendTables(tables, found []Table) []Table {
+	if room := maxReportedTables - len(tables); room < len(found) {
+		found = found[:maxInt(room, 0)]
+	}
+	return append(tables, found...)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
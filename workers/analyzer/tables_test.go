@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A row of 10 point words at baseline y, each word given as its x and text
+func textRow(y float64, words ...any) TextRow {
+	row := TextRow{Y: y}
+	for i := 0; i+1 < len(words); i += 2 {
+		text := words[i+1].(string)
+		row.Words = append(row.Words, TextWord{
+			Text:     text,
+			X:        float64(words[i].(int)),
+			Y:        y,
+			Width:    5 * float64(len(text)),
+			FontSize: 10,
+		})
+	}
+	return row
+}
+
+func TestDetectTablesInPDF(t *testing.T) {
+	root := t.TempDir()
+	pa := newExtractingAnalyzer(t, root)
+
+	// Off unless the config or the request asks for it
+	if result := analyzeFixture(t, pa, "table.pdf"); len(result.Tables) != 0 {
+		t.Fatalf("tables detected without being asked for: %+v", result.Tables)
+	}
+
+	input := DocumentInput{ID: "prices", FilePath: "table.pdf", DetectTables: true, ExtractText: true}
+	result, err := pa.AnalyzeDocument(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Tables) != 1 {
+		t.Fatalf("found %d tables, want 1", len(result.Tables))
+	}
+
+	table := result.Tables[0]
+	want := [][]string{
+		{"Item", "Qty", "Price"},
+		{"Apples", "10", "1.50"},
+		{"Pears", "20", "2.25"},
+		{"Plums", "5", "3.00"},
+	}
+	if table.PageNumber != 1 || table.RowCount != 4 || table.ColumnCount != 3 || !reflect.DeepEqual(table.Cells, want) {
+		t.Errorf("table %+v, want cells %v on page 1", table, want)
+	}
+	// Rows of 12 point Helvetica 14 points apart, the first on the baseline below 72,720
+	if box := table.BoundingBox; box.X0 != 72 || box.Y1 != 720-14+12 || box.Y0 != 720-4*14 || box.X1 <= box.X0 {
+		t.Errorf("BoundingBox = %+v", box)
+	}
+
+	// Tables travel with their page in the text artifact too
+	pages := readTextArtifact(t, filepath.Join(root, result.Extraction.Location))
+	if len(pages) != 1 || !reflect.DeepEqual(pages[0].Tables, result.Tables) {
+		t.Errorf("artifact tables %+v", pages[0].Tables)
+	}
+}
+
+func TestDetectTables(t *testing.T) {
+	t.Run("aligned columns", func(t *testing.T) {
+		tables := detectTables(3, []TextRow{
+			textRow(700, 72, "Invoice", 72+8*5, "summary"),
+			textRow(680, 72, "Date", 200, "Amount", 300, "Status"),
+			textRow(668, 72, "2024-01-02", 200, "12.50", 300, "paid"),
+			textRow(656, 72, "2024-02-02", 205, "7.00", 300, "open"),
+			textRow(600, 72, "Thank you for your business."),
+		})
+		if len(tables) != 1 {
+			t.Fatalf("found %d tables, want 1", len(tables))
+		}
+		// Words a space apart stay one cell, the heading and footer are not rows
+		table := tables[0]
+		if table.PageNumber != 3 || table.RowCount != 3 || table.ColumnCount != 3 || table.Cells[2][1] != "7.00" {
+			t.Errorf("table %+v", table)
+		}
+	})
+
+	t.Run("gap between rows", func(t *testing.T) {
+		block := func(y float64) []TextRow {
+			return []TextRow{
+				textRow(y, 72, "a", 200, "b"),
+				textRow(y-12, 72, "c", 200, "d"),
+				textRow(y-24, 72, "e", 200, "f"),
+			}
+		}
+		// Further apart than tableRowGapRatio font sizes, two tables
+		tables := detectTables(1, append(block(700), block(700-24-26)...))
+		if len(tables) != 2 || tables[0].RowCount != 3 || tables[1].RowCount != 3 {
+			t.Errorf("found %+v, want two tables of three rows", tables)
+		}
+	})
+
+	t.Run("two columns of prose", func(t *testing.T) {
+		line := strings.Repeat("x", maxAverageCellChars)
+		var rows []TextRow
+		for i := 0; i < 5; i++ {
+			rows = append(rows, textRow(float64(700-12*i), 72, line+" prose", 320, line+" more"))
+		}
+		if tables := detectTables(1, rows); len(tables) != 0 {
+			t.Errorf("newspaper columns detected as %+v", tables)
+		}
+	})
+
+	t.Run("too few rows", func(t *testing.T) {
+		tables := detectTables(1, []TextRow{
+			textRow(700, 72, "Name", 200, "Value"),
+			textRow(688, 72, "a", 200, "1"),
+		})
+		if len(tables) != 0 {
+			t.Errorf("two rows detected as %+v", tables)
+		}
+	})
+}
+
+func TestAppendTablesCapsReport(t *testing.T) {
+	found := make([]Table, 30)
+	tables := appendTables(nil, found)
+	tables = appendTables(tables, found)
+	tables = appendTables(tables, found)
+	if len(tables) != maxReportedTables {
+		t.Errorf("kept %d tables, want %d", len(tables), maxReportedTables)
+	}
+}
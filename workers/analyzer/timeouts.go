@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Defaults keep a pathological document from holding a worker slot for long
+const (
+	defaultDocumentTimeout = 2 * time.Minute
+	defaultPageTimeout     = 20 * time.Second
+)
+
+// Extra time the Hatchet step gets over the document deadline, for opening,
+// hashing and writing artifacts around the content analysis
+const stepTimeoutHeadroom = time.Minute
+
+// A document stops after this many pages time out, each abandoned page keeps
+// a goroutine busy until the pdf package returns
+const maxPageTimeouts = 3
+
+// Why a result is partial
+const (
+	PartialDocumentTimeout = "document_timeout"
+	PartialPageTimeout     = "page_timeout"
+)
+
+// Stages skipped when the document deadline passes before they run
+const (
+	StageConformance = "conformance"
+	StageSecurity    = "security"
+)
+
+var (
+	errDocumentTimeout = errors.New("document analysis timed out")
+	errPageTimeout     = errors.New("page analysis timed out")
+)
+
+// Deadlines for content analysis, zero disables a timeout
+type TimeoutConfig struct {
+	Document time.Duration
+	Page     time.Duration
+}
+
+func DefaultTimeoutConfig() TimeoutConfig {
+	return TimeoutConfig{Document: defaultDocumentTimeout, Page: defaultPageTimeout}
+}
+
+// Timeouts from ANALYZER_DOCUMENT_TIMEOUT and ANALYZER_PAGE_TIMEOUT, e.g. 90s or 2m
+func TimeoutConfigFromEnv() TimeoutConfig {
+	config := DefaultTimeoutConfig()
+	config.Document = envDuration("ANALYZER_DOCUMENT_TIMEOUT", config.Document)
+	config.Page = envDuration("ANALYZER_PAGE_TIMEOUT", config.Page)
+	return config
+}
+
+// Duration from the environment, or fallback when unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// Hatchet step timeout covering the document deadline, empty keeps Hatchet's default
+func (tc TimeoutConfig) StepTimeout() string {
+	if tc.Document <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%ds", int((tc.Document + stepTimeoutHeadroom).Seconds()))
+}
+
+// Context for content analysis, ending at the document deadline
+func (tc TimeoutConfig) documentContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if tc.Document <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, tc.Document, errDocumentTimeout)
+}
+
+// What was left out of a result cut short by a deadline
+type PartialResult struct {
+	Reason        string   `json:"reason"`
+	PagesAnalyzed int      `json:"pages_analyzed"`
+	PagesTimedOut []int    `json:"pages_timed_out,omitempty"`
+	SkippedStages []string `json:"skipped_stages,omitempty"`
+}
+
+// Record why the result is partial, the document deadline outranks page timeouts
+func (cs *contentStats) markPartial(reason string) *PartialResult {
+	if cs.Partial == nil {
+		cs.Partial = &PartialResult{Reason: reason}
+	}
+	if reason == PartialDocumentTimeout {
+		cs.Partial.Reason = reason
+	}
+	return cs.Partial
+}
+
+// Record a page that timed out, true once enough have that the walk should stop
+func (cs *contentStats) pageTimedOut(pageNumber int) bool {
+	partial := cs.markPartial(PartialPageTimeout)
+	partial.PagesTimedOut = append(partial.PagesTimedOut, pageNumber)
+	return len(partial.PagesTimedOut) >= maxPageTimeouts
+}
+
+// Pages that were read, leaving out those that timed out
+func pagesAnalyzed(pages []PageReport) int {
+	count := 0
+	for _, page := range pages {
+		if !page.TimedOut {
+			count++
+		}
+	}
+	return count
+}
+
+// Mark the stats partial once the analysis context has ended; the document
+// deadline leaves a partial result, cancellation by the caller is an error
+func checkStopped(ctx context.Context, stats *contentStats) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	if context.Cause(ctx) != errDocumentTimeout {
+		return ctx.Err()
+	}
+	stats.markPartial(PartialDocumentTimeout)
+	return nil
+}
+
+// Run fn for one page, or another walk of the document such as the catalog, off
+// the calling goroutine, returning errPageTimeout or the context error when
+// either ends first; a zero timeout waits for the context alone. An abandoned fn keeps running until
+// the pdf package returns, so it must only write to variables the caller drops.
+// Panics are re-raised in the caller, where recoverPDFPanic handles them; their
+// origin is captured here, the re-raised panic's stack no longer shows it.
+func runPage(ctx context.Context, timeout time.Duration, fn func()) error {
+	done := make(chan *capturedPanic, 1)
+	go func() {
+		defer func() {
+			var p *capturedPanic
+			if r := recover(); r != nil {
+				p = capturePanic(r)
+			}
+			done <- p
+		}()
+		fn()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case p := <-done:
+		if p != nil {
+			panic(p)
+		}
+		return nil
+	case <-expired:
+		return errPageTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Runs fn when the analyzer logs message, to stop an analysis at a known point
+type logHook struct {
+	message string
+	fn      func()
+}
+
+func (h logHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h logHook) Fire(entry *logrus.Entry) error {
+	if entry.Message == h.message {
+		h.fn()
+	}
+	return nil
+}
+
+func TestAnalysisStoppedBetweenStages(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string // the point the analysis is stopped at
+		timeout  bool   // the document deadline rather than the caller
+		analyzed int
+	}{
+		{"cancelled before the pages", "Extracted page count", false, 0},
+		{"cancelled after the last page", "Page analysis completed", false, 1},
+		{"deadline before the pages", "Extracted page count", true, 0},
+		{"deadline after the last page", "Page analysis completed", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			timeouts := TimeoutConfig{Document: time.Minute}
+			stop := cancel
+			if tt.timeout {
+				timeouts.Document = 200 * time.Millisecond
+				stop = func() { time.Sleep(2 * timeouts.Document) }
+			}
+			pa := newTestAnalyzer(t, WithTimeouts(timeouts))
+			pa.logger.AddHook(logHook{tt.message, stop})
+
+			result, err := pa.AnalyzeDocument(ctx, DocumentInput{ID: "doc", FilePath: "sample.pdf"})
+			if !tt.timeout {
+				// Cancelled work is retried, it never becomes a result
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("AnalyzeDocument() = %+v, %v, want the cancellation", result, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &PartialResult{
+				Reason:        PartialDocumentTimeout,
+				PagesAnalyzed: tt.analyzed,
+				SkippedStages: []string{StageConformance, StageSecurity},
+			}
+			if !reflect.DeepEqual(result.Partial, want) {
+				t.Errorf("Partial = %+v, want %+v", result.Partial, want)
+			}
+			if result.Conformance != nil || result.Security != nil || len(result.Pages) != tt.analyzed {
+				t.Errorf("skipped stages reported: %d pages, conformance %v, security %v", len(result.Pages), result.Conformance, result.Security)
+			}
+		})
+	}
+}
+
+func TestPartialResultNotCached(t *testing.T) {
+	cache, err := NewResultCache(DefaultResultCacheConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeouts := TimeoutConfig{Document: 200 * time.Millisecond}
+	pa := newTestAnalyzer(t, WithTimeouts(timeouts), WithResultCache(cache))
+
+	slow := true
+	pa.logger.AddHook(logHook{"Page analysis completed", func() {
+		if slow {
+			time.Sleep(2 * timeouts.Document)
+		}
+	}})
+	if result := analyzeFixture(t, pa, "sample.pdf"); result.Partial == nil {
+		t.Fatal("analysis past the deadline not partial")
+	}
+
+	// The retry runs in full rather than getting the partial result back
+	slow = false
+	result := analyzeFixture(t, pa, "sample.pdf")
+	if result.Cached || result.Partial != nil || result.Security == nil {
+		t.Errorf("retry %+v, want a complete analysis", result)
+	}
+}
+
+func TestRunPage(t *testing.T) {
+	t.Run("finishes", func(t *testing.T) {
+		ran := false
+		if err := runPage(context.Background(), time.Minute, func() { ran = true }); err != nil || !ran {
+			t.Errorf("runPage() = %v, ran %v", err, ran)
+		}
+	})
+
+	t.Run("page timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		if err := runPage(context.Background(), 10*time.Millisecond, func() { <-release }); !errors.Is(err, errPageTimeout) {
+			t.Errorf("runPage() = %v, want a page timeout", err)
+		}
+	})
+
+	t.Run("context ends first", func(t *testing.T) {
+		ctx, cancel := context.WithTimeoutCause(context.Background(), 10*time.Millisecond, errDocumentTimeout)
+		defer cancel()
+		release := make(chan struct{})
+		defer close(release)
+		// No page timeout, only the document deadline
+		if err := runPage(ctx, 0, func() { <-release }); !errors.Is(err, context.DeadlineExceeded) || context.Cause(ctx) != errDocumentTimeout {
+			t.Errorf("runPage() = %v, cause %v", err, context.Cause(ctx))
+		}
+	})
+}
+
+func TestPageTimeouts(t *testing.T) {
+	stats := &contentStats{}
+	for page := 1; page < maxPageTimeouts; page++ {
+		if stats.pageTimedOut(page) {
+			t.Fatalf("walk stopped after %d timed out pages", page)
+		}
+	}
+	if !stats.pageTimedOut(maxPageTimeouts) {
+		t.Errorf("walk goes on after %d timed out pages", maxPageTimeouts)
+	}
+
+	// The document deadline outranks the page timeouts, whatever the order
+	stats.markPartial(PartialDocumentTimeout)
+	stats.markPartial(PartialPageTimeout)
+	if stats.Partial.Reason != PartialDocumentTimeout || len(stats.Partial.PagesTimedOut) != maxPageTimeouts {
+		t.Errorf("Partial = %+v", stats.Partial)
+	}
+
+	pages := []PageReport{{PageNumber: 1}, {PageNumber: 2, TimedOut: true}, {PageNumber: 3}}
+	if got := pagesAnalyzed(pages); got != 2 {
+		t.Errorf("pagesAnalyzed() = %d, want 2", got)
+	}
+}
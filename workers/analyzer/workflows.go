@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+	"github.com/sirupsen/logrus"
+)
+
+// Child workflow that extracts one page range of a parallel document
+const chunkWorkflowName = "analyze-document-chunk"
+
+// Outcome of one spawned chunk workflow
+type ChunkOutcome struct {
+	ChunkIndex int          `json:"chunk_index"`
+	StartPage  int          `json:"start_page"`
+	EndPage    int          `json:"end_page"`
+	Result     *ChunkResult `json:"result,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// Output of the fan-out step
+type FanOutOutput struct {
+	DocumentID string         `json:"document_id"`
+	Strategy   string         `json:"strategy"`
+	Skipped    bool           `json:"skipped"`
+	Chunks     []ChunkOutcome `json:"chunks"`
+}
+
+// Document level extraction aggregated from every chunk
+type JoinOutput struct {
+	DocumentID      string         `json:"document_id"`
+	Strategy        string         `json:"strategy"`
+	ChunkCount      int            `json:"chunk_count"`
+	ChunksSucceeded int            `json:"chunks_succeeded"`
+	ChunksFailed    int            `json:"chunks_failed"`
+	PagesExtracted  int            `json:"pages_extracted"`
+	TableCount      int            `json:"table_count"`
+	ImagesWritten   int            `json:"images_written"`
+	EntityCounts    map[string]int `json:"entity_counts,omitempty"`
+	PIICount        int            `json:"pii_count"`
+	Artifacts       []string       `json:"artifacts"` // text artifact of each chunk, in page order
+	Errors          []string       `json:"errors,omitempty"`
+}
+
+// Spawn one child workflow per planned chunk and wait for all of them
+func fanOutStep(pdfAnalyzer *PDFAnalyzer) func(ctx worker.HatchetContext, input *DocumentInput) (*FanOutOutput, error) {
+	return func(ctx worker.HatchetContext, input *DocumentInput) (*FanOutOutput, error) {
+		logger := pdfAnalyzer.logger
+
+		analysis := &AnalysisResult{}
+		if err := ctx.StepOutput("analyze", analysis); err != nil {
+			return nil, fmt.Errorf("failed to read analyze output: %w", err)
+		}
+
+		output := &FanOutOutput{
+			DocumentID: analysis.DocumentID,
+			Strategy:   analysis.ProcessType,
+			Chunks:     []ChunkOutcome{},
+		}
+
+		// Only parallel documents with extraction left to do fan out, duplicates
+		// reuse the original's results
+		if !isParallelStrategy(analysis.ProcessType) || analysis.ChunkPlan == nil ||
+			!analysis.ExtractionDeferred || analysis.DuplicateOf != "" {
+			output.Skipped = true
+			return output, nil
+		}
+
+		logger.WithFields(logrus.Fields{
+			"workflow_run_id": ctx.WorkflowRunId(),
+			"document_id":     analysis.DocumentID,
+			"chunk_count":     analysis.ChunkPlan.ChunkCount,
+		}).Info("Fan-out: SPAWNING CHUNK WORKFLOWS")
+
+		// Spawn every chunk first so they run concurrently
+		children := make([]*worker.ChildWorkflow, len(analysis.ChunkPlan.Chunks))
+		output.Chunks = make([]ChunkOutcome, len(analysis.ChunkPlan.Chunks))
+		for i, chunk := range analysis.ChunkPlan.Chunks {
+			output.Chunks[i] = ChunkOutcome{
+				ChunkIndex: chunk.Index,
+				StartPage:  chunk.StartPage,
+				EndPage:    chunk.EndPage,
+			}
+
+			// Stable key so a retried fan-out reuses the same child runs
+			key := fmt.Sprintf("%s-chunk-%d", analysis.DocumentID, chunk.Index)
+			child, err := ctx.SpawnWorkflow(chunkWorkflowName, newChunkInput(input, analysis.DocumentID, chunk),
+				&worker.SpawnWorkflowOpts{Key: &key})
+			if err != nil {
+				return nil, fmt.Errorf("failed to spawn chunk %d: %w", chunk.Index, err)
+			}
+			children[i] = child
+		}
+
+		// Wait for every chunk, failed chunks are reported rather than failing the step
+		var wg sync.WaitGroup
+		for i, child := range children {
+			wg.Add(1)
+			go func(i int, child *worker.ChildWorkflow) {
+				defer wg.Done()
+
+				workflowResult, err := child.Result()
+				if err != nil {
+					output.Chunks[i].Error = err.Error()
+					return
+				}
+
+				chunkResult := &ChunkResult{}
+				if err := workflowResult.StepOutput("analyze-chunk", chunkResult); err != nil {
+					output.Chunks[i].Error = err.Error()
+					return
+				}
+				output.Chunks[i].Result = chunkResult
+				if chunkResult.Error != nil {
+					output.Chunks[i].Error = chunkResult.Error.Message
+				}
+			}(i, child)
+		}
+		wg.Wait()
+
+		logger.WithFields(logrus.Fields{
+			"workflow_run_id": ctx.WorkflowRunId(),
+			"document_id":     analysis.DocumentID,
+		}).Info("Fan-out: ALL CHUNKS FINISHED")
+
+		return output, nil
+	}
+}
+
+// Aggregate chunk outputs into one document level result. Failed chunks are
+// reported in the output rather than failing the run, so the document still
+// reaches the emit step and the pipeline sees which pages are missing.
+func joinStep(pdfAnalyzer *PDFAnalyzer) func(ctx worker.HatchetContext, input *DocumentInput) (*JoinOutput, error) {
+	return func(ctx worker.HatchetContext, input *DocumentInput) (*JoinOutput, error) {
+		fanOut := &FanOutOutput{}
+		if err := ctx.StepOutput("fan-out", fanOut); err != nil {
+			return nil, fmt.Errorf("failed to read fan-out output: %w", err)
+		}
+
+		output := joinChunkOutcomes(fanOut)
+
+		entry := pdfAnalyzer.logger.WithFields(logrus.Fields{
+			"workflow_run_id":  ctx.WorkflowRunId(),
+			"document_id":      output.DocumentID,
+			"chunks_succeeded": output.ChunksSucceeded,
+			"chunks_failed":    output.ChunksFailed,
+		})
+		if output.ChunksFailed > 0 {
+			entry.WithField("errors", output.Errors).Warn("Join: COMPLETED WITH FAILED CHUNKS")
+		} else {
+			entry.Info("Join: COMPLETED")
+		}
+		return output, nil
+	}
+}
+
+func joinChunkOutcomes(fanOut *FanOutOutput) *JoinOutput {
+	output := &JoinOutput{
+		DocumentID: fanOut.DocumentID,
+		Strategy:   fanOut.Strategy,
+		ChunkCount: len(fanOut.Chunks),
+		Artifacts:  []string{},
+	}
+
+	for _, outcome := range fanOut.Chunks {
+		if outcome.Result == nil || outcome.Result.Error != nil {
+			output.ChunksFailed++
+			output.Errors = append(output.Errors,
+				fmt.Sprintf("chunk %d (pages %d-%d): %s", outcome.ChunkIndex, outcome.StartPage, outcome.EndPage, outcome.Error))
+			continue
+		}
+
+		result := outcome.Result
+		output.ChunksSucceeded++
+		output.PagesExtracted += result.PagesExtracted
+		output.TableCount += result.TableCount
+		if result.ImageExtraction != nil {
+			output.ImagesWritten += result.ImageExtraction.ImagesWritten
+		}
+		if result.Entities != nil {
+			if output.EntityCounts == nil {
+				output.EntityCounts = make(map[string]int)
+			}
+			for entityType, count := range result.Entities.Counts {
+				output.EntityCounts[entityType] += count
+			}
+			output.PIICount += result.Entities.PIICount
+		}
+		if result.Extraction != nil {
+			output.Artifacts = append(output.Artifacts, result.Extraction.Location)
+		}
+	}
+	return output
+}
+
+// Child workflow step extracting a single chunk
+func analyzeChunkStep(pdfAnalyzer *PDFAnalyzer) func(ctx worker.HatchetContext, input *ChunkInput) (*ChunkResult, error) {
+	return func(ctx worker.HatchetContext, input *ChunkInput) (*ChunkResult, error) {
+		result, err := pdfAnalyzer.AnalyzeChunk(ctx, *input)
+		if err != nil {
+			// Report permanent failures instead of letting Hatchet retry them
+			if IsPermanentError(err) {
+				pdfAnalyzer.logger.WithError(err).Warn("Chunk analysis failed permanently, not retrying")
+				return &ChunkResult{
+					DocumentID: input.DocumentID,
+					ChunkIndex: input.Chunk.Index,
+					StartPage:  input.Chunk.StartPage,
+					EndPage:    input.Chunk.EndPage,
+					Error:      newAnalysisError(err),
+				}, nil
+			}
+
+			pdfAnalyzer.logger.WithError(err).Error("Chunk analysis failed")
+			return nil, fmt.Errorf("chunk analysis failed: %w", err)
+		}
+		return result, nil
+	}
+}
@@ -2,7 +2,10 @@ module dag-demo
 
 go 1.24.0
 
-require github.com/hatchet-dev/hatchet v0.73.74
+require (
+	github.com/hatchet-dev/hatchet v0.73.74
+	github.com/joho/godotenv v1.5.1
+)
 
 require (
 	cel.dev/expr v0.24.0 // indirect
@@ -27,7 +30,6 @@ require (
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/pgx/v5 v5.7.6 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/labstack/echo/v4 v4.13.4 // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
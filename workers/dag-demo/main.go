@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/hatchet-dev/hatchet/pkg/client"
@@ -16,6 +20,16 @@ import (
 type DocumentInput struct {
 	DocumentID string `json:"document_id"`
 	FilePath   string `json:"file_path"`
+	// Set by the analyzer, the same document and content always carry the same key
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Claim status of an event whose key was claimed before, later stages skip too
+const ClaimSkipped = "skipped"
+
+type ClaimOutput struct {
+	Status  string `json:"status"`
+	Claimed bool   `json:"claimed"`
 }
 
 type UploadOutput struct {
@@ -114,73 +128,90 @@ func main() {
 		&worker.WorkflowJob{
 			On:   worker.Events("document:process"),
 			Name: "document-processing-pipeline",
+			// A failed run releases its claim, the event may be sent again
+			OnFailure: &worker.WorkflowJob{
+				Name: "document-processing-pipeline-on-failure",
+				Steps: []*worker.WorkflowStep{
+					{
+						Name:     "release-claim",
+						Function: releaseClaimStep,
+					},
+				},
+			},
 			Steps: []*worker.WorkflowStep{
+				// Stage 0: Claim the idempotency key, a re-sent event skips every
+				// later stage, which all read the claim
+				{
+					Name:     "claim",
+					Function: claimStep,
+				},
 				// Stage 1: Upload
 				{
 					Name:     "upload",
-					Function: uploadStep,
+					Function: unlessDuplicate(uploadStep, &UploadOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim"},
 				},
 				// Stage 2: Validate
 				{
 					Name:     "validate",
-					Function: validateStep,
-					Parents:  []string{"upload"},
+					Function: unlessDuplicate(validateStep, &ValidateOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "upload"},
 				},
 				// Stage 3: Extract
 				{
 					Name:     "extract",
-					Function: extractStep,
-					Parents:  []string{"validate"},
+					Function: unlessDuplicate(extractStep, &ExtractOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "validate"},
 				},
 				// Stage 4: Parse operations (parallel)
 				{
 					Name:     "parse-text",
-					Function: parseTextStep,
-					Parents:  []string{"extract"},
+					Function: unlessDuplicate(parseTextStep, &ParseOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "extract"},
 				},
 				{
 					Name:     "parse-images",
-					Function: parseImagesStep,
-					Parents:  []string{"extract"},
+					Function: unlessDuplicate(parseImagesStep, &ParseOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "extract"},
 				},
 				{
 					Name:     "parse-tables",
-					Function: parseTablesStep,
-					Parents:  []string{"extract"},
+					Function: unlessDuplicate(parseTablesStep, &ParseOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "extract"},
 				},
 				// Stage 5: Transform
 				{
 					Name:     "transform",
-					Function: transformStep,
-					Parents:  []string{"parse-text", "parse-images", "parse-tables"},
+					Function: unlessDuplicate(transformStep, &TransformOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "parse-text", "parse-images", "parse-tables"},
 				},
 				// Stage 6: Storage operations (parallel)
 				{
 					Name:     "store-database",
-					Function: storeDatabaseStep,
-					Parents:  []string{"transform"},
+					Function: unlessDuplicate(storeDatabaseStep, &StorageOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "transform"},
 				},
 				{
 					Name:     "store-s3",
-					Function: storeS3Step,
-					Parents:  []string{"transform"},
+					Function: unlessDuplicate(storeS3Step, &StorageOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "transform"},
 				},
 				{
 					Name:     "index-search",
-					Function: indexSearchStep,
-					Parents:  []string{"transform"},
+					Function: unlessDuplicate(indexSearchStep, &StorageOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "transform"},
 				},
 				// Stage 7: Notify
 				{
 					Name:     "notify",
-					Function: notifyStep,
-					Parents:  []string{"store-database", "store-s3", "index-search"},
+					Function: unlessDuplicate(notifyStep, &NotifyOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "store-database", "store-s3", "index-search"},
 				},
 				// Stage 8: Cleanup
 				{
 					Name:     "cleanup",
-					Function: cleanupStep,
-					Parents:  []string{"notify"},
+					Function: unlessDuplicate(cleanupStep, &CleanupOutput{Status: ClaimSkipped}),
+					Parents:  []string{"claim", "notify"},
 				},
 			},
 		},
@@ -210,6 +241,146 @@ func main() {
 
 // ==================== WORKFLOW STEPS ====================
 
+// Stage 0: Claim
+// Hatchet runs the pipeline for every event it receives, so a document the
+// analyzer sends again (a retried push, a re-triggered analysis) is stopped
+// here: the claim completes as skipped and every later stage with it. A run
+// that fails releases its claim in releaseClaimStep, so the next event retries.
+func claimStep(ctx worker.HatchetContext, input *DocumentInput) (*ClaimOutput, error) {
+	captureEvent("STEP_STARTED", "claim", input)
+	fmt.Println("🔑 [CLAIM] Claiming idempotency key...")
+
+	// Events sent without a key always run
+	if input.IdempotencyKey == "" {
+		fmt.Println("   ✓ No idempotency key, processing")
+		result := &ClaimOutput{Status: "completed"}
+		captureEvent("STEP_COMPLETED", "claim", result)
+		return result, nil
+	}
+
+	err := claimIdempotencyKey(input.IdempotencyKey, ctx.WorkflowRunId())
+	if errors.Is(err, os.ErrExist) {
+		fmt.Printf("   ✓ Already processed or in progress: %s, skipping\n", input.IdempotencyKey)
+		result := &ClaimOutput{Status: ClaimSkipped}
+		captureEvent("STEP_COMPLETED", "claim", result)
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not claim idempotency key: %w", err)
+	}
+
+	result := &ClaimOutput{Status: "completed", Claimed: true}
+	fmt.Println("   ✓ Key claimed")
+	captureEvent("STEP_COMPLETED", "claim", result)
+	return result, nil
+}
+
+// Wraps a later stage so it completes with the skipped output, without doing
+// its work, when the claim found the event already handled
+func unlessDuplicate[T any](step func(ctx context.Context, input *DocumentInput) (*T, error), skipped *T) func(ctx worker.HatchetContext, input *DocumentInput) (*T, error) {
+	return func(ctx worker.HatchetContext, input *DocumentInput) (*T, error) {
+		claim := &ClaimOutput{}
+		if err := ctx.StepOutput("claim", claim); err != nil {
+			return nil, fmt.Errorf("could not read claim output: %w", err)
+		}
+		if claim.Status == ClaimSkipped {
+			return skipped, nil
+		}
+		return step(ctx, input)
+	}
+}
+
+// On failure: release the key claimed by the failed run, so the document is
+// processed when the event is sent again. Keys claimed by another run stay.
+func releaseClaimStep(ctx worker.HatchetContext) (*ClaimOutput, error) {
+	input := &DocumentInput{}
+	if err := ctx.WorkflowInput(input); err != nil {
+		return nil, fmt.Errorf("could not read workflow input: %w", err)
+	}
+	if input.IdempotencyKey == "" {
+		return &ClaimOutput{Status: "completed"}, nil
+	}
+
+	released, err := releaseIdempotencyKey(input.IdempotencyKey, ctx.WorkflowRunId())
+	if err != nil {
+		return nil, fmt.Errorf("could not release idempotency key: %w", err)
+	}
+	if !released {
+		return &ClaimOutput{Status: "completed"}, nil
+	}
+	fmt.Printf("🔓 [RELEASE] Released idempotency key %s after a failed run\n", input.IdempotencyKey)
+	return &ClaimOutput{Status: "released"}, nil
+}
+
+// Directory with a file per claimed key, from PIPELINE_IDEMPOTENCY_DIR. Workers
+// sharing the pipeline need to share the directory; removing a key's file lets
+// its document run again.
+func idempotencyDir() string {
+	if dir := os.Getenv("PIPELINE_IDEMPOTENCY_DIR"); dir != "" {
+		return dir
+	}
+	return "processed-keys"
+}
+
+// Create the key's file holding the claiming run's ID, failing with
+// os.ErrExist when it was claimed before. O_EXCL makes the claim atomic, two
+// runs of the same event can't both win. A claim whose file could not be
+// written is removed again, it never counts as claimed.
+func claimIdempotencyKey(key, runID string) error {
+	path, err := idempotencyKeyPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(runID + " " + time.Now().Format(time.RFC3339) + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// Remove the key's file when runID holds the claim, reporting whether it did
+func releaseIdempotencyKey(key, runID string) (bool, error) {
+	path, err := idempotencyKeyPath(key)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if owner, _, _ := strings.Cut(string(data), " "); owner != runID {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, nil
+}
+
+func idempotencyKeyPath(key string) (string, error) {
+	// Keys are hex digests, anything else must not name a path
+	if _, err := hex.DecodeString(key); err != nil || key == "" {
+		return "", fmt.Errorf("invalid idempotency key %q", key)
+	}
+	return filepath.Join(idempotencyDir(), key), nil
+}
+
 // Stage 1: Upload
 func uploadStep(ctx context.Context, input *DocumentInput) (*UploadOutput, error) {
 	captureEvent("STEP_STARTED", "upload", input)
@@ -428,7 +599,8 @@ func cleanupStep(ctx context.Context, input *DocumentInput) (*CleanupOutput, err
 	}
 
 	fmt.Println("   ✓ Cleanup complete")
-	fmt.Println("\n🎉 Document processing pipeline finished!\n")
+	fmt.Println("\n🎉 Document processing pipeline finished!")
+	fmt.Println()
 	captureEvent("STEP_COMPLETED", "cleanup", result)
 	return result, nil
 }
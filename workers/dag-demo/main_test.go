@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hatchet-dev/hatchet/pkg/worker"
+)
+
+// Hatchet context of one pipeline run, serving the workflow input and the
+// outputs of the steps that already ran
+type testRun struct {
+	worker.HatchetContext
+	id      string
+	input   *DocumentInput
+	outputs map[string]any
+}
+
+func (r *testRun) WorkflowRunId() string { return r.id }
+
+func (r *testRun) WorkflowInput(target interface{}) error {
+	return roundTrip(r.input, target)
+}
+
+func (r *testRun) StepOutput(step string, target interface{}) error {
+	output, ok := r.outputs[step]
+	if !ok {
+		return fmt.Errorf("step %s has no output", step)
+	}
+	return roundTrip(output, target)
+}
+
+func roundTrip(value, target any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// Claims land in a fresh directory, captured events in a temp working directory
+func setupClaims(t *testing.T) string {
+	t.Helper()
+
+	t.Chdir(t.TempDir())
+	dir := filepath.Join(t.TempDir(), "keys")
+	t.Setenv("PIPELINE_IDEMPOTENCY_DIR", dir)
+	return dir
+}
+
+const testKey = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func TestClaimStep(t *testing.T) {
+	dir := setupClaims(t)
+	input := &DocumentInput{DocumentID: "doc-1", IdempotencyKey: testKey}
+
+	first, err := claimStep(&testRun{id: "run-1"}, input)
+	if err != nil || first.Status != "completed" || !first.Claimed {
+		t.Fatalf("first claim = %+v, %v", first, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, testKey))
+	if err != nil || !strings.HasPrefix(string(data), "run-1 ") {
+		t.Errorf("key file holds %q, %v, want the claiming run", data, err)
+	}
+
+	// The same event sent again completes as skipped instead of failing
+	again, err := claimStep(&testRun{id: "run-2"}, input)
+	if err != nil || again.Status != ClaimSkipped || again.Claimed {
+		t.Errorf("second claim = %+v, %v, want skipped", again, err)
+	}
+
+	// Events without a key always run, malformed keys never name a path
+	if result, err := claimStep(&testRun{id: "run-3"}, &DocumentInput{DocumentID: "doc-2"}); err != nil || result.Status != "completed" || result.Claimed {
+		t.Errorf("claim without a key = %+v, %v", result, err)
+	}
+	if _, err := claimStep(&testRun{id: "run-4"}, &DocumentInput{IdempotencyKey: "../escape"}); err == nil {
+		t.Error("malformed key claimed")
+	}
+}
+
+func TestReleaseClaim(t *testing.T) {
+	setupClaims(t)
+	input := &DocumentInput{DocumentID: "doc-1", IdempotencyKey: testKey}
+	if _, err := claimStep(&testRun{id: "run-1"}, input); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed duplicate leaves the claim of the run processing the document
+	if result, err := releaseClaimStep(&testRun{id: "run-2", input: input}); err != nil || result.Status != "completed" {
+		t.Errorf("release by another run = %+v, %v", result, err)
+	}
+	if result, _ := claimStep(&testRun{id: "run-3"}, input); result.Status != ClaimSkipped {
+		t.Fatalf("claim after another run's failure = %+v, want skipped", result)
+	}
+
+	// Once the claiming run fails, the next event processes the document
+	if result, err := releaseClaimStep(&testRun{id: "run-1", input: input}); err != nil || result.Status != "released" {
+		t.Errorf("release by the claiming run = %+v, %v", result, err)
+	}
+	if result, err := claimStep(&testRun{id: "run-4"}, input); err != nil || !result.Claimed {
+		t.Errorf("claim after the release = %+v, %v", result, err)
+	}
+
+	// Nothing to release for events without a key or keys never claimed
+	if released, err := releaseIdempotencyKey(strings.Repeat("ab", 32), "run-1"); released || err != nil {
+		t.Errorf("releaseIdempotencyKey() = %v, %v for an unclaimed key", released, err)
+	}
+	if result, err := releaseClaimStep(&testRun{id: "run-5", input: &DocumentInput{DocumentID: "doc-2"}}); err != nil || result.Status != "completed" {
+		t.Errorf("release without a key = %+v, %v", result, err)
+	}
+}
+
+func TestClaimUnwritableDirectory(t *testing.T) {
+	dir := setupClaims(t)
+	if err := os.WriteFile(dir, []byte("not a directory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A claim that could not be recorded fails the run rather than claiming
+	if result, err := claimStep(&testRun{id: "run-1"}, &DocumentInput{IdempotencyKey: testKey}); err == nil {
+		t.Errorf("claim = %+v without a key directory", result)
+	}
+}
+
+func TestUnlessDuplicate(t *testing.T) {
+	setupClaims(t)
+	calls := 0
+	step := unlessDuplicate(func(ctx context.Context, input *DocumentInput) (*StorageOutput, error) {
+		calls++
+		return &StorageOutput{Status: "completed", RecordID: "doc_" + input.DocumentID}, nil
+	}, &StorageOutput{Status: ClaimSkipped})
+	input := &DocumentInput{DocumentID: "doc-1"}
+
+	tests := []struct {
+		name   string
+		claim  any
+		status string
+		calls  int
+	}{
+		{"claimed", &ClaimOutput{Status: "completed", Claimed: true}, "completed", 1},
+		{"no idempotency key", &ClaimOutput{Status: "completed"}, "completed", 2},
+		{"duplicate event", &ClaimOutput{Status: ClaimSkipped}, ClaimSkipped, 2},
+	}
+	for _, tt := range tests {
+		run := &testRun{id: "run-1", outputs: map[string]any{"claim": tt.claim}}
+		result, err := step(run, input)
+		if err != nil || result.Status != tt.status || calls != tt.calls {
+			t.Errorf("%s: %+v, %v after %d calls, want %s after %d", tt.name, result, err, calls, tt.status, tt.calls)
+		}
+	}
+
+	if _, err := step(&testRun{id: "run-2"}, input); err == nil {
+		t.Error("step ran without a claim output")
+	}
+}